@@ -0,0 +1,355 @@
+package gato
+
+import (
+	"errors"
+	"image"
+)
+
+var (
+	ErrInvalidWhiteBalance = errors.New("invalid white balance method: only gray-world and white-patch are available")
+	ErrInvalidPercentile   = errors.New("invalid percentile: percentiles must be between 0 and 100, and the low one must be less than the high one")
+)
+
+// WhiteBalanceMethod is the way WhiteBalance estimates the color cast of an image.
+type WhiteBalanceMethod string
+
+const (
+	// GrayWorld assumes that the average color of the image is gray.
+	GrayWorld WhiteBalanceMethod = "gray-world"
+	// WhitePatch assumes that the brightest colors of the image are white.
+	WhitePatch WhiteBalanceMethod = "white-patch"
+)
+
+// Correction is a correction that an automatic operation measured on an image and applied to it,
+// expressed as the operation applying it, e.g. a Recolor, so that it can be logged, stored as JSON, or applied to other images.
+type Correction struct {
+	// Step is the index of the automatic operation in the pipeline.
+	Step      int       `json:"step"`
+	Operation Operation `json:"operation"`
+}
+
+// gainMatrix returns the color matrix that scales red, green and blue by gains, and adds offsets to them.
+func gainMatrix(gains, offsets [3]float64) ColorMatrix {
+	return ColorMatrix{
+		gains[0], 0, 0, 0, offsets[0],
+		0, gains[1], 0, 0, offsets[1],
+		0, 0, gains[2], 0, offsets[2],
+		0, 0, 0, 1, 0,
+	}
+}
+
+// percentile returns the least value such that at least p percent of the values counted by hist are less than or equal to it.
+func percentile(hist *[256]int, p float64) uint8 {
+	total := 0
+	for _, n := range hist {
+		total += n
+	}
+	target := p / 100 * float64(total)
+	sum := 0
+	for v, n := range hist {
+		sum += n
+		if sum > 0 && float64(sum) >= target {
+			return uint8(v)
+		}
+	}
+	return 255
+}
+
+// mean returns the mean of the values counted by hist, and 0 if it counts none.
+func mean(hist *[256]int) float64 {
+	sum, total := 0, 0
+	for v, n := range hist {
+		sum += v * n
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(sum) / float64(total)
+}
+
+// validatePercentiles reports whether low and high are a valid percentile range, high being 100 when it is not set.
+func validatePercentiles(low, high float64) error {
+	if high == 0 {
+		high = 100
+	}
+	if !(high > 0 && high <= 100) {
+		return fieldError("high", ErrInvalidPercentile)
+	}
+	if !(low >= 0 && low < high) {
+		return fieldError("low", ErrInvalidPercentile)
+	}
+	return nil
+}
+
+// channelHistograms returns the histograms of the red, green and blue channels of src.
+func channelHistograms(s *scratch, src *image.RGBA) ([3]*[256]int, error) {
+	// the luminances are not needed, so they are all counted as black
+	hist, err := histogram(s, src, [3]float64{})
+	if err != nil {
+		return [3]*[256]int{}, err
+	}
+	return [3]*[256]int{&hist.Red, &hist.Green, &hist.Blue}, nil
+}
+
+// WhiteBalance is an operation that removes the color cast of an image by scaling its channels,
+// e.g. the yellow cast of product photos taken under tungsten light.
+// The gains it applies are reported as a Recolor by Correction and Processor.ProcessReport.
+type WhiteBalance struct {
+	// Method is the way the color cast is estimated. If it is not set, it defaults to GrayWorld.
+	Method WhiteBalanceMethod `json:"method,omitempty"`
+	// Percentile is the percentile of every channel that WhitePatch takes as white, from 0 to 100,
+	// so that a few blown out pixels do not decide the balance. If it is not set, it defaults to 99.
+	Percentile float64 `json:"percentile,omitempty"`
+}
+
+func (wb WhiteBalance) kind() string {
+	return "white_balance"
+}
+
+func (wb WhiteBalance) validate() error {
+	switch wb.Method {
+	case "", GrayWorld, WhitePatch:
+		// do nothing
+	default:
+		return fieldError("method", ErrInvalidWhiteBalance)
+	}
+	if !(wb.Percentile >= 0 && wb.Percentile <= 100) {
+		return fieldError("percentile", ErrInvalidPercentile)
+	}
+	return nil
+}
+
+// Correction returns the Recolor that balances src, without applying it.
+func (wb WhiteBalance) Correction(src *image.RGBA) (Recolor, error) {
+	if err := wb.validate(); err != nil {
+		return Recolor{}, err
+	}
+	return wb.correction(&scratch{}, src)
+}
+
+func (wb WhiteBalance) correction(s *scratch, src *image.RGBA) (Recolor, error) {
+	channels, err := channelHistograms(s, src)
+	if err != nil {
+		return Recolor{}, err
+	}
+
+	// ref is the color that should be neutral, and target the gray it should become
+	var ref [3]float64
+	var target float64
+	if wb.Method == WhitePatch {
+		p := wb.Percentile
+		if p == 0 {
+			p = 99
+		}
+		for k, c := range channels {
+			ref[k] = float64(percentile(c, p))
+		}
+		target = 255
+	} else {
+		for k, c := range channels {
+			ref[k] = mean(c)
+		}
+		target = (ref[0] + ref[1] + ref[2]) / 3
+	}
+
+	gains := [3]float64{1, 1, 1}
+	for k, r := range ref {
+		// channels with nothing to scale are left as they are
+		if r > 0 && target > 0 {
+			gains[k] = target / r
+		}
+	}
+	return Recolor{Matrix: gainMatrix(gains, [3]float64{})}, nil
+}
+
+func (wb WhiteBalance) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	rc, err := wb.correction(s, src)
+	if err != nil {
+		return nil, err
+	}
+	s.corrected(rc)
+	return rc.apply(s, src)
+}
+
+// Apply returns a new image of the source balanced following the operation.
+func (wb WhiteBalance) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := wb.validate(); err != nil {
+		return nil, err
+	}
+	return wb.apply(&scratch{}, src)
+}
+
+func (wb WhiteBalance) MarshalJSON() ([]byte, error) {
+	type alias WhiteBalance
+	return marshalOperation(wb.kind(), alias(wb))
+}
+
+func (wb *WhiteBalance) UnmarshalJSON(data []byte) error {
+	type alias WhiteBalance
+	v := struct {
+		Op string `json:"op"`
+		*alias
+	}{alias: (*alias)(wb)}
+	return decodeStrict(data, &v)
+}
+
+// AutoLevels is an operation that stretches every channel of an image on its own, so that its Low percentile becomes 0
+// and its High percentile 255. As the channels are stretched apart, it also removes color casts.
+// The stretch it applies is reported as a Recolor by Correction and Processor.ProcessReport.
+type AutoLevels struct {
+	// Low is the percentile, from 0 to 100, of the values of a channel that are mapped to 0 and below, e.g. 0.5.
+	Low float64 `json:"low,omitempty"`
+	// High is the percentile, from 0 to 100, of the values of a channel that are mapped to 255 and above, e.g. 99.5.
+	// If it is not set, it defaults to 100.
+	High float64 `json:"high,omitempty"`
+}
+
+func (al AutoLevels) kind() string {
+	return "auto_levels"
+}
+
+func (al AutoLevels) validate() error {
+	return validatePercentiles(al.Low, al.High)
+}
+
+// Correction returns the Recolor that stretches the channels of src, without applying it.
+func (al AutoLevels) Correction(src *image.RGBA) (Recolor, error) {
+	if err := al.validate(); err != nil {
+		return Recolor{}, err
+	}
+	return al.correction(&scratch{}, src)
+}
+
+func (al AutoLevels) correction(s *scratch, src *image.RGBA) (Recolor, error) {
+	channels, err := channelHistograms(s, src)
+	if err != nil {
+		return Recolor{}, err
+	}
+	high := al.High
+	if high == 0 {
+		high = 100
+	}
+
+	gains, offsets := [3]float64{1, 1, 1}, [3]float64{}
+	for k, c := range channels {
+		lo, hi := float64(percentile(c, al.Low)), float64(percentile(c, high))
+		// flat channels are left as they are
+		if lo < hi {
+			gains[k] = 255 / (hi - lo)
+			offsets[k] = -lo / (hi - lo)
+		}
+	}
+	return Recolor{Matrix: gainMatrix(gains, offsets)}, nil
+}
+
+func (al AutoLevels) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	rc, err := al.correction(s, src)
+	if err != nil {
+		return nil, err
+	}
+	s.corrected(rc)
+	return rc.apply(s, src)
+}
+
+// Apply returns a new image of the source stretched following the operation.
+func (al AutoLevels) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := al.validate(); err != nil {
+		return nil, err
+	}
+	return al.apply(&scratch{}, src)
+}
+
+func (al AutoLevels) MarshalJSON() ([]byte, error) {
+	type alias AutoLevels
+	return marshalOperation(al.kind(), alias(al))
+}
+
+func (al *AutoLevels) UnmarshalJSON(data []byte) error {
+	type alias AutoLevels
+	v := struct {
+		Op string `json:"op"`
+		*alias
+	}{alias: (*alias)(al)}
+	return decodeStrict(data, &v)
+}
+
+// AutoContrast is an operation that stretches the values of an image over the whole range, as AutoLevels does,
+// but with the same stretch for every channel, so that colors keep their hue.
+// The stretch it applies is reported as a Levels by Correction and Processor.ProcessReport.
+type AutoContrast struct {
+	// Low is the percentile, from 0 to 100, of the values of the darkest channel that are mapped to 0 and below.
+	Low float64 `json:"low,omitempty"`
+	// High is the percentile, from 0 to 100, of the values of the brightest channel that are mapped to 255 and above.
+	// If it is not set, it defaults to 100.
+	High float64 `json:"high,omitempty"`
+}
+
+func (ac AutoContrast) kind() string {
+	return "auto_contrast"
+}
+
+func (ac AutoContrast) validate() error {
+	return validatePercentiles(ac.Low, ac.High)
+}
+
+// Correction returns the Levels that stretch the values of src, without applying it.
+func (ac AutoContrast) Correction(src *image.RGBA) (Levels, error) {
+	if err := ac.validate(); err != nil {
+		return Levels{}, err
+	}
+	return ac.correction(&scratch{}, src)
+}
+
+func (ac AutoContrast) correction(s *scratch, src *image.RGBA) (Levels, error) {
+	channels, err := channelHistograms(s, src)
+	if err != nil {
+		return Levels{}, err
+	}
+	high := ac.High
+	if high == 0 {
+		high = 100
+	}
+
+	lo, hi := uint8(255), uint8(0)
+	for _, c := range channels {
+		lo = min(lo, percentile(c, ac.Low))
+		hi = max(hi, percentile(c, high))
+	}
+	// flat images are left as they are
+	if lo >= hi {
+		return Levels{InWhite: 255}, nil
+	}
+	return Levels{InBlack: lo, InWhite: hi}, nil
+}
+
+func (ac AutoContrast) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	l, err := ac.correction(s, src)
+	if err != nil {
+		return nil, err
+	}
+	s.corrected(l)
+	return l.apply(s, src)
+}
+
+// Apply returns a new image of the source stretched following the operation.
+func (ac AutoContrast) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := ac.validate(); err != nil {
+		return nil, err
+	}
+	return ac.apply(&scratch{}, src)
+}
+
+func (ac AutoContrast) MarshalJSON() ([]byte, error) {
+	type alias AutoContrast
+	return marshalOperation(ac.kind(), alias(ac))
+}
+
+func (ac *AutoContrast) UnmarshalJSON(data []byte) error {
+	type alias AutoContrast
+	v := struct {
+		Op string `json:"op"`
+		*alias
+	}{alias: (*alias)(ac)}
+	return decodeStrict(data, &v)
+}
@@ -0,0 +1,123 @@
+package gato
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// newTwoColorImage returns an opaque image of size 2 x 1 whose pixels are a and b.
+func newTwoColorImage(a, b color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, a)
+	img.SetRGBA(1, 0, b)
+	return img
+}
+
+func TestWhiteBalance(t *testing.T) {
+	t.Run("neutralize the average color with gray world", func(t *testing.T) {
+		src := newTwoColorImage(color.RGBA{200, 150, 100, 255}, color.RGBA{100, 75, 50, 255})
+		rc, err := WhiteBalance{}.Correction(src)
+		assertError(t, err, nil)
+		assertColorMatrix(t, rc.Matrix, gainMatrix([3]float64{0.75, 1, 1.5}, [3]float64{}), 1e-9)
+
+		dst, err := WhiteBalance{Method: GrayWorld}.Apply(src)
+		assertError(t, err, nil)
+		if got := dst.RGBAAt(0, 0); got != (color.RGBA{150, 150, 150, 255}) {
+			t.Errorf("got %v, want gray", got)
+		}
+	})
+
+	t.Run("turn the brightest color white with white patch", func(t *testing.T) {
+		src := newTwoColorImage(color.RGBA{200, 220, 240, 255}, color.RGBA{120, 132, 144, 255})
+		dst, err := WhiteBalance{Method: WhitePatch, Percentile: 100}.Apply(src)
+		assertError(t, err, nil)
+		if got := dst.RGBAAt(0, 0); got != (color.RGBA{255, 255, 255, 255}) {
+			t.Errorf("got %v, want white", got)
+		}
+		if got := dst.RGBAAt(1, 0); got != (color.RGBA{153, 153, 153, 255}) {
+			t.Errorf("got %v, want gray", got)
+		}
+	})
+
+	t.Run("leave a transparent image as it is", func(t *testing.T) {
+		rc, err := WhiteBalance{}.Correction(image.NewRGBA(image.Rect(0, 0, 2, 2)))
+		assertError(t, err, nil)
+		assertColorMatrix(t, rc.Matrix, IdentityColorMatrix, 0)
+	})
+
+	t.Run("return error when the operation is invalid", func(t *testing.T) {
+		src := newGradientImage(2, 2)
+		_, err := WhiteBalance{Method: "retinex"}.Apply(src)
+		assertValidationError(t, err, -1, "method", ErrInvalidWhiteBalance)
+		_, err = WhiteBalance{Percentile: 101}.Correction(src)
+		assertValidationError(t, err, -1, "percentile", ErrInvalidPercentile)
+	})
+}
+
+func TestAutoLevels(t *testing.T) {
+	t.Run("stretch every channel on its own", func(t *testing.T) {
+		src := newTwoColorImage(color.RGBA{50, 0, 100, 255}, color.RGBA{150, 255, 200, 255})
+		rc, err := AutoLevels{}.Correction(src)
+		assertError(t, err, nil)
+		assertColorMatrix(t, rc.Matrix, gainMatrix([3]float64{2.55, 1, 2.55}, [3]float64{-0.5, 0, -1}), 1e-9)
+
+		dst, err := AutoLevels{}.Apply(src)
+		assertError(t, err, nil)
+		if got := dst.RGBAAt(0, 0); got != (color.RGBA{0, 0, 0, 255}) {
+			t.Errorf("got %v, want black", got)
+		}
+		if got := dst.RGBAAt(1, 0); got != (color.RGBA{255, 255, 255, 255}) {
+			t.Errorf("got %v, want white", got)
+		}
+	})
+
+	t.Run("clip the values beyond the percentiles", func(t *testing.T) {
+		src := newGrayImage(100, 1, func(x, y int) uint8 { return uint8(50 + x) })
+		src.SetRGBA(99, 0, color.RGBA{255, 255, 255, 255})
+		dst, err := AutoLevels{Low: 1, High: 98}.Apply(src)
+		assertError(t, err, nil)
+		assertInt(t, int(dst.RGBAAt(0, 0).R), 0)
+		assertInt(t, int(dst.RGBAAt(97, 0).R), 255)
+		// the outlier does not decide the stretch, which maps 50 to 147 onto the whole range
+		assertInt(t, int(dst.RGBAAt(50, 0).R), 131)
+	})
+
+	t.Run("return error when the percentiles are invalid", func(t *testing.T) {
+		src := newGradientImage(2, 2)
+		_, err := AutoLevels{Low: 60, High: 40}.Apply(src)
+		assertValidationError(t, err, -1, "low", ErrInvalidPercentile)
+		_, err = AutoLevels{High: 120}.Apply(src)
+		assertValidationError(t, err, -1, "high", ErrInvalidPercentile)
+	})
+}
+
+func TestAutoContrast(t *testing.T) {
+	t.Run("stretch every channel alike", func(t *testing.T) {
+		src := newTwoColorImage(color.RGBA{50, 80, 100, 255}, color.RGBA{150, 120, 200, 255})
+		l, err := AutoContrast{}.Correction(src)
+		assertError(t, err, nil)
+		if l != (Levels{InBlack: 50, InWhite: 200}) {
+			t.Errorf("got %+v, want levels from 50 to 200", l)
+		}
+		dst, err := AutoContrast{}.Apply(src)
+		assertError(t, err, nil)
+		if got := dst.RGBAAt(1, 0); got != (color.RGBA{170, 119, 255, 255}) {
+			t.Errorf("got %v", got)
+		}
+	})
+
+	t.Run("leave a flat image as it is", func(t *testing.T) {
+		src := newGrayImage(4, 4, func(x, y int) uint8 { return 90 })
+		l, err := AutoContrast{}.Correction(src)
+		assertError(t, err, nil)
+		if l != (Levels{InWhite: 255}) {
+			t.Errorf("got %+v, want identity levels", l)
+		}
+	})
+
+	t.Run("return error when the percentiles are invalid", func(t *testing.T) {
+		_, err := AutoContrast{Low: -1}.Apply(newGradientImage(2, 2))
+		assertValidationError(t, err, -1, "low", ErrInvalidPercentile)
+	})
+}
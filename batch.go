@@ -0,0 +1,470 @@
+package gato
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultTemplate is the name template of batch outputs when Batch.Template is not set.
+const DefaultTemplate = "{name}_{w}x{h}.{ext}"
+
+// manifestName is the name of the file recording the outputs of a Batch in its output directory.
+const manifestName = ".gato-batch.json"
+
+var ErrInvalidTemplate = errors.New("invalid template: only {name}, {variant}, {w}, {h} and {ext} are available")
+
+// Variant is one output of every file of a Batch.
+type Variant struct {
+	// Name is substituted for {variant} in the name template, e.g. "thumbnail".
+	Name string
+	// Instruction is the processing of the variant.
+	Instruction Instruction
+	// Output is the encoding of the variant. If Output.Format is not set, the format of the source image is kept.
+	Output Output
+}
+
+// Batch processes the image files of a file system into an output directory, with one output per file and Variant.
+// The output directory mirrors the tree of the source, and outputs are named following Template.
+//
+// The outputs of a Batch are recorded in a manifest in the output directory.
+// An output is up to date, and skipped, when the source file, the Variant and the template are the same as the ones it was made from,
+// so that running a Batch again only processes the files and variants that changed.
+type Batch struct {
+	// Source is the file system that the original images are read from, e.g. os.DirFS("images").
+	Source fs.FS
+	// Patterns are the glob patterns of the files to process, as accepted by path.Match.
+	// A pattern without a slash is matched against the base name of files, e.g. *.jpg, and one with a slash against their whole path, e.g. photos/*.jpg.
+	// If it is not set, every jpg/jpeg and png file is processed. Files of other formats are always ignored.
+	Patterns []string
+	// Variants are the outputs made from every file.
+	Variants []Variant
+	// Output is the directory that the outputs are written to. It is created if it does not exist, and it should not be inside Source.
+	Output string
+	// Template is the name of outputs, in which {name} is the name of the source file without its extension, {variant} is Variant.Name,
+	// {w} and {h} are the dimensions of the output image, and {ext} is the extension of its format.
+	// If it is not set, it defaults to DefaultTemplate.
+	Template string
+	// Workers is the number of files processed at the same time. If it is not greater than 0, it defaults to runtime.NumCPU().
+	Workers int
+	// Pool runs the rows of the processed images. If it is not set, DefaultPool() is used.
+	Pool *Pool
+	// Progress is called after every output is written, skipped or failed. Calls are never concurrent.
+	Progress func(Progress)
+}
+
+// Progress describes one output of a Batch that is done.
+type Progress struct {
+	// Path is the path of the source file in Batch.Source.
+	Path string
+	// Variant is the name of the Variant.
+	Variant string
+	// Output is the path of the output file. It is empty when Err is set.
+	Output string
+	// Skipped reports whether the output was up to date.
+	Skipped bool
+	// Err is the error that the output failed with.
+	Err error
+	// Done and Total are the numbers of outputs that are done and to do in the whole batch.
+	Done  int
+	Total int
+}
+
+// BatchResult sums up a run of a Batch.
+type BatchResult struct {
+	// Processed and Skipped are the numbers of outputs that were written and that were up to date.
+	Processed int
+	Skipped   int
+	// Errors are the outputs that failed. They do not stop the other outputs.
+	Errors []*FileError
+}
+
+// FileError is the error of one output of a Batch.
+type FileError struct {
+	Path    string
+	Variant string
+	Err     error
+}
+
+func (e *FileError) Error() string {
+	if e.Variant == "" {
+		return e.Path + ": " + e.Err.Error()
+	}
+	return e.Path + " (" + e.Variant + "): " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// batchRun is the state of a single run of a Batch.
+type batchRun struct {
+	*Batch
+	ctx        context.Context
+	processors []*Processor
+
+	mu       sync.Mutex
+	manifest map[string]string
+	result   BatchResult
+	done     int
+	total    int
+}
+
+// Run processes the files of b.
+// Errors of single files are collected in BatchResult.Errors, and Run only returns an error when the batch itself cannot run,
+// e.g. for an invalid Variant or pattern, or when ctx is done.
+func (b *Batch) Run(ctx context.Context) (BatchResult, error) {
+	r := &batchRun{Batch: b, ctx: ctx}
+	if err := r.validate(); err != nil {
+		return BatchResult{}, err
+	}
+
+	var files []string
+	err := fs.WalkDir(b.Source, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !b.match(p) {
+			return nil
+		}
+		if _, _, err := splitFileName(path.Base(p)); err != nil {
+			return nil
+		}
+		files = append(files, p)
+		return nil
+	})
+	if err != nil {
+		return BatchResult{}, err
+	}
+
+	if err := os.MkdirAll(b.Output, 0o755); err != nil {
+		return BatchResult{}, err
+	}
+	r.manifest = readManifest(filepath.Join(b.Output, manifestName))
+	r.total = len(files) * len(b.Variants)
+
+	workers := b.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				r.processFile(p)
+			}
+		}()
+	}
+	for _, p := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- p
+	}
+	close(queue)
+	wg.Wait()
+
+	// the outputs written so far are kept even if the batch was cancelled
+	if err := writeManifest(filepath.Join(b.Output, manifestName), r.manifest); err != nil {
+		return r.result, err
+	}
+	return r.result, ctx.Err()
+}
+
+// validate reports whether the patterns, the template and the variants of r are well-formed, and creates the processors of the variants.
+func (r *batchRun) validate() error {
+	for _, pattern := range r.Patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: %q", err, pattern)
+		}
+	}
+	if _, err := expandTemplate(r.template(), map[string]string{}); err != nil {
+		return err
+	}
+	for _, v := range r.Variants {
+		prc, err := NewProcessor(v.Instruction)
+		if err != nil {
+			return fmt.Errorf("variant %q: %w", v.Name, err)
+		}
+		if v.Output.Format != "" {
+			if err := v.Output.validate(); err != nil {
+				return fmt.Errorf("variant %q: %w", v.Name, err)
+			}
+		}
+		prc.Pool = r.Pool
+		r.processors = append(r.processors, prc)
+	}
+	return nil
+}
+
+func (b *Batch) template() string {
+	if b.Template == "" {
+		return DefaultTemplate
+	}
+	return b.Template
+}
+
+// match reports whether the file at p matches one of the patterns of b.
+func (b *Batch) match(p string) bool {
+	if len(b.Patterns) == 0 {
+		return true
+	}
+	for _, pattern := range b.Patterns {
+		name := p
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(p)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// processFile makes the outputs of the file at p that are not up to date, decoding it at most once.
+func (r *batchRun) processFile(p string) {
+	info, err := fs.Stat(r.Source, p)
+	if err != nil {
+		r.failAll(p, err)
+		return
+	}
+
+	keys := make([]string, len(r.Variants))
+	var pending []int
+	for i, v := range r.Variants {
+		// the template is part of the identity, so that renamed outputs are made again
+		tmpl := strings.ReplaceAll(r.template(), "{variant}", v.Name)
+		source := fmt.Sprintf("%s\x00%d\x00%d\x00%s", p, info.Size(), info.ModTime().UnixNano(), tmpl)
+		if keys[i], err = CacheKey(source, v.Instruction, v.Output); err != nil {
+			r.report(Progress{Path: p, Variant: v.Name, Err: err})
+			continue
+		}
+		if out, ok := r.upToDate(keys[i]); ok {
+			r.report(Progress{Path: p, Variant: v.Name, Output: out, Skipped: true})
+			continue
+		}
+		pending = append(pending, i)
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	f, err := r.Source.Open(p)
+	if err != nil {
+		r.fail(p, pending, err)
+		return
+	}
+	d, err := NewDataContext(r.ctx, path.Base(p), f)
+	f.Close()
+	if err != nil {
+		r.fail(p, pending, err)
+		return
+	}
+	defer d.Release()
+
+	for _, i := range pending {
+		out, err := r.processVariant(p, d, i)
+		if err != nil {
+			r.report(Progress{Path: p, Variant: r.Variants[i].Name, Err: err})
+			continue
+		}
+		r.mu.Lock()
+		r.manifest[keys[i]] = filepath.ToSlash(out)
+		r.mu.Unlock()
+		r.report(Progress{Path: p, Variant: r.Variants[i].Name, Output: filepath.Join(r.Output, out)})
+	}
+}
+
+// processVariant writes the output of the i-th variant of the image d read from p, and returns its path relative to the output directory.
+func (r *batchRun) processVariant(p string, d *Data, i int) (string, error) {
+	v := r.Variants[i]
+	img, err := r.processors[i].ProcessContext(r.ctx, d)
+	if err != nil {
+		return "", err
+	}
+	defer putBuffer(img)
+
+	o := v.Output
+	if o.Format == "" {
+		o.Format = d.Format
+	}
+	b := new(bytes.Buffer)
+	if err := o.Encode(b, img); err != nil {
+		return "", err
+	}
+
+	ext := strings.TrimPrefix(path.Ext(p), ".")
+	if o.Format != d.Format {
+		ext = o.Format
+		if ext == FormatJPEG {
+			ext = "jpg"
+		}
+	}
+	name, err := expandTemplate(r.template(), map[string]string{
+		"name":    d.Name,
+		"variant": v.Name,
+		"w":       strconv.Itoa(img.Bounds().Dx()),
+		"h":       strconv.Itoa(img.Bounds().Dy()),
+		"ext":     ext,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	out := filepath.Join(filepath.FromSlash(path.Dir(p)), name)
+	if err := writeFile(filepath.Join(r.Output, out), b.Bytes()); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// upToDate returns the path of the output recorded for key, if its file still exists.
+func (r *batchRun) upToDate(key string) (string, bool) {
+	r.mu.Lock()
+	out, ok := r.manifest[key]
+	r.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	p := filepath.Join(r.Output, filepath.FromSlash(out))
+	if _, err := os.Stat(p); err != nil {
+		return "", false
+	}
+	return p, true
+}
+
+// fail reports err for the variants of the file at p whose indices are given.
+func (r *batchRun) fail(p string, variants []int, err error) {
+	for _, i := range variants {
+		r.report(Progress{Path: p, Variant: r.Variants[i].Name, Err: err})
+	}
+}
+
+// failAll reports err for every variant of the file at p.
+func (r *batchRun) failAll(p string, err error) {
+	for _, v := range r.Variants {
+		r.report(Progress{Path: p, Variant: v.Name, Err: err})
+	}
+}
+
+// report counts the output described by pg and passes it to the Progress callback.
+func (r *batchRun) report(pg Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case pg.Err != nil:
+		r.result.Errors = append(r.result.Errors, &FileError{Path: pg.Path, Variant: pg.Variant, Err: pg.Err})
+	case pg.Skipped:
+		r.result.Skipped++
+	default:
+		r.result.Processed++
+	}
+	r.done++
+	pg.Done, pg.Total = r.done, r.total
+	if r.Progress != nil {
+		r.Progress(pg)
+	}
+}
+
+// expandTemplate substitutes the values for their {key} placeholders in tmpl.
+// Placeholders missing from values are left as is, and unknown ones return an error ErrInvalidTemplate.
+func expandTemplate(tmpl string, values map[string]string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(tmpl, '{')
+		if i < 0 {
+			b.WriteString(tmpl)
+			break
+		}
+		j := strings.IndexByte(tmpl[i:], '}')
+		if j < 0 {
+			return "", ErrInvalidTemplate
+		}
+		key := tmpl[i+1 : i+j]
+		switch key {
+		case "name", "variant", "w", "h", "ext":
+			// do nothing
+		default:
+			return "", ErrInvalidTemplate
+		}
+		b.WriteString(tmpl[:i])
+		if v, ok := values[key]; ok {
+			b.WriteString(v)
+		} else {
+			b.WriteString(tmpl[i : i+j+1])
+		}
+		tmpl = tmpl[i+j+1:]
+	}
+
+	name := b.String()
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", ErrInvalidTemplate
+	}
+	return name, nil
+}
+
+// readManifest returns the outputs recorded in the manifest at p, keyed by their cache keys.
+// A missing or corrupted manifest is read as empty, which only costs processing everything again.
+func readManifest(p string) map[string]string {
+	m := map[string]string{}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return m
+	}
+	var v struct {
+		Outputs map[string]string `json:"outputs"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil || v.Outputs == nil {
+		return m
+	}
+	return v.Outputs
+}
+
+func writeManifest(p string, m map[string]string) error {
+	b, err := json.MarshalIndent(struct {
+		Outputs map[string]string `json:"outputs"`
+	}{m}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(p, b)
+}
+
+// writeFile writes b to the file at p through a temporary file, so that the file is never seen partially written.
+func writeFile(p string, b []byte) error {
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// temporary files are only readable by their owner
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
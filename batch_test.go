@@ -0,0 +1,158 @@
+package gato
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestBatch(t *testing.T) {
+	t.Run("write every variant of every image to the mirrored tree", func(t *testing.T) {
+		out := t.TempDir()
+		var progress []Progress
+		b := &Batch{
+			Source: newStubSource(),
+			Variants: []Variant{
+				{Name: "small", Instruction: Instruction{Width: 10}},
+				{Name: "thumb", Instruction: Instruction{Width: 8, Height: 8, Fit: FitCover}, Output: Output{Format: FormatJPEG}},
+			},
+			Output:   out,
+			Progress: func(p Progress) { progress = append(progress, p) },
+		}
+		res, err := b.Run(context.Background())
+		assertError(t, err, nil)
+		assertInt(t, res.Processed, 6)
+		assertInt(t, len(res.Errors), 0)
+
+		for _, name := range []string{
+			"photos/dog_10x5.png", "photos/dog_8x8.jpg",
+			"photos/logo_10x10.png", "photos/logo_8x8.jpg",
+			"photos/cat_10x10.jpg", "photos/cat_8x8.jpg",
+		} {
+			if _, err := os.Stat(filepath.Join(out, name)); err != nil {
+				t.Errorf("got no %s", name)
+			}
+		}
+
+		assertInt(t, len(progress), 6)
+		for i, p := range progress {
+			assertInt(t, p.Done, i+1)
+			assertInt(t, p.Total, 6)
+		}
+	})
+
+	t.Run("skip the outputs that are up to date", func(t *testing.T) {
+		out := t.TempDir()
+		src := newStubSource()
+		b := &Batch{
+			Source:   src,
+			Patterns: []string{"*.png"},
+			Variants: []Variant{{Instruction: Instruction{Width: 10}}},
+			Output:   out,
+		}
+		_, _ = b.Run(context.Background())
+		res, err := b.Run(context.Background())
+		assertError(t, err, nil)
+		assertInt(t, res.Processed, 0)
+		assertInt(t, res.Skipped, 2)
+
+		// a changed source, a changed variant or a missing output are processed again
+		src["photos/dog.png"].ModTime = src["photos/dog.png"].ModTime.Add(1)
+		res, _ = b.Run(context.Background())
+		assertInt(t, res.Processed, 1)
+		assertInt(t, res.Skipped, 1)
+
+		b.Variants[0].Instruction.Interpolation = Bicubic
+		res, _ = b.Run(context.Background())
+		assertInt(t, res.Processed, 2)
+
+		b.Variants[0].Output.Gray = true
+		res, _ = b.Run(context.Background())
+		assertInt(t, res.Processed, 2)
+
+		_ = os.Remove(filepath.Join(out, "photos", "logo_10x10.png"))
+		res, _ = b.Run(context.Background())
+		assertInt(t, res.Processed, 1)
+		assertInt(t, res.Skipped, 1)
+	})
+
+	t.Run("collect the errors of single files without stopping", func(t *testing.T) {
+		src := newStubSource()
+		src["photos/broken.png"] = &fstest.MapFile{Data: []byte("not an image")}
+		b := &Batch{
+			Source:   src,
+			Variants: []Variant{{Name: "small", Instruction: Instruction{Width: 10}}},
+			Output:   t.TempDir(),
+		}
+		res, err := b.Run(context.Background())
+		assertError(t, err, nil)
+		assertInt(t, res.Processed, 3)
+		assertInt(t, len(res.Errors), 1)
+		assertString(t, res.Errors[0].Path, "photos/broken.png")
+		assertString(t, res.Errors[0].Variant, "small")
+	})
+
+	t.Run("match patterns against base names or whole paths", func(t *testing.T) {
+		src := newStubSource()
+		src["archive/dog.png"] = src["photos/dog.png"]
+		cases := []struct {
+			patterns []string
+			want     []string
+		}{
+			{nil, []string{"archive/dog.png", "photos/cat.jpg", "photos/dog.png", "photos/logo.png"}},
+			{[]string{"dog.*"}, []string{"archive/dog.png", "photos/dog.png"}},
+			{[]string{"photos/*.png"}, []string{"photos/dog.png", "photos/logo.png"}},
+			{[]string{"*.txt"}, nil},
+		}
+		for _, c := range cases {
+			var got []string
+			b := &Batch{
+				Source:   src,
+				Patterns: c.patterns,
+				Variants: []Variant{{Instruction: Instruction{Width: 10}}},
+				Output:   t.TempDir(),
+				Progress: func(p Progress) { got = append(got, p.Path) },
+			}
+			_, err := b.Run(context.Background())
+			assertError(t, err, nil)
+			slices.Sort(got)
+			if !slices.Equal(got, c.want) {
+				t.Errorf("%v: got %v, want %v", c.patterns, got, c.want)
+			}
+		}
+	})
+
+	t.Run("return error when the batch cannot run", func(t *testing.T) {
+		cases := []struct {
+			batch Batch
+			want  error
+		}{
+			{Batch{Template: "{name}_{size}.{ext}"}, ErrInvalidTemplate},
+			{Batch{Template: "{name}/{w}.{ext}"}, ErrInvalidTemplate},
+			{Batch{Patterns: []string{"[a"}}, path.ErrBadPattern},
+			{Batch{Variants: []Variant{{Instruction: Instruction{Width: -1}}}}, ErrInvalidDimension},
+			{Batch{Variants: []Variant{{Instruction: Instruction{Width: 1}, Output: Output{Format: "gif"}}}}, ErrInvalidFormat},
+		}
+		for _, c := range cases {
+			c.batch.Source = newStubSource()
+			c.batch.Output = t.TempDir()
+			_, err := c.batch.Run(context.Background())
+			assertError(t, err, c.want)
+		}
+	})
+}
+
+func TestExpandTemplate(t *testing.T) {
+	got, err := expandTemplate("{name}-{variant}_{w}x{h}.{ext}", map[string]string{
+		"name": "dog", "variant": "thumb", "w": "10", "h": "5", "ext": "png",
+	})
+	assertError(t, err, nil)
+	assertString(t, got, "dog-thumb_10x5.png")
+
+	_, err = expandTemplate("{name", nil)
+	assertError(t, err, ErrInvalidTemplate)
+}
@@ -0,0 +1,319 @@
+package gato
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRenderPanicked = errors.New("render panicked: the value of the key could not be created")
+
+// Store keeps encoded images by key. Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the value stored for key. ok is false when there is none.
+	// The returned slice must not be modified.
+	Get(key string) (value []byte, ok bool)
+	// Set stores value for key, possibly evicting other values.
+	Set(key string, value []byte) error
+}
+
+// CacheKey returns the key of the source image identified by source, processed following i and encoded following o.
+// source must change whenever the content of the image does, e.g. its name followed by its size and modification time.
+// Instructions that only differ by fields set to their defaults, e.g. Bilinear and FitFill, share a key.
+func CacheKey(source string, i Instruction, o Output) (string, error) {
+	b, err := json.Marshal(i.canonical())
+	if err != nil {
+		return "", err
+	}
+	// the whole output is part of the key, so that every encoding option tells the variants apart
+	ob, err := json.Marshal(o)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%s\x00%s", source, b, ob))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Cache stores the encoded images rendered for a key in a Store.
+// Concurrent renderings of the same key are de-duplicated, so that only one of them runs while the others wait for its result.
+type Cache struct {
+	Store Store
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call is a rendering in flight.
+type call struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// NewCache creates a new Cache instance backed by s.
+func NewCache(s Store) *Cache {
+	return &Cache{Store: s}
+}
+
+// Do returns the value stored for key, or calls render to create it and stores it when there is none.
+// Errors of render are returned as is and are not cached.
+// If render panics, the callers waiting for it get an error ErrRenderPanicked, and the panic goes on in the caller running it.
+func (c *Cache) Do(key string, render func() ([]byte, error)) ([]byte, error) {
+	if v, ok := c.Store.Get(key); ok {
+		return v, nil
+	}
+
+	c.mu.Lock()
+	if cl, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		<-cl.done
+		return cl.value, cl.err
+	}
+	cl := &call{done: make(chan struct{})}
+	if c.calls == nil {
+		c.calls = make(map[string]*call)
+	}
+	c.calls[key] = cl
+	c.mu.Unlock()
+
+	// the call is removed even if render panics, so that the key can be rendered again and no waiter is stuck
+	cl.err = ErrRenderPanicked
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		close(cl.done)
+	}()
+
+	cl.value, cl.err = render()
+	if cl.err == nil {
+		// a failing store only costs a later rendering
+		_ = c.Store.Set(key, cl.value)
+	}
+	return cl.value, cl.err
+}
+
+// lru orders entries from the most to the least recently used, and keeps their total size under max bytes.
+type lru struct {
+	max     int64
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	size  int64
+	value []byte
+}
+
+func newLRU(max int64) *lru {
+	return &lru{max: max, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns the entry of key and marks it as the most recently used.
+func (l *lru) get(key string) (*lruEntry, bool) {
+	el, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*lruEntry), true
+}
+
+// add sets e as the most recently used entry and returns the entries evicted to make room for it.
+// An entry larger than max is not added.
+func (l *lru) add(e *lruEntry) (evicted []*lruEntry) {
+	if e.size > l.max {
+		return nil
+	}
+	if old, ok := l.entries[e.key]; ok {
+		l.remove(old)
+	}
+	l.entries[e.key] = l.order.PushFront(e)
+	l.size += e.size
+	for l.size > l.max {
+		el := l.order.Back()
+		evicted = append(evicted, el.Value.(*lruEntry))
+		l.remove(el)
+	}
+	return evicted
+}
+
+func (l *lru) remove(el *list.Element) {
+	e := l.order.Remove(el).(*lruEntry)
+	delete(l.entries, e.key)
+	l.size -= e.size
+}
+
+// MemoryStore is a Store keeping values in memory, evicting the least recently used ones beyond a total size.
+type MemoryStore struct {
+	mu  sync.Mutex
+	lru *lru
+}
+
+// NewMemoryStore creates a new MemoryStore instance holding at most maxBytes bytes of values.
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{lru: newLRU(maxBytes)}
+}
+
+func (s *MemoryStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.lru.get(key)
+	if !ok {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Set stores value for key. A value larger than the limit is not stored.
+func (s *MemoryStore) Set(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lru.add(&lruEntry{key: key, size: int64(len(value)), value: value})
+	return nil
+}
+
+// Len returns the number of stored values.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.order.Len()
+}
+
+// Size returns the total size of the stored values in bytes.
+func (s *MemoryStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.size
+}
+
+// DiskStore is a Store keeping values as files of a directory, evicting the least recently used ones beyond a total size.
+// The recency of values is kept in the modification time of their files, so it survives restarts.
+type DiskStore struct {
+	dir string
+
+	mu  sync.Mutex
+	lru *lru
+}
+
+// NewDiskStore creates a new DiskStore instance holding at most maxBytes bytes of values in dir.
+// dir is created if it does not exist, and the values it already holds are kept.
+func NewDiskStore(dir string, maxBytes int64) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type file struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	for _, e := range entries {
+		// skip the temporary files of writes that did not complete
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{e.Name(), info.Size(), info.ModTime()})
+	}
+	// from the least to the most recently used, so that the last one added ends up in front
+	slices.SortFunc(files, func(a, b file) int {
+		return a.modTime.Compare(b.modTime)
+	})
+
+	s := &DiskStore{dir: dir, lru: newLRU(maxBytes)}
+	for _, f := range files {
+		for _, e := range s.lru.add(&lruEntry{key: f.name, size: f.size}) {
+			_ = os.Remove(filepath.Join(dir, e.key))
+		}
+	}
+	return s, nil
+}
+
+// fileName returns the name of the file of key, which is safe whatever key holds.
+func (s *DiskStore) fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *DiskStore) Get(key string) ([]byte, bool) {
+	name := s.fileName(key)
+	s.mu.Lock()
+	_, ok := s.lru.get(name)
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	p := filepath.Join(s.dir, name)
+	b, err := os.ReadFile(p)
+	if err != nil {
+		s.mu.Lock()
+		if el, ok := s.lru.entries[name]; ok {
+			s.lru.remove(el)
+		}
+		s.mu.Unlock()
+		return nil, false
+	}
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+	return b, true
+}
+
+// Set stores value for key. A value larger than the limit is not stored.
+func (s *DiskStore) Set(key string, value []byte) error {
+	if int64(len(value)) > s.lru.max {
+		return nil
+	}
+
+	// write to a temporary file first, so that readers never see a partial value
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	name := s.fileName(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		return err
+	}
+	for _, e := range s.lru.add(&lruEntry{key: name, size: int64(len(value))}) {
+		_ = os.Remove(filepath.Join(s.dir, e.key))
+	}
+	return nil
+}
+
+// Size returns the total size of the stored values in bytes.
+func (s *DiskStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.size
+}
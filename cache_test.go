@@ -0,0 +1,193 @@
+package gato
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheKey(t *testing.T) {
+	i := Instruction{Width: 100}
+	a, err := CacheKey("dog.png", i, Output{Format: FormatPNG})
+	assertError(t, err, nil)
+	b, _ := CacheKey("dog.png", i, Output{Format: FormatPNG})
+	assertString(t, a, b)
+
+	for _, other := range []struct {
+		source string
+		i      Instruction
+		o      Output
+	}{
+		{"cat.png", i, Output{Format: FormatPNG}},
+		{"dog.png", Instruction{Width: 200}, Output{Format: FormatPNG}},
+		{"dog.png", i, Output{Format: FormatJPEG}},
+		{"dog.png", i, Output{Format: FormatPNG, Quality: 80}},
+		{"dog.png", i, Output{Format: FormatPNG, Gray: true}},
+	} {
+		if c, _ := CacheKey(other.source, other.i, other.o); c == a {
+			t.Errorf("got the same key for %v", other)
+		}
+	}
+
+	for _, same := range []Instruction{
+		{Width: 100, Interpolation: Bilinear, Fit: FitFill},
+		{Width: 100, Gravity: GravityCenter},
+	} {
+		if c, _ := CacheKey("dog.png", same, Output{Format: FormatPNG}); c != a {
+			t.Errorf("got another key for %v", same)
+		}
+	}
+	p, _ := CacheKey("dog.png", Instruction{Pipeline: []Operation{Resize{Width: 100}}}, Output{Format: FormatPNG})
+	q, _ := CacheKey("dog.png", Instruction{Pipeline: []Operation{Resize{Width: 100, Interpolation: Bilinear, Fit: FitFill}}}, Output{Format: FormatPNG})
+	assertString(t, q, p)
+}
+
+func TestCache(t *testing.T) {
+	t.Run("render once and serve the stored value afterwards", func(t *testing.T) {
+		c := NewCache(NewMemoryStore(1 << 10))
+		var n int
+		render := func() ([]byte, error) {
+			n++
+			return []byte("image"), nil
+		}
+		for range 3 {
+			b, err := c.Do("key", render)
+			assertError(t, err, nil)
+			assertString(t, string(b), "image")
+		}
+		assertInt(t, n, 1)
+	})
+
+	t.Run("do not store errors", func(t *testing.T) {
+		c := NewCache(NewMemoryStore(1 << 10))
+		errRender := errors.New("render")
+		_, err := c.Do("key", func() ([]byte, error) { return nil, errRender })
+		assertError(t, err, errRender)
+		b, err := c.Do("key", func() ([]byte, error) { return []byte("image"), nil })
+		assertError(t, err, nil)
+		assertString(t, string(b), "image")
+	})
+
+	t.Run("release the key when render panics", func(t *testing.T) {
+		c := NewCache(NewMemoryStore(1 << 10))
+		started, release := make(chan struct{}), make(chan struct{})
+		panicked := make(chan any)
+		go func() {
+			defer func() { panicked <- recover() }()
+			c.Do("key", func() ([]byte, error) {
+				close(started)
+				<-release
+				panic("render")
+			})
+		}()
+		<-started
+		waited := make(chan error)
+		go func() {
+			_, err := c.Do("key", func() ([]byte, error) { return []byte("image"), nil })
+			waited <- err
+		}()
+		// let the waiting caller pile up behind the panicking rendering
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		if r := <-panicked; r != "render" {
+			t.Errorf("got %v, want the panic of render", r)
+		}
+		assertError(t, <-waited, ErrRenderPanicked)
+
+		b, err := c.Do("key", func() ([]byte, error) { return []byte("image"), nil })
+		assertError(t, err, nil)
+		assertString(t, string(b), "image")
+	})
+
+	t.Run("render concurrent requests of the same key once", func(t *testing.T) {
+		c := NewCache(NewMemoryStore(1 << 10))
+		var n atomic.Int32
+		release := make(chan struct{})
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b, err := c.Do("key", func() ([]byte, error) {
+					n.Add(1)
+					<-release
+					return []byte("image"), nil
+				})
+				assertError(t, err, nil)
+				assertString(t, string(b), "image")
+			}()
+		}
+		// let the waiting callers pile up behind the first rendering
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		assertInt(t, int(n.Load()), 1)
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore(10)
+	_ = s.Set("a", []byte("1234"))
+	_ = s.Set("b", []byte("1234"))
+	s.Get("a")
+	_ = s.Set("c", []byte("1234"))
+
+	if _, ok := s.Get("b"); ok {
+		t.Error("got b, want it evicted as the least recently used")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := s.Get(key); !ok {
+			t.Errorf("got no %s", key)
+		}
+	}
+	assertInt(t, s.Len(), 2)
+	assertInt(t, int(s.Size()), 8)
+
+	_ = s.Set("d", []byte("01234567890"))
+	if _, ok := s.Get("d"); ok {
+		t.Error("got a value larger than the limit")
+	}
+	assertInt(t, s.Len(), 2)
+}
+
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskStore(dir, 10)
+	assertError(t, err, nil)
+	_ = s.Set("a", []byte("1234"))
+	_ = s.Set("b", []byte("1234"))
+	s.Get("a")
+	_ = s.Set("c", []byte("1234"))
+
+	if _, ok := s.Get("b"); ok {
+		t.Error("got b, want it evicted as the least recently used")
+	}
+	b, ok := s.Get("a")
+	if !ok {
+		t.Fatal("got no a")
+	}
+	assertString(t, string(b), "1234")
+	files, _ := os.ReadDir(dir)
+	assertInt(t, len(files), 2)
+
+	t.Run("keep the stored values across restarts", func(t *testing.T) {
+		s, err := NewDiskStore(dir, 10)
+		assertError(t, err, nil)
+		assertInt(t, int(s.Size()), 8)
+		if _, ok := s.Get("c"); !ok {
+			t.Error("got no c")
+		}
+	})
+
+	t.Run("evict files beyond a lower limit on start", func(t *testing.T) {
+		s, err := NewDiskStore(dir, 5)
+		assertError(t, err, nil)
+		assertInt(t, int(s.Size()), 4)
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		assertInt(t, len(files), 1)
+	})
+}
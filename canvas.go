@@ -0,0 +1,361 @@
+package gato
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Gravity is the side or corner that an image sticks to when it is placed inside a larger box, or cropped out of one.
+type Gravity string
+
+const (
+	GravityCenter    Gravity = "center"
+	GravityNorth     Gravity = "north"
+	GravityNorthEast Gravity = "north-east"
+	GravityEast      Gravity = "east"
+	GravitySouthEast Gravity = "south-east"
+	GravitySouth     Gravity = "south"
+	GravitySouthWest Gravity = "south-west"
+	GravityWest      Gravity = "west"
+	GravityNorthWest Gravity = "north-west"
+)
+
+func (g Gravity) MarshalText() ([]byte, error) {
+	if _, _, err := anchor(g); err != nil {
+		return nil, err
+	}
+	return []byte(g), nil
+}
+
+func (g *Gravity) UnmarshalText(text []byte) error {
+	if _, _, err := anchor(Gravity(text)); err != nil {
+		return fieldError("gravity", err)
+	}
+	*g = Gravity(text)
+	return nil
+}
+
+// Extend is the way the area around an image is filled when its canvas is extended.
+type Extend string
+
+const (
+	ExtendColor  Extend = "color"
+	ExtendEdge   Extend = "edge"
+	ExtendMirror Extend = "mirror"
+)
+
+// validate reports whether e is a known extend mode. The empty mode is valid and means ExtendColor.
+func (e Extend) validate() error {
+	switch e {
+	case "", ExtendColor, ExtendEdge, ExtendMirror:
+		return nil
+	}
+	return ErrInvalidExtend
+}
+
+func (e Extend) MarshalText() ([]byte, error) {
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+	return []byte(e), nil
+}
+
+func (e *Extend) UnmarshalText(text []byte) error {
+	if err := Extend(text).validate(); err != nil {
+		return fieldError("extend", err)
+	}
+	*e = Extend(text)
+	return nil
+}
+
+var (
+	ErrInvalidGravity = errors.New("invalid gravity: only center, north, north-east, east, south-east, south, south-west, west, and north-west are available")
+	ErrInvalidExtend  = errors.New("invalid extend mode: only color, edge, and mirror are available")
+	ErrInvalidPadding = errors.New("invalid padding: padding is negative or the canvas is smaller than the image")
+	ErrInvalidCrop    = errors.New("invalid crop: the area is empty or outside of the image")
+)
+
+// anchor returns the relative position (0, 0.5 or 1 on each axis) that the gravity g points to.
+// If g is not set, it defaults to GravityCenter.
+func anchor(g Gravity) (fx, fy float64, err error) {
+	switch g {
+	case "", GravityCenter:
+		return 0.5, 0.5, nil
+	case GravityNorth:
+		return 0.5, 0, nil
+	case GravityNorthEast:
+		return 1, 0, nil
+	case GravityEast:
+		return 1, 0.5, nil
+	case GravitySouthEast:
+		return 1, 1, nil
+	case GravitySouth:
+		return 0.5, 1, nil
+	case GravitySouthWest:
+		return 0, 1, nil
+	case GravityWest:
+		return 0, 0.5, nil
+	case GravityNorthWest:
+		return 0, 0, nil
+	}
+	return 0, 0, ErrInvalidGravity
+}
+
+// gravityOffset returns where a box of size inner is placed inside a box of size outer following the gravity g.
+func gravityOffset(g Gravity, outer, inner image.Point) (image.Point, error) {
+	fx, fy, err := anchor(g)
+	if err != nil {
+		return image.Point{}, err
+	}
+	return image.Point{
+		X: int(math.Round(float64(outer.X-inner.X) * fx)),
+		Y: int(math.Round(float64(outer.Y-inner.Y) * fy)),
+	}, nil
+}
+
+// extendIndex maps the index i, which may lie outside of [0, n), back into [0, n) following the extend mode.
+// ExtendEdge repeats the edge pixel and ExtendMirror reflects the image around its edges.
+func extendIndex(i, n int, mode Extend) int {
+	if i >= 0 && i < n {
+		return i
+	}
+	switch mode {
+	case ExtendMirror:
+		period := 2 * n
+		i %= period
+		if i < 0 {
+			i += period
+		}
+		if i >= n {
+			i = period - 1 - i
+		}
+		return i
+	default:
+		return min(max(i, 0), n-1)
+	}
+}
+
+// crop copies the area of src whose top-left corner is at and whose size is the size of dst into dst.
+func crop(dst, src *image.RGBA, at image.Point) {
+	w := dst.Bounds().Dx()
+	at = at.Add(src.Bounds().Min)
+	for y := range dst.Bounds().Dy() {
+		srcOff := src.PixOffset(at.X, at.Y+y)
+		copy(dst.Pix[y*dst.Stride:y*dst.Stride+4*w], src.Pix[srcOff:srcOff+4*w])
+	}
+}
+
+// Pad is an operation that extends the canvas of an image.
+// The canvas is either extended by the per-side amounts Top, Right, Bottom and Left, or to the size Width x Height.
+type Pad struct {
+	Top    int `json:"top,omitempty"`
+	Right  int `json:"right,omitempty"`
+	Bottom int `json:"bottom,omitempty"`
+	Left   int `json:"left,omitempty"`
+	// Width and Height are the dimensions of the extended canvas. They cannot be combined with the per-side amounts.
+	// If only one of them is set, the other dimension of the image is kept.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// Gravity places the image on the extended canvas when Width or Height is set. If it is not set, it defaults to GravityCenter.
+	Gravity Gravity `json:"gravity,omitempty"`
+	// Extend decides how the new area is filled. If it is not set, it defaults to ExtendColor.
+	Extend Extend `json:"extend,omitempty"`
+	// Background is the color of the new area with ExtendColor. The zero value is transparent.
+	Background color.RGBA `json:"-"`
+}
+
+func (pd Pad) kind() string {
+	return "pad"
+}
+
+func (pd Pad) validate() error {
+	if err := pd.Extend.validate(); err != nil {
+		return fieldError("extend", err)
+	}
+	sides := []struct {
+		field  string
+		amount int
+	}{{"top", pd.Top}, {"right", pd.Right}, {"bottom", pd.Bottom}, {"left", pd.Left}, {"width", pd.Width}, {"height", pd.Height}}
+	for _, s := range sides {
+		if s.amount < 0 {
+			return fieldError(s.field, ErrInvalidPadding)
+		}
+	}
+	if (pd.Width != 0 || pd.Height != 0) && (pd.Top != 0 || pd.Right != 0 || pd.Bottom != 0 || pd.Left != 0) {
+		return fieldError("width", ErrInvalidPadding)
+	}
+	if _, _, err := anchor(pd.Gravity); err != nil {
+		return fieldError("gravity", err)
+	}
+	return nil
+}
+
+// insets returns the amount of padding on each side for an image of the given size.
+func (pd Pad) insets(size image.Point) (top, right, bottom, left int, err error) {
+	if pd.Width == 0 && pd.Height == 0 {
+		return pd.Top, pd.Right, pd.Bottom, pd.Left, nil
+	}
+
+	canvas := image.Point{X: pd.Width, Y: pd.Height}
+	if canvas.X == 0 {
+		canvas.X = size.X
+	}
+	if canvas.Y == 0 {
+		canvas.Y = size.Y
+	}
+	if canvas.X < size.X || canvas.Y < size.Y {
+		return 0, 0, 0, 0, ErrInvalidPadding
+	}
+	off, err := gravityOffset(pd.Gravity, canvas, size)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	return off.Y, canvas.X - size.X - off.X, canvas.Y - size.Y - off.Y, off.X, nil
+}
+
+func (pd Pad) outputSize(size image.Point) (image.Point, bool) {
+	top, right, bottom, left, err := pd.insets(size)
+	if err != nil {
+		return image.Point{}, false
+	}
+	return image.Pt(left+size.X+right, top+size.Y+bottom), true
+}
+
+func (pd Pad) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	srcW := src.Bounds().Dx()
+	srcH := src.Bounds().Dy()
+	top, right, bottom, left, err := pd.insets(image.Point{X: srcW, Y: srcH})
+	if err != nil {
+		return nil, err
+	}
+
+	dstW := left + srcW + right
+	dstH := top + srcH + bottom
+	dst := s.get(dstW, dstH)
+
+	err = s.parallel(dstH, func(start, end int) {
+		for y := start; y < end; y++ {
+			sy := y - top
+			for x := range dstW {
+				sx := x - left
+				if sx >= 0 && sx < srcW && sy >= 0 && sy < srcH {
+					dst.SetRGBA(x, y, src.RGBAAt(src.Rect.Min.X+sx, src.Rect.Min.Y+sy))
+					continue
+				}
+				if pd.Extend == "" || pd.Extend == ExtendColor {
+					dst.SetRGBA(x, y, pd.Background)
+					continue
+				}
+				dst.SetRGBA(x, y, src.RGBAAt(src.Rect.Min.X+extendIndex(sx, srcW, pd.Extend), src.Rect.Min.Y+extendIndex(sy, srcH, pd.Extend)))
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dst, nil
+}
+
+// Apply returns a new image of the source placed on the extended canvas following the operation.
+func (pd Pad) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := pd.validate(); err != nil {
+		return nil, err
+	}
+	return pd.apply(&scratch{}, src)
+}
+
+func (pd Pad) MarshalJSON() ([]byte, error) {
+	type alias Pad
+	return marshalOperation(pd.kind(), struct {
+		alias
+		Background *hexColor `json:"background,omitempty"`
+	}{alias(pd), newHexColor(pd.Background)})
+}
+
+func (pd *Pad) UnmarshalJSON(data []byte) error {
+	type alias Pad
+	v := struct {
+		Op string `json:"op"`
+		*alias
+		Background string `json:"background"`
+	}{alias: (*alias)(pd)}
+	if err := decodeStrict(data, &v); err != nil {
+		return err
+	}
+	bg, err := decodeColor("background", v.Background)
+	if err != nil {
+		return err
+	}
+	pd.Background = bg
+	return nil
+}
+
+// Crop is an operation that cuts out the rectangular area of an image whose top-left corner is (X, Y).
+// The area is clipped to the image.
+type Crop struct {
+	X      int `json:"x,omitempty"`
+	Y      int `json:"y,omitempty"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (c Crop) kind() string {
+	return "crop"
+}
+
+func (c Crop) validate() error {
+	if c.X < 0 {
+		return fieldError("x", ErrInvalidCrop)
+	}
+	if c.Y < 0 {
+		return fieldError("y", ErrInvalidCrop)
+	}
+	if c.Width <= 0 {
+		return fieldError("width", ErrInvalidCrop)
+	}
+	if c.Height <= 0 {
+		return fieldError("height", ErrInvalidCrop)
+	}
+	return nil
+}
+
+func (c Crop) outputSize(size image.Point) (image.Point, bool) {
+	r := image.Rect(c.X, c.Y, c.X+c.Width, c.Y+c.Height).Intersect(image.Rectangle{Max: size})
+	return r.Size(), !r.Empty()
+}
+
+func (c Crop) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	size := src.Bounds().Size()
+	r := image.Rect(c.X, c.Y, c.X+c.Width, c.Y+c.Height).Intersect(image.Rectangle{Max: size})
+	if r.Empty() {
+		return nil, ErrInvalidCrop
+	}
+	dst := s.get(r.Dx(), r.Dy())
+	crop(dst, src, r.Min)
+	return dst, nil
+}
+
+// Apply returns a new image of the cropped area of the source.
+// If the area lies outside of the image, it returns an error ErrInvalidCrop.
+func (c Crop) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c.apply(&scratch{}, src)
+}
+
+func (c Crop) MarshalJSON() ([]byte, error) {
+	type alias Crop
+	return marshalOperation(c.kind(), alias(c))
+}
+
+func (c *Crop) UnmarshalJSON(data []byte) error {
+	type alias Crop
+	return decodeStrict(data, &struct {
+		Op string `json:"op"`
+		*alias
+	}{alias: (*alias)(c)})
+}
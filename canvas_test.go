@@ -0,0 +1,140 @@
+package gato
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestExtendIndex(t *testing.T) {
+	n := 3
+	cases := []struct {
+		mode Extend
+		want []int // indices -4..6
+	}{
+		{ExtendEdge, []int{0, 0, 0, 0, 0, 1, 2, 2, 2, 2, 2}},
+		{ExtendMirror, []int{2, 2, 1, 0, 0, 1, 2, 2, 1, 0, 0}},
+	}
+	for _, c := range cases {
+		for i, want := range c.want {
+			assertInt(t, extendIndex(i-4, n, c.mode), want)
+		}
+	}
+}
+
+func TestPad(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+
+	t.Run("extend the canvas by per-side amounts", func(t *testing.T) {
+		src := newGradientImage(4, 3)
+		dst, err := Pad{Top: 1, Right: 2, Bottom: 3, Left: 4, Background: red}.Apply(src)
+		assertError(t, err, nil)
+		assertInt(t, dst.Bounds().Dx(), 10)
+		assertInt(t, dst.Bounds().Dy(), 7)
+		if got := dst.RGBAAt(0, 0); got != red {
+			t.Errorf("got %v, want %v", got, red)
+		}
+		if got, want := dst.RGBAAt(4, 1), src.RGBAAt(0, 0); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("extend the canvas to a target size with gravity", func(t *testing.T) {
+		src := newGradientImage(4, 8)
+		dst, _ := Pad{Width: 8, Height: 8, Gravity: GravityEast}.Apply(src)
+		assertInt(t, dst.Bounds().Dx(), 8)
+		assertInt(t, dst.Bounds().Dy(), 8)
+		if got := dst.RGBAAt(0, 0); got != (color.RGBA{}) {
+			t.Errorf("got %v, want transparent", got)
+		}
+		if got, want := dst.RGBAAt(4, 0), src.RGBAAt(0, 0); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("replicate the edge pixels", func(t *testing.T) {
+		src := newGradientImage(4, 4)
+		dst, _ := Pad{Left: 2, Extend: ExtendEdge}.Apply(src)
+		for x := range 2 {
+			if got, want := dst.RGBAAt(x, 3), src.RGBAAt(0, 3); got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+	})
+
+	t.Run("mirror the image around its edges", func(t *testing.T) {
+		src := newGradientImage(4, 4)
+		dst, _ := Pad{Top: 2, Extend: ExtendMirror}.Apply(src)
+		if got, want := dst.RGBAAt(1, 0), src.RGBAAt(1, 1); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("pad a sub-image as a copy of its area", func(t *testing.T) {
+		src := newGradientImage(10, 8)
+		sub := src.SubImage(image.Rect(2, 3, 8, 7)).(*image.RGBA)
+		area, _ := Crop{X: 2, Y: 3, Width: 6, Height: 4}.Apply(src)
+		for _, pd := range []Pad{
+			{Top: 1, Left: 2, Background: red},
+			{Right: 3, Bottom: 2, Extend: ExtendEdge},
+			{Width: 12, Height: 10, Extend: ExtendMirror},
+		} {
+			got, err := pd.Apply(sub)
+			assertError(t, err, nil)
+			want, _ := pd.Apply(area)
+			if !reflect.DeepEqual(got.Pix, want.Pix) {
+				t.Errorf("got other pixels than the padded copy with %+v", pd)
+			}
+		}
+	})
+
+	t.Run("return error when the canvas is smaller than the image", func(t *testing.T) {
+		_, err := Pad{Width: 2}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidPadding)
+	})
+
+	t.Run("return error when per-side amounts and target size are combined", func(t *testing.T) {
+		_, err := Pad{Top: 1, Width: 10}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidPadding)
+	})
+
+	t.Run("return error when invalid gravity is provided", func(t *testing.T) {
+		_, err := Pad{Width: 10, Gravity: "up"}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidGravity)
+	})
+
+	t.Run("return error when invalid extend mode is provided", func(t *testing.T) {
+		_, err := Pad{Top: 1, Extend: "wrap"}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidExtend)
+	})
+}
+
+func TestCrop(t *testing.T) {
+	src := newGradientImage(6, 6)
+	dst := image.NewRGBA(image.Rect(0, 0, 3, 5))
+	crop(dst, src, image.Pt(2, 1))
+	assertInt(t, dst.Bounds().Dx(), 3)
+	assertInt(t, dst.Bounds().Dy(), 5)
+	if got, want := dst.RGBAAt(0, 0), src.RGBAAt(2, 1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCropOperation(t *testing.T) {
+	t.Run("clip the area to the image", func(t *testing.T) {
+		src := newGradientImage(6, 6)
+		dst, err := Crop{X: 4, Y: 1, Width: 10, Height: 2}.Apply(src)
+		assertError(t, err, nil)
+		assertInt(t, dst.Bounds().Dx(), 2)
+		assertInt(t, dst.Bounds().Dy(), 2)
+		if got, want := dst.RGBAAt(0, 0), src.RGBAAt(4, 1); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("return error when the area is outside of the image", func(t *testing.T) {
+		_, err := Crop{X: 10, Y: 10, Width: 2, Height: 2}.Apply(newGradientImage(6, 6))
+		assertError(t, err, ErrInvalidCrop)
+	})
+}
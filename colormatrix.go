@@ -0,0 +1,236 @@
+package gato
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+var (
+	ErrInvalidColorMatrix = errors.New("invalid color matrix: the matrix must be set, and its values must be finite")
+	ErrInvalidLuma        = errors.New("invalid luma: only rec601 and rec709 are available")
+	ErrInvalidChannels    = errors.New("invalid channels: channels must be 3 or 4 letters among r, g, b and a, e.g. bgr")
+)
+
+// Luma is the set of weights that the luminance of a color is computed with.
+type Luma string
+
+const (
+	// LumaRec601 weighs colors following ITU-R BT.601, as JPEG and image/color do.
+	LumaRec601 Luma = "rec601"
+	// LumaRec709 weighs colors following ITU-R BT.709, as sRGB does.
+	LumaRec709 Luma = "rec709"
+)
+
+// weights returns the weights of red, green and blue. If l is not set, it defaults to LumaRec601.
+func (l Luma) weights() ([3]float64, error) {
+	switch l {
+	case "", LumaRec601:
+		return [3]float64{0.299, 0.587, 0.114}, nil
+	case LumaRec709:
+		return [3]float64{0.2126, 0.7152, 0.0722}, nil
+	}
+	return [3]float64{}, ErrInvalidLuma
+}
+
+// ColorMatrix is a 4x5 color transformation matrix in row-major order.
+// A straight color (r, g, b, a), whose channels range from 0 to 1, is mapped to (m[0]*r + m[1]*g + m[2]*b + m[3]*a + m[4], ...),
+// so that the last column is an offset.
+type ColorMatrix [20]float64
+
+// IdentityColorMatrix is the color matrix that maps every color to itself.
+var IdentityColorMatrix = ColorMatrix{
+	1, 0, 0, 0, 0,
+	0, 1, 0, 0, 0,
+	0, 0, 1, 0, 0,
+	0, 0, 0, 1, 0,
+}
+
+// Then returns the color matrix that applies m, then n.
+func (m ColorMatrix) Then(n ColorMatrix) ColorMatrix {
+	var p ColorMatrix
+	for i := range 4 {
+		for j := range 5 {
+			var v float64
+			for k := range 4 {
+				v += n[5*i+k] * m[5*k+j]
+			}
+			if j == 4 {
+				v += n[5*i+4]
+			}
+			p[5*i+j] = v
+		}
+	}
+	return p
+}
+
+// GrayscaleMatrix returns the color matrix that turns colors into their luminance, weighed following luma.
+func GrayscaleMatrix(luma Luma) (ColorMatrix, error) {
+	w, err := luma.weights()
+	if err != nil {
+		return ColorMatrix{}, err
+	}
+	return ColorMatrix{
+		w[0], w[1], w[2], 0, 0,
+		w[0], w[1], w[2], 0, 0,
+		w[0], w[1], w[2], 0, 0,
+		0, 0, 0, 1, 0,
+	}, nil
+}
+
+// SepiaMatrix returns the color matrix that tones colors in sepia.
+func SepiaMatrix() ColorMatrix {
+	return ColorMatrix{
+		0.393, 0.769, 0.189, 0, 0,
+		0.349, 0.686, 0.168, 0, 0,
+		0.272, 0.534, 0.131, 0, 0,
+		0, 0, 0, 1, 0,
+	}
+}
+
+// SaturationMatrix returns the color matrix that scales the saturation of colors by s, keeping their Rec.709 luminance.
+// 0 is grayscale, 1 leaves colors as they are, and greater values are more vivid.
+func SaturationMatrix(s float64) ColorMatrix {
+	gray, _ := GrayscaleMatrix(LumaRec709)
+	var m ColorMatrix
+	for i := range m {
+		m[i] = s*IdentityColorMatrix[i] + (1-s)*gray[i]
+	}
+	return m
+}
+
+// HueRotationMatrix returns the color matrix that rotates the hue of colors by degrees, keeping their luminance,
+// following the hueRotate filter of SVG.
+func HueRotationMatrix(degrees float64) ColorMatrix {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	return ColorMatrix{
+		0.213 + cos*0.787 - sin*0.213, 0.715 - cos*0.715 - sin*0.715, 0.072 - cos*0.072 + sin*0.928, 0, 0,
+		0.213 - cos*0.213 + sin*0.143, 0.715 + cos*0.285 + sin*0.140, 0.072 - cos*0.072 - sin*0.283, 0, 0,
+		0.213 - cos*0.213 - sin*0.787, 0.715 - cos*0.715 + sin*0.715, 0.072 + cos*0.928 + sin*0.072, 0, 0,
+		0, 0, 0, 1, 0,
+	}
+}
+
+// ChannelMatrix returns the color matrix that reorders the channels of colors following channels,
+// whose letter i names the source of the channel i, e.g. bgr swaps red and blue, and rrr spreads red over the three colors.
+// Without a fourth letter, alpha is kept as it is.
+func ChannelMatrix(channels string) (ColorMatrix, error) {
+	if len(channels) != 3 && len(channels) != 4 {
+		return ColorMatrix{}, ErrInvalidChannels
+	}
+	m := IdentityColorMatrix
+	for i, c := range []byte(channels) {
+		src := strings.IndexByte("rgba", c)
+		if src < 0 {
+			return ColorMatrix{}, ErrInvalidChannels
+		}
+		row := m[5*i : 5*i+5]
+		clear(row)
+		row[src] = 1
+	}
+	return m, nil
+}
+
+// Recolor is an operation that maps the colors of an image through a color matrix,
+// e.g. SepiaMatrix() or SaturationMatrix(1.2).Then(HueRotationMatrix(15)).
+type Recolor struct {
+	Matrix ColorMatrix `json:"matrix"`
+}
+
+func (rc Recolor) kind() string {
+	return "recolor"
+}
+
+func (rc Recolor) validate() error {
+	if rc.Matrix == (ColorMatrix{}) {
+		return fieldError("matrix", ErrInvalidColorMatrix)
+	}
+	for _, v := range rc.Matrix {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fieldError("matrix", ErrInvalidColorMatrix)
+		}
+	}
+	return nil
+}
+
+func (rc Recolor) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	m := rc.Matrix
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := s.get(w, h)
+	err := s.parallel(h, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := range w {
+				c := src.RGBAAt(src.Rect.Min.X+x, src.Rect.Min.Y+y)
+				// the matrix applies to straight colors
+				var in [4]float64
+				if c.A > 0 {
+					a := float64(c.A)
+					in = [4]float64{float64(c.R) / a, float64(c.G) / a, float64(c.B) / a, a / 255}
+				}
+				var out [4]float64
+				for i := range out {
+					row := m[5*i : 5*i+5]
+					out[i] = min(max(row[0]*in[0]+row[1]*in[1]+row[2]*in[2]+row[3]*in[3]+row[4], 0), 1)
+				}
+				a := out[3] * 255
+				dst.SetRGBA(x, y, color.RGBA{clamp(out[0] * a), clamp(out[1] * a), clamp(out[2] * a), clamp(a)})
+			}
+		}
+	})
+	if err != nil {
+		s.put(dst)
+		return nil, err
+	}
+	return dst, nil
+}
+
+// Apply returns a new image of the source recolored following the operation.
+func (rc Recolor) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := rc.validate(); err != nil {
+		return nil, err
+	}
+	return rc.apply(&scratch{}, src)
+}
+
+func (rc Recolor) MarshalJSON() ([]byte, error) {
+	type alias Recolor
+	return marshalOperation(rc.kind(), alias(rc))
+}
+
+func (rc *Recolor) UnmarshalJSON(data []byte) error {
+	type alias Recolor
+	v := struct {
+		Op string `json:"op"`
+		*alias
+	}{alias: (*alias)(rc)}
+	return decodeStrict(data, &v)
+}
+
+// Grayscale returns the luminance of src as an *image.Gray, a quarter of the size of an *image.RGBA, weighed following luma.
+// Gray images have no alpha, so transparent areas are composited onto black.
+// Its rows are run with the workers of DefaultPool().
+func Grayscale(src *image.RGBA, luma Luma) (*image.Gray, error) {
+	wt, err := luma.weights()
+	if err != nil {
+		return nil, err
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	err = (&scratch{}).parallel(h, func(start, end int) {
+		for y := start; y < end; y++ {
+			sp := src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y):]
+			dp := dst.Pix[dst.PixOffset(0, y):]
+			for x := range w {
+				// premultiplied colors are already composited onto black
+				p := sp[4*x : 4*x+3]
+				dp[x] = clamp(wt[0]*float64(p[0]) + wt[1]*float64(p[1]) + wt[2]*float64(p[2]))
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
@@ -0,0 +1,118 @@
+package gato
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// assertColorMatrix reports whether got is within tolerance of want.
+func assertColorMatrix(t testing.TB, got, want ColorMatrix, tolerance float64) {
+	t.Helper()
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > tolerance {
+			t.Errorf("got %v, want %v", got, want)
+			return
+		}
+	}
+}
+
+func TestColorMatrix(t *testing.T) {
+	t.Run("compose matrices in order", func(t *testing.T) {
+		swap, _ := ChannelMatrix("bgr")
+		bright := IdentityColorMatrix
+		bright[4] = 0.5 // red offset
+		src := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		src.SetRGBA(0, 0, color.RGBA{0, 0, 100, 255})
+
+		composed, err := Recolor{Matrix: swap.Then(bright)}.Apply(src)
+		assertError(t, err, nil)
+		first, _ := Recolor{Matrix: swap}.Apply(src)
+		second, _ := Recolor{Matrix: bright}.Apply(first)
+		if composed.RGBAAt(0, 0) != second.RGBAAt(0, 0) {
+			t.Errorf("got %v, want %v", composed.RGBAAt(0, 0), second.RGBAAt(0, 0))
+		}
+		assertColorMatrix(t, IdentityColorMatrix.Then(swap), swap, 1e-9)
+	})
+
+	t.Run("desaturate to the Rec. 709 grayscale", func(t *testing.T) {
+		gray, err := GrayscaleMatrix(LumaRec709)
+		assertError(t, err, nil)
+		assertColorMatrix(t, SaturationMatrix(0), gray, 1e-9)
+		assertColorMatrix(t, SaturationMatrix(1), IdentityColorMatrix, 1e-9)
+		_, err = GrayscaleMatrix("rec2020")
+		assertError(t, err, ErrInvalidLuma)
+	})
+
+	t.Run("rotate hues by full turns back to the identity", func(t *testing.T) {
+		assertColorMatrix(t, HueRotationMatrix(0), IdentityColorMatrix, 1e-9)
+		assertColorMatrix(t, HueRotationMatrix(360), IdentityColorMatrix, 1e-9)
+		// the weights of the SVG filter are rounded, so rotations only add up approximately
+		assertColorMatrix(t, HueRotationMatrix(120).Then(HueRotationMatrix(240)), IdentityColorMatrix, 2e-3)
+	})
+
+	t.Run("reorder channels", func(t *testing.T) {
+		m, err := ChannelMatrix("bgra")
+		assertError(t, err, nil)
+		src := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		src.SetRGBA(0, 0, color.RGBA{10, 20, 30, 255})
+		dst, _ := Recolor{Matrix: m}.Apply(src)
+		if got := dst.RGBAAt(0, 0); got != (color.RGBA{30, 20, 10, 255}) {
+			t.Errorf("got %v, want red and blue swapped", got)
+		}
+		for _, channels := range []string{"rg", "rgbax", "rgx"} {
+			_, err := ChannelMatrix(channels)
+			assertError(t, err, ErrInvalidChannels)
+		}
+	})
+}
+
+func TestRecolor(t *testing.T) {
+	t.Run("tone in sepia and keep alpha", func(t *testing.T) {
+		src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+		src.SetRGBA(0, 0, color.RGBA{255, 255, 255, 255})
+		src.SetRGBA(1, 0, color.RGBA{128, 128, 128, 128})
+		dst, err := Recolor{Matrix: SepiaMatrix()}.Apply(src)
+		assertError(t, err, nil)
+		if got := dst.RGBAAt(0, 0); got != (color.RGBA{255, 255, 239, 255}) {
+			t.Errorf("got %v for white", got)
+		}
+		if got := dst.RGBAAt(1, 0); got != (color.RGBA{128, 128, 120, 128}) {
+			t.Errorf("got %v for translucent white", got)
+		}
+	})
+
+	t.Run("return error when the matrix is not set", func(t *testing.T) {
+		_, err := Recolor{}.Apply(newGradientImage(2, 2))
+		assertValidationError(t, err, -1, "matrix", ErrInvalidColorMatrix)
+		_, err = Recolor{Matrix: ColorMatrix{math.Inf(1)}}.Apply(newGradientImage(2, 2))
+		assertError(t, err, ErrInvalidColorMatrix)
+	})
+}
+
+func TestGrayscale(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	src.SetRGBA(0, 0, color.RGBA{0, 255, 0, 255})
+	src.SetRGBA(1, 0, color.RGBA{0, 128, 0, 128})
+
+	cases := []struct {
+		luma         Luma
+		green, faint uint8
+	}{
+		{LumaRec601, 150, 75},
+		{LumaRec709, 182, 92},
+	}
+	for _, c := range cases {
+		gray, err := Grayscale(src, c.luma)
+		assertError(t, err, nil)
+		assertInt(t, int(gray.GrayAt(0, 0).Y), int(c.green))
+		// translucent pixels are composited onto black
+		assertInt(t, int(gray.GrayAt(1, 0).Y), int(c.faint))
+		assertInt(t, int(gray.GrayAt(2, 0).Y), 0)
+		assertInt(t, len(gray.Pix), 3)
+	}
+
+	_, err := Grayscale(src, "hdr")
+	assertError(t, err, ErrInvalidLuma)
+}
@@ -1,6 +1,7 @@
 package gato
 
 import (
+	"context"
 	"errors"
 	"image"
 	"image/draw"
@@ -24,36 +25,30 @@ type Data struct {
 
 // NewData creates a new Data instance from a file name and a reader.
 // Only jpg/jpeg and png formats are supported.
-// It also creates a new *image.RGBA instance from the reader.
+// It also creates a new *image.RGBA instance from the reader, whose buffer can be reused after calling Data.Release.
+// The decoders of the standard library still allocate the decoded image on every call, before it is converted into that buffer.
 func NewData(fileName string, r io.Reader) (*Data, error) {
-	// extract name and format from fileName
-	re, err := regexp.Compile(`^(.+)\.([^.]+)$`)
+	return NewDataContext(context.Background(), fileName, r)
+}
+
+// NewDataContext is like NewData, but it stops reading r as soon as ctx is done and returns ctx.Err().
+func NewDataContext(ctx context.Context, fileName string, r io.Reader) (*Data, error) {
+	imgName, format, err := splitFileName(fileName)
 	if err != nil {
 		return nil, err
 	}
-	matches := re.FindStringSubmatch(fileName)
-	if len(matches) != 3 {
-		return nil, ErrInvalidFileName
-	}
-
-	imgName := matches[1]
-	format := matches[2]
-	if format == "jpg" {
-		format = "jpeg"
-	}
-	if format != "jpeg" && format != "png" {
-		return nil, ErrInvalidFormat
-	}
 
 	// decode []byte to *image.RGBA
-	dec, _, err := image.Decode(r)
+	dec, _, err := image.Decode(&contextReader{ctx: ctx, r: r})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if err != nil {
 		return nil, err
 	}
 	rect := dec.Bounds()
-	w, h := rect.Size().X, rect.Size().Y
-	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
-	draw.Draw(rgba, rect, dec, rect.Min, draw.Src)
+	rgba := getBuffer(rect.Dx(), rect.Dy())
+	draw.Draw(rgba, rgba.Bounds(), dec, rect.Min, draw.Src)
 
 	data := &Data{
 		Name:   imgName,
@@ -63,3 +58,47 @@ func NewData(fileName string, r io.Reader) (*Data, error) {
 
 	return data, nil
 }
+
+// Release gives the buffer of d.Image back for reuse by later calls of NewData and Processor.ProcessInto.
+// d.Image must not be used afterwards, and it is set to nil.
+func (d *Data) Release() {
+	if d.Image != nil {
+		putBuffer(d.Image)
+		d.Image = nil
+	}
+}
+
+// contextReader is an io.Reader that fails with ctx.Err() once ctx is done, so that decoders stop early.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+var fileNameRe = regexp.MustCompile(`^(.+)\.([^.]+)$`)
+
+// splitFileName extracts the name and the format of the image from fileName.
+// Only jpg/jpeg and png formats are supported, and jpg is reported as jpeg.
+func splitFileName(fileName string) (name, format string, err error) {
+	matches := fileNameRe.FindStringSubmatch(fileName)
+	if len(matches) != 3 {
+		return "", "", ErrInvalidFileName
+	}
+
+	name = matches[1]
+	format = matches[2]
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	if format != FormatJPEG && format != FormatPNG {
+		return "", "", ErrInvalidFormat
+	}
+
+	return name, format, nil
+}
@@ -1,7 +1,10 @@
 package gato
 
 import (
+	"bytes"
+	"context"
 	"image"
+	"image/png"
 	"testing"
 )
 
@@ -52,4 +55,29 @@ func TestData(t *testing.T) {
 		want := image.ErrFormat
 		assertError(t, got, want)
 	})
+
+	t.Run("return the context error when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, got := NewDataContext(ctx, "norwich-terrier.jpg", newStubImageReader())
+		assertError(t, got, context.Canceled)
+	})
+
+	t.Run("give the image buffer back on release", func(t *testing.T) {
+		data, _ := NewData("norwich-terrier.jpg", newStubImageReader())
+		data.Release()
+		if data.Image != nil {
+			t.Error("got an image after release")
+		}
+	})
+}
+
+func BenchmarkNewData(b *testing.B) {
+	buf := new(bytes.Buffer)
+	_ = png.Encode(buf, newGradientImage(640, 480))
+	b.ReportAllocs()
+	for b.Loop() {
+		d, _ := NewData("gradient.png", bytes.NewReader(buf.Bytes()))
+		d.Release()
+	}
 }
@@ -0,0 +1,437 @@
+package gato
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+var (
+	ErrInvalidKernel = errors.New("invalid kernel: kernels must have an odd number of finite weights, and at least one kernel must be set")
+	ErrInvalidSigma  = errors.New("invalid sigma: sigma must be greater than 0")
+	ErrInvalidRadius = errors.New("invalid radius: radius must be greater than 0, and passes must be between 0 and 10")
+)
+
+// maxBoxPasses is the largest number of passes of BoxBlur, beyond which more passes barely change the blur.
+const maxBoxPasses = 10
+
+// boxBlurSigma is the sigma from which Blur is approximated by three box blurs, whose cost does not grow with the radius.
+// Below it, the Gaussian kernel is exact and still cheap.
+const boxBlurSigma = 8
+
+// pass is the filter of one direction of a separable filter.
+type pass struct {
+	// pad is the number of pixels that filter reads past both ends of a line
+	pad int
+	// filter filters line, whose pixels are 4 interleaved channels extended by pad pixels on both sides, into dst.
+	// It may overwrite line and tmp, which is as long as line, so that no buffer is allocated per line.
+	filter func(dst, line, tmp []float32)
+}
+
+// identityPass leaves lines as they are.
+var identityPass = pass{filter: func(dst, line, tmp []float32) { copy(dst, line) }}
+
+// kernelPass returns the pass that weighs the pixels around every pixel with k, centered on its middle weight.
+func kernelPass(k []float32) pass {
+	return pass{pad: len(k) / 2, filter: func(dst, line, tmp []float32) {
+		for i := 0; i < len(dst); i += 4 {
+			var acc [4]float32
+			for j, wt := range k {
+				o := i + 4*j
+				acc[0] += wt * line[o]
+				acc[1] += wt * line[o+1]
+				acc[2] += wt * line[o+2]
+				acc[3] += wt * line[o+3]
+			}
+			copy(dst[i:i+4], acc[:])
+		}
+	}}
+}
+
+// boxPass returns the pass that averages every pixel with its neighbors within each of radii in turn.
+// Its cost does not depend on the radii, as every box is a running sum.
+func boxPass(radii []int) pass {
+	var pad int
+	for _, r := range radii {
+		pad += r
+	}
+	return pass{pad: pad, filter: func(dst, line, tmp []float32) {
+		n := len(line) / 4
+		a, b := line, tmp
+		for _, r := range radii {
+			inv := 1 / float64(2*r+1)
+			for c := range 4 {
+				// the window [i-r, i+r] is clamped to the ends of the line, which only the padding is close enough to see
+				var sum float64
+				for j := -r; j <= r; j++ {
+					sum += float64(a[4*min(max(j, 0), n-1)+c])
+				}
+				for i := range n {
+					b[4*i+c] = float32(sum * inv)
+					sum += float64(a[4*min(i+r+1, n-1)+c]) - float64(a[4*max(i-r, 0)+c])
+				}
+			}
+			a, b = b, a
+		}
+		copy(dst, a[4*pad:])
+	}}
+}
+
+// gaussianKernel returns the Gaussian kernel of sigma, cut at 3 sigma and normalized.
+func gaussianKernel(sigma float64) []float32 {
+	r := int(math.Ceil(3 * sigma))
+	k := make([]float32, 2*r+1)
+	var sum float64
+	w := make([]float64, len(k))
+	for i := range w {
+		x := float64(i - r)
+		w[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += w[i]
+	}
+	for i := range w {
+		k[i] = float32(w[i] / sum)
+	}
+	return k
+}
+
+// gaussianBoxes returns the radii of the three box blurs whose succession approximates the Gaussian blur of sigma,
+// following "Fast Almost-Gaussian Filtering" by Peter Kovesi.
+func gaussianBoxes(sigma float64) []int {
+	const n = 3
+	// the widths of the boxes are the odd widths around the ideal one, so that their variances add up to sigma^2
+	wl := int(math.Floor(math.Sqrt(12*sigma*sigma/n + 1)))
+	if wl%2 == 0 {
+		wl--
+	}
+	wu := wl + 2
+	m := int(math.Round((12*sigma*sigma - n*float64(wl*wl) - 4*n*float64(wl) - 3*n) / (-4*float64(wl) - 4)))
+	radii := make([]int, n)
+	for i := range radii {
+		if i < m {
+			radii[i] = (wl - 1) / 2
+		} else {
+			radii[i] = (wu - 1) / 2
+		}
+	}
+	return radii
+}
+
+// separable runs h along the rows of src, then v along its columns, and returns the result in a buffer taken from s.
+// Lines are extended past the edges of src following extend, and bg is the color of the canvas with ExtendColor.
+// The channels are filtered premultiplied, so that transparent pixels do not bleed their color.
+func separable(s *scratch, src *image.RGBA, extend Extend, bg color.RGBA, h, v pass) (*image.RGBA, error) {
+	if extend == "" {
+		extend = ExtendEdge
+	}
+	w, ht := src.Bounds().Dx(), src.Bounds().Dy()
+	bgv := [4]float32{float32(bg.R), float32(bg.G), float32(bg.B), float32(bg.A)}
+
+	// every worker takes a line, its working space and its output from a single buffer, of the same size for both passes,
+	// so that the buffers of either pass can be reused by the other one
+	hn, vn := 4*(w+2*h.pad), 4*(ht+2*v.pad)
+	n := 3 * max(hn, vn)
+
+	rows := s.floats(4 * w * ht)
+	err := s.parallel(ht, func(start, end int) {
+		buf := s.line(n)
+		defer s.putLine(buf)
+		line, tmp := (*buf)[:hn], (*buf)[hn:2*hn]
+		for y := start; y < end; y++ {
+			for i := range w + 2*h.pad {
+				x := i - h.pad
+				if (x < 0 || x >= w) && extend == ExtendColor {
+					copy(line[4*i:], bgv[:])
+					continue
+				}
+				c := src.RGBAAt(src.Rect.Min.X+extendIndex(x, w, extend), src.Rect.Min.Y+y)
+				line[4*i], line[4*i+1], line[4*i+2], line[4*i+3] = float32(c.R), float32(c.G), float32(c.B), float32(c.A)
+			}
+			h.filter(rows[4*w*y:4*w*(y+1)], line, tmp)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the rows past the edges of the canvas are the background filtered along them
+	var bgh [4]float32
+	if extend == ExtendColor {
+		line := make([]float32, 4*(1+2*h.pad))
+		for i := 0; i < len(line); i += 4 {
+			copy(line[i:], bgv[:])
+		}
+		h.filter(bgh[:], line, make([]float32, len(line)))
+	}
+
+	dst := s.get(w, ht)
+	err = s.parallel(w, func(start, end int) {
+		buf := s.line(n)
+		defer s.putLine(buf)
+		line, tmp, out := (*buf)[:vn], (*buf)[vn:2*vn], (*buf)[2*vn:2*vn+4*ht]
+		for x := start; x < end; x++ {
+			for i := range ht + 2*v.pad {
+				y := i - v.pad
+				if (y < 0 || y >= ht) && extend == ExtendColor {
+					copy(line[4*i:], bgh[:])
+					continue
+				}
+				copy(line[4*i:4*i+4], rows[4*(w*extendIndex(y, ht, extend)+x):])
+			}
+			v.filter(out, line, tmp)
+
+			for y := range ht {
+				// premultiplied colors never exceed their alpha, which kernels with negative weights could break
+				a := clamp(float64(out[4*y+3]))
+				dst.SetRGBA(x, y, color.RGBA{
+					min(clamp(float64(out[4*y])), a),
+					min(clamp(float64(out[4*y+1])), a),
+					min(clamp(float64(out[4*y+2])), a),
+					a,
+				})
+			}
+		}
+	})
+	if err != nil {
+		s.put(dst)
+		return nil, err
+	}
+
+	return dst, nil
+}
+
+// Convolve is an operation that filters an image with a separable kernel, along its rows with Horizontal, then along its columns with Vertical.
+// The weight i of a kernel applies to the pixel i - len(kernel)/2 away from the filtered one, e.g. [-1, 0, 1] is the difference
+// between the next pixel and the previous one.
+type Convolve struct {
+	// Horizontal is the kernel along rows. If it is not set, rows are left as they are.
+	Horizontal []float64 `json:"horizontal,omitempty"`
+	// Vertical is the kernel along columns. If it is not set, columns are left as they are.
+	Vertical []float64 `json:"vertical,omitempty"`
+	// Extend decides how the pixels past the edges are read. If it is not set, it defaults to ExtendEdge.
+	Extend Extend `json:"extend,omitempty"`
+	// Background is the color past the edges with ExtendColor. The zero value is transparent.
+	Background color.RGBA `json:"-"`
+}
+
+func (cv Convolve) kind() string {
+	return "convolve"
+}
+
+func (cv Convolve) validate() error {
+	if len(cv.Horizontal) == 0 && len(cv.Vertical) == 0 {
+		return fieldError("horizontal", ErrInvalidKernel)
+	}
+	for _, k := range []struct {
+		field  string
+		kernel []float64
+	}{{"horizontal", cv.Horizontal}, {"vertical", cv.Vertical}} {
+		if len(k.kernel) == 0 {
+			continue
+		}
+		if len(k.kernel)%2 == 0 {
+			return fieldError(k.field, ErrInvalidKernel)
+		}
+		for _, wt := range k.kernel {
+			if math.IsNaN(wt) || math.IsInf(wt, 0) {
+				return fieldError(k.field, ErrInvalidKernel)
+			}
+		}
+	}
+	if err := cv.Extend.validate(); err != nil {
+		return fieldError("extend", err)
+	}
+	return nil
+}
+
+func (cv Convolve) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	passOf := func(k []float64) pass {
+		if len(k) == 0 {
+			return identityPass
+		}
+		k32 := make([]float32, len(k))
+		for i, wt := range k {
+			k32[i] = float32(wt)
+		}
+		return kernelPass(k32)
+	}
+	return separable(s, src, cv.Extend, cv.Background, passOf(cv.Horizontal), passOf(cv.Vertical))
+}
+
+// Apply returns a new image of the source filtered following the operation.
+func (cv Convolve) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := cv.validate(); err != nil {
+		return nil, err
+	}
+	return cv.apply(&scratch{}, src)
+}
+
+func (cv Convolve) MarshalJSON() ([]byte, error) {
+	type alias Convolve
+	return marshalOperation(cv.kind(), struct {
+		alias
+		Background *hexColor `json:"background,omitempty"`
+	}{alias(cv), newHexColor(cv.Background)})
+}
+
+func (cv *Convolve) UnmarshalJSON(data []byte) error {
+	type alias Convolve
+	v := struct {
+		Op string `json:"op"`
+		*alias
+		Background string `json:"background"`
+	}{alias: (*alias)(cv)}
+	if err := decodeStrict(data, &v); err != nil {
+		return err
+	}
+	bg, err := decodeColor("background", v.Background)
+	if err != nil {
+		return err
+	}
+	cv.Background = bg
+	return nil
+}
+
+// Blur is an operation that blurs an image with a Gaussian of standard deviation Sigma.
+// From a sigma of 8 pixels, the Gaussian is approximated by three box blurs, so that large blurs cost as little as small ones.
+type Blur struct {
+	// Sigma is the standard deviation of the Gaussian in pixels.
+	// It is capped to the larger dimension of the image, beyond which the blur is about uniform anyway.
+	Sigma float64 `json:"sigma"`
+	// Extend decides how the pixels past the edges are read. If it is not set, it defaults to ExtendEdge.
+	Extend Extend `json:"extend,omitempty"`
+	// Background is the color past the edges with ExtendColor. The zero value is transparent.
+	Background color.RGBA `json:"-"`
+}
+
+func (b Blur) kind() string {
+	return "blur"
+}
+
+func (b Blur) validate() error {
+	if !(b.Sigma > 0) || math.IsInf(b.Sigma, 0) {
+		return fieldError("sigma", ErrInvalidSigma)
+	}
+	if err := b.Extend.validate(); err != nil {
+		return fieldError("extend", err)
+	}
+	return nil
+}
+
+func (b Blur) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	// the lines are padded by about 1.5 sigma, which must not outgrow the image
+	sigma := min(b.Sigma, float64(max(src.Bounds().Dx(), src.Bounds().Dy(), 1)))
+	p := kernelPass(gaussianKernel(sigma))
+	if sigma >= boxBlurSigma {
+		p = boxPass(gaussianBoxes(sigma))
+	}
+	return separable(s, src, b.Extend, b.Background, p, p)
+}
+
+// Apply returns a new image of the source blurred following the operation.
+func (b Blur) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+	return b.apply(&scratch{}, src)
+}
+
+func (b Blur) MarshalJSON() ([]byte, error) {
+	type alias Blur
+	return marshalOperation(b.kind(), struct {
+		alias
+		Background *hexColor `json:"background,omitempty"`
+	}{alias(b), newHexColor(b.Background)})
+}
+
+func (b *Blur) UnmarshalJSON(data []byte) error {
+	type alias Blur
+	v := struct {
+		Op string `json:"op"`
+		*alias
+		Background string `json:"background"`
+	}{alias: (*alias)(b)}
+	if err := decodeStrict(data, &v); err != nil {
+		return err
+	}
+	bg, err := decodeColor("background", v.Background)
+	if err != nil {
+		return err
+	}
+	b.Background = bg
+	return nil
+}
+
+// BoxBlur is an operation that replaces every pixel with the average of the square of side 2*Radius+1 around it.
+// Its cost does not depend on Radius, and three passes are close to a Gaussian blur.
+type BoxBlur struct {
+	// Radius is capped to the larger dimension of the image, beyond which the blur is about uniform anyway.
+	Radius int `json:"radius"`
+	// Passes is the number of times the box is applied, up to 10. If it is not set, it defaults to 1.
+	Passes int `json:"passes,omitempty"`
+	// Extend decides how the pixels past the edges are read. If it is not set, it defaults to ExtendEdge.
+	Extend Extend `json:"extend,omitempty"`
+	// Background is the color past the edges with ExtendColor. The zero value is transparent.
+	Background color.RGBA `json:"-"`
+}
+
+func (bb BoxBlur) kind() string {
+	return "box_blur"
+}
+
+func (bb BoxBlur) validate() error {
+	if bb.Radius <= 0 {
+		return fieldError("radius", ErrInvalidRadius)
+	}
+	if bb.Passes < 0 || bb.Passes > maxBoxPasses {
+		return fieldError("passes", ErrInvalidRadius)
+	}
+	if err := bb.Extend.validate(); err != nil {
+		return fieldError("extend", err)
+	}
+	return nil
+}
+
+func (bb BoxBlur) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	radii := make([]int, max(bb.Passes, 1))
+	for i := range radii {
+		// the lines are padded by every radius, which must not outgrow the image
+		radii[i] = min(bb.Radius, max(src.Bounds().Dx(), src.Bounds().Dy(), 1))
+	}
+	p := boxPass(radii)
+	return separable(s, src, bb.Extend, bb.Background, p, p)
+}
+
+// Apply returns a new image of the source blurred following the operation.
+func (bb BoxBlur) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := bb.validate(); err != nil {
+		return nil, err
+	}
+	return bb.apply(&scratch{}, src)
+}
+
+func (bb BoxBlur) MarshalJSON() ([]byte, error) {
+	type alias BoxBlur
+	return marshalOperation(bb.kind(), struct {
+		alias
+		Background *hexColor `json:"background,omitempty"`
+	}{alias(bb), newHexColor(bb.Background)})
+}
+
+func (bb *BoxBlur) UnmarshalJSON(data []byte) error {
+	type alias BoxBlur
+	v := struct {
+		Op string `json:"op"`
+		*alias
+		Background string `json:"background"`
+	}{alias: (*alias)(bb)}
+	if err := decodeStrict(data, &v); err != nil {
+		return err
+	}
+	bg, err := decodeColor("background", v.Background)
+	if err != nil {
+		return err
+	}
+	bb.Background = bg
+	return nil
+}
@@ -0,0 +1,234 @@
+package gato
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// newImpulseImage returns an opaque black image of size w x h with a single white pixel at its center.
+func newImpulseImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	img.SetRGBA(w/2, h/2, color.RGBA{255, 255, 255, 255})
+	return img
+}
+
+func TestConvolve(t *testing.T) {
+	t.Run("leave the image as it is with the identity kernel", func(t *testing.T) {
+		src := newGradientImage(7, 5)
+		dst, err := Convolve{Horizontal: []float64{0, 1, 0}, Vertical: []float64{1}}.Apply(src)
+		assertError(t, err, nil)
+		for y := range 5 {
+			for x := range 7 {
+				if dst.RGBAAt(x, y) != src.RGBAAt(x, y) {
+					t.Fatalf("got %v at (%d, %d), want %v", dst.RGBAAt(x, y), x, y, src.RGBAAt(x, y))
+				}
+			}
+		}
+	})
+
+	t.Run("apply the weights in the order they are listed", func(t *testing.T) {
+		src := image.NewRGBA(image.Rect(0, 0, 3, 1))
+		src.SetRGBA(2, 0, color.RGBA{200, 200, 200, 255})
+		dst, err := Convolve{Horizontal: []float64{0, 0, 1}}.Apply(src)
+		assertError(t, err, nil)
+		assertInt(t, int(dst.RGBAAt(1, 0).A), 255)
+		assertInt(t, int(dst.RGBAAt(0, 0).A), 0)
+	})
+
+	t.Run("read past the edges following the extend mode", func(t *testing.T) {
+		src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		for i := range src.Pix {
+			src.Pix[i] = 255
+		}
+		box := []float64{1.0 / 3, 1.0 / 3, 1.0 / 3}
+		edge, err := Convolve{Horizontal: box, Vertical: box}.Apply(src)
+		assertError(t, err, nil)
+		assertInt(t, int(edge.RGBAAt(0, 0).A), 255)
+		transparent, err := Convolve{Horizontal: box, Vertical: box, Extend: ExtendColor}.Apply(src)
+		assertError(t, err, nil)
+		// 4 of the 9 pixels around the corner are in the image
+		assertInt(t, int(transparent.RGBAAt(0, 0).A), 113)
+		assertInt(t, int(transparent.RGBAAt(1, 1).A), 255)
+	})
+
+	t.Run("keep premultiplied colors within their alpha", func(t *testing.T) {
+		src := newGradientImage(8, 8)
+		dst, err := Convolve{Horizontal: []float64{-1, 3, -1}}.Apply(src)
+		assertError(t, err, nil)
+		for i := 0; i < len(dst.Pix); i += 4 {
+			if a := dst.Pix[i+3]; dst.Pix[i] > a || dst.Pix[i+1] > a || dst.Pix[i+2] > a {
+				t.Fatalf("got %v, whose colors exceed its alpha", dst.Pix[i:i+4])
+			}
+		}
+	})
+
+	t.Run("return error when the kernel is invalid", func(t *testing.T) {
+		_, err := Convolve{}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidKernel)
+		_, err = Convolve{Vertical: []float64{1, 1}}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidKernel)
+		_, err = Convolve{Horizontal: []float64{math.NaN()}}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidKernel)
+	})
+}
+
+func TestBlur(t *testing.T) {
+	t.Run("spread an impulse symmetrically and keep its energy", func(t *testing.T) {
+		dst, err := Blur{Sigma: 1.5}.Apply(newImpulseImage(21, 21))
+		assertError(t, err, nil)
+		var sum int
+		for y := range 21 {
+			for x := range 21 {
+				sum += int(dst.RGBAAt(x, y).R)
+			}
+		}
+		if sum < 245 || sum > 265 {
+			t.Errorf("got a total of %d, want about 255", sum)
+		}
+		c := dst.RGBAAt(10, 10).R
+		if c == 255 || c != dst.RGBAAt(10, 10).G {
+			t.Errorf("got %v at the center", dst.RGBAAt(10, 10))
+		}
+		if dst.RGBAAt(8, 10) != dst.RGBAAt(12, 10) || dst.RGBAAt(10, 8) != dst.RGBAAt(8, 10) {
+			t.Errorf("got an asymmetric blur")
+		}
+	})
+
+	t.Run("approximate large blurs with box blurs", func(t *testing.T) {
+		src := newImpulseImage(101, 101)
+		s := &scratch{}
+		exact, err := separable(s, src, ExtendEdge, color.RGBA{}, kernelPass(gaussianKernel(10)), kernelPass(gaussianKernel(10)))
+		assertError(t, err, nil)
+		approx, err := Blur{Sigma: 10}.Apply(src)
+		assertError(t, err, nil)
+		for y := range 101 {
+			for x := range 101 {
+				if d := int(exact.RGBAAt(x, y).R) - int(approx.RGBAAt(x, y).R); d < -1 || d > 1 {
+					t.Fatalf("got %v at (%d, %d), want about %v", approx.RGBAAt(x, y), x, y, exact.RGBAAt(x, y))
+				}
+			}
+		}
+	})
+
+	t.Run("match the variance of the Gaussian with the boxes", func(t *testing.T) {
+		for _, sigma := range []float64{8, 12.5, 40} {
+			var variance float64
+			for _, r := range gaussianBoxes(sigma) {
+				w := float64(2*r + 1)
+				variance += (w*w - 1) / 12
+			}
+			if d := math.Sqrt(variance) - sigma; d < -0.5 || d > 0.5 {
+				t.Errorf("sigma %v: got boxes of sigma %v", sigma, math.Sqrt(variance))
+			}
+		}
+	})
+
+	t.Run("cap sigma to the size of the image", func(t *testing.T) {
+		src := newImpulseImage(9, 9)
+		capped, err := Blur{Sigma: 1e12}.Apply(src)
+		assertError(t, err, nil)
+		want, _ := Blur{Sigma: 9}.Apply(src)
+		if !reflect.DeepEqual(capped.Pix, want.Pix) {
+			t.Error("got a different blur, want the blur of sigma 9")
+		}
+	})
+
+	t.Run("return error when sigma is invalid", func(t *testing.T) {
+		_, err := Blur{}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidSigma)
+		_, err = Blur{Sigma: 1, Extend: "wrap"}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidExtend)
+	})
+}
+
+func TestBoxBlur(t *testing.T) {
+	t.Run("average the square around every pixel", func(t *testing.T) {
+		dst, err := BoxBlur{Radius: 1}.Apply(newImpulseImage(5, 5))
+		assertError(t, err, nil)
+		for y := 1; y <= 3; y++ {
+			for x := 1; x <= 3; x++ {
+				assertInt(t, int(dst.RGBAAt(x, y).R), 28)
+			}
+		}
+		assertInt(t, int(dst.RGBAAt(0, 0).R), 0)
+	})
+
+	t.Run("keep a uniform image as it is whatever the radius", func(t *testing.T) {
+		src := image.NewRGBA(image.Rect(0, 0, 6, 4))
+		for i := range src.Pix {
+			src.Pix[i] = 100
+		}
+		dst, err := BoxBlur{Radius: 50, Passes: 3}.Apply(src)
+		assertError(t, err, nil)
+		if got := dst.RGBAAt(3, 2); got != (color.RGBA{100, 100, 100, 100}) {
+			t.Errorf("got %v, want the uniform color", got)
+		}
+	})
+
+	t.Run("cap the radius to the size of the image", func(t *testing.T) {
+		src := newImpulseImage(6, 4)
+		capped, err := BoxBlur{Radius: math.MaxInt32, Passes: 2}.Apply(src)
+		assertError(t, err, nil)
+		want, _ := BoxBlur{Radius: 6, Passes: 2}.Apply(src)
+		if !reflect.DeepEqual(capped.Pix, want.Pix) {
+			t.Error("got a different blur, want the blur of radius 6")
+		}
+	})
+
+	t.Run("filter lines without allocating", func(t *testing.T) {
+		p := boxPass([]int{2, 3, 3})
+		line := make([]float32, 4*(64+2*p.pad))
+		tmp, dst := make([]float32, len(line)), make([]float32, 4*64)
+		if n := testing.AllocsPerRun(10, func() { p.filter(dst, line, tmp) }); n != 0 {
+			t.Errorf("got %v allocations per line, want 0", n)
+		}
+	})
+
+	t.Run("return error when the radius is invalid", func(t *testing.T) {
+		_, err := BoxBlur{}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidRadius)
+		_, err = BoxBlur{Radius: 1, Passes: -1}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidRadius)
+		_, err = BoxBlur{Radius: 1, Passes: 11}.Apply(newGradientImage(4, 4))
+		assertValidationError(t, err, -1, "passes", ErrInvalidRadius)
+		var bb BoxBlur
+		err = json.Unmarshal([]byte(`{"op":"box_blur","radius":1,"passes":1000000000}`), &bb)
+		assertError(t, err, nil)
+		_, err = bb.Apply(newGradientImage(4, 4))
+		assertValidationError(t, err, -1, "passes", ErrInvalidRadius)
+	})
+}
+
+func BenchmarkBlur(b *testing.B) {
+	// the allocations do not grow with the size of the image once the buffer pools are warm
+	for _, size := range []image.Point{{160, 120}, {640, 480}} {
+		ops := []struct {
+			name string
+			op   Operation
+		}{
+			{"gaussian", Blur{Sigma: 2}},
+			{"boxes", Blur{Sigma: 10}},
+			{"box", BoxBlur{Radius: 3, Passes: 3}},
+		}
+		for _, c := range ops {
+			op := c.op
+			b.Run(fmt.Sprintf("%s/%dx%d", c.name, size.X, size.Y), func(b *testing.B) {
+				d := &Data{Image: newGradientImage(size.X, size.Y)}
+				p, _ := NewProcessor(Instruction{Pipeline: []Operation{op}})
+				dst := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+				b.ReportAllocs()
+				for b.Loop() {
+					_ = p.ProcessInto(dst, d)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package gato
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxArea is the maximum number of pixels of the images that a Handler processes by default, about 16 megapixels.
+	DefaultMaxArea = 4096 * 4096
+	// DefaultMaxSourceArea is the maximum number of pixels of the source images that a Handler decodes by default, about 67 megapixels.
+	DefaultMaxSourceArea = 8192 * 8192
+)
+
+var (
+	ErrImageTooLarge  = errors.New("image too large: the processed image exceeds the maximum area")
+	ErrSourceTooLarge = errors.New("source image too large: the source image exceeds the maximum area")
+)
+
+// Handler is an http.Handler that serves the images of Source processed following the URL parameters of the request.
+// Parameters are read from the leading path segments and the query string, e.g. /w:300/photos/dog.jpg or /photos/dog.jpg?w=300.
+//
+// It answers conditional requests with If-None-Match and If-Modified-Since before any decoding happens.
+type Handler struct {
+	// Source is the file system that the original images are read from, e.g. os.DirFS("images").
+	Source fs.FS
+	// CacheControl is the value of the Cache-Control header of successful responses. If it is not set, the header is omitted.
+	CacheControl string
+	// Presets are named Params requested with the preset query parameter, e.g. ?preset=thumbnail.
+	// Other parameters of the request override the ones of the preset.
+	Presets map[string]Params
+	// Signer verifies the signature of every request before the source image is even opened.
+	// If it is not set, requests are not signed.
+	Signer *Signer
+	// Formats are the output formats negotiated with the Accept header of requests without the fmt parameter, by order of preference.
+	// If it is not set, the format of the source image is kept.
+	Formats []string
+	// Cache stores the encoded images, so that repeated requests are not processed again.
+	// If it is not set, every request is processed.
+	Cache *Cache
+	// Pool runs the processing of all requests. If it is not set, DefaultPool() is used.
+	Pool *Pool
+	// MaxArea is the maximum number of pixels, width times height, of the processed images, so that a single request
+	// cannot exhaust the memory of the server. Larger requests are rejected with 400 Bad Request.
+	// If it is not set, it defaults to DefaultMaxArea.
+	MaxArea int
+	// MaxSourceArea is the maximum number of pixels of the source images, which is read from their header before they are decoded,
+	// so that a small but highly compressed file cannot exhaust the memory of the server either.
+	// Larger sources are rejected with 422 Unprocessable Entity. If it is not set, it defaults to DefaultMaxSourceArea.
+	MaxSourceArea int
+}
+
+// NewHandler creates a new Handler instance serving the images of src, cacheable for a day.
+func NewHandler(src fs.FS) *Handler {
+	return &Handler{
+		Source:       src,
+		CacheControl: "public, max-age=86400",
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.Signer != nil {
+		if err := h.Signer.Verify(r.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	// parameters of the query string take precedence over the ones of the path
+	q, name := pathValues(r.URL.Path)
+	for key, v := range r.URL.Query() {
+		q[key] = v
+	}
+	var p Params
+	if preset := q.Get("preset"); preset != "" {
+		var ok bool
+		if p, ok = h.Presets[preset]; !ok {
+			http.Error(w, fieldError("preset", ErrUnknownPreset).Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := p.parse(q); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the requested dimensions alone may exceed the limit, whatever the source image
+	if c := p.Canonical(); c.Width > h.maxArea() || c.Height > h.maxArea() || float64(c.Width)*float64(c.Height) > float64(h.maxArea()) {
+		replyError(w, r, tooLarge(p))
+		return
+	}
+
+	if !fs.ValidPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := h.Source.Open(name)
+	if err != nil {
+		replyError(w, r, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		replyError(w, r, err)
+		return
+	}
+	if info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	header := w.Header()
+	_, source, err := splitFileName(path.Base(name))
+	if err != nil {
+		replyError(w, r, err)
+		return
+	}
+	// the format depends on whether the processed image has transparency, so both outcomes are part of the entity tag
+	var opaque, alpha string
+	if len(h.Formats) > 0 && p.Format == "" {
+		header.Set("Vary", "Accept")
+		opaque = h.negotiate(r, false, source)
+		alpha = h.negotiate(r, true, source)
+	}
+	header.Set("ETag", etag(name, info, p, opaque+"\x00"+alpha))
+	if !info.ModTime().IsZero() {
+		header.Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	}
+	if h.CacheControl != "" {
+		header.Set("Cache-Control", h.CacheControl)
+	}
+	if notModified(r, header.Get("ETag"), info.ModTime()) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	var b []byte
+	if h.Cache != nil {
+		// the entity tag identifies the representation, so it is the cache key as well
+		// the rendering is shared with concurrent requests and stored, so it outlives the request that started it
+		ctx := context.WithoutCancel(r.Context())
+		b, err = h.Cache.Do(strings.Trim(header.Get("ETag"), `"`), func() ([]byte, error) {
+			return h.render(ctx, f, name, p, opaque, alpha)
+		})
+	} else {
+		b, err = h.render(r.Context(), f, name, p, opaque, alpha)
+	}
+	if err != nil {
+		replyError(w, r, err)
+		return
+	}
+
+	// the encoded image tells its format, which may have been negotiated after processing
+	header.Set("Content-Type", http.DetectContentType(b))
+	header.Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(b)
+	}
+}
+
+// render decodes the image file f named name, processes it following p and encodes it, unless ctx is done first.
+// If opaque and alpha are set, they are the negotiated output formats of opaque and transparent images respectively.
+func (h *Handler) render(ctx context.Context, f io.Reader, name string, p Params, opaque, alpha string) ([]byte, error) {
+	d, err := decodeSource(ctx, path.Base(name), f, h.maxSourceArea())
+	if err != nil {
+		return nil, err
+	}
+	// the images are done with once encoded, so their buffers are reused by later requests
+	defer d.Release()
+	img := d.Image
+	if i, ok := p.Instruction(); ok {
+		if p.area(img.Bounds().Dx(), img.Bounds().Dy()) > float64(h.maxArea()) {
+			return nil, tooLarge(p)
+		}
+		prc, err := NewProcessor(i)
+		if err != nil {
+			return nil, err
+		}
+		prc.Pool = h.Pool
+		if img, err = prc.ProcessContext(ctx, d); err != nil {
+			return nil, err
+		}
+		defer putBuffer(img)
+	}
+
+	o := p.Output(d.Format)
+	if opaque != "" {
+		o.Format = opaque
+		if !img.Opaque() {
+			o.Format = alpha
+		}
+	}
+	b := new(bytes.Buffer)
+	if err := o.Encode(b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// maxArea returns h.MaxArea, which defaults to DefaultMaxArea.
+func (h *Handler) maxArea() int {
+	if h.MaxArea > 0 {
+		return h.MaxArea
+	}
+	return DefaultMaxArea
+}
+
+func (h *Handler) maxSourceArea() int {
+	if h.MaxSourceArea > 0 {
+		return h.MaxSourceArea
+	}
+	return DefaultMaxSourceArea
+}
+
+// decodeSource is like NewDataContext, but it reads the size of the image from its header first,
+// and returns an error ErrSourceTooLarge without decoding it when its area exceeds maxArea.
+func decodeSource(ctx context.Context, fileName string, r io.Reader, maxArea int) (*Data, error) {
+	if _, _, err := splitFileName(fileName); err != nil {
+		return nil, err
+	}
+	// the header read for the configuration is read again for decoding
+	head := new(bytes.Buffer)
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, head))
+	if err != nil {
+		return nil, err
+	}
+	if float64(cfg.Width)*float64(cfg.Height) > float64(maxArea) {
+		return nil, ErrSourceTooLarge
+	}
+	return NewDataContext(ctx, fileName, io.MultiReader(head, r))
+}
+
+// tooLarge returns the error of the dimensions of p exceeding the maximum area.
+func tooLarge(p Params) error {
+	if p.Width == 0 {
+		return fieldError("h", ErrImageTooLarge)
+	}
+	return fieldError("w", ErrImageTooLarge)
+}
+
+// negotiate returns the output format for r among h.Formats, or source if r accepts none of them.
+func (h *Handler) negotiate(r *http.Request, hasAlpha bool, source string) string {
+	f, ok := Negotiate(r.Header.Get("Accept"), hasAlpha, h.Formats)
+	if !ok {
+		return source
+	}
+	return f
+}
+
+// replyError replies to the request with the HTTP status code matching err.
+func replyError(w http.ResponseWriter, r *http.Request, err error) {
+	// errors must not be cached as the image
+	w.Header().Del("ETag")
+	w.Header().Del("Last-Modified")
+	w.Header().Del("Cache-Control")
+
+	var ve *ValidationError
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, ErrInvalidFileName), errors.Is(err, ErrInvalidFormat):
+		http.NotFound(w, r)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	case errors.As(err, &ve), errors.Is(err, ErrInvalidCrop),
+		errors.Is(err, ErrBilinearSrcImageTooSmall), errors.Is(err, ErrBicubicSrcImageTooSmall):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, image.ErrFormat), errors.Is(err, ErrSourceTooLarge):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// the client is gone or the server gave up on the request
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// etag returns the strong entity tag of the image named name, whose file is described by info, processed following p.
+// variant tells apart the representations of the same parameters, e.g. the negotiated formats.
+func etag(name string, info fs.FileInfo, p Params, variant string) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%d\x00%d\x00%s\x00%s", name, info.Size(), info.ModTime().UnixNano(), p.Canonical().Encode(), variant))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified reports whether the conditional headers of r allow a 304 Not Modified response.
+// If-None-Match takes precedence over If-Modified-Since, following RFC 9110.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			// weak comparison
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modTime.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	// Last-Modified has a precision of one second
+	return !modTime.Truncate(time.Second).After(t)
+}
@@ -0,0 +1,229 @@
+package gato
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func newStubSource() fstest.MapFS {
+	b := new(bytes.Buffer)
+	_ = png.Encode(b, newGradientImage(40, 20))
+	logo := new(bytes.Buffer)
+	_ = png.Encode(logo, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	return fstest.MapFS{
+		"photos/logo.png": {Data: logo.Bytes()},
+		"photos/dog.png":  {Data: b.Bytes(), ModTime: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+		"photos/cat.jpg":  {Data: newStubImageData(), ModTime: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+		"notes.txt":       {Data: []byte("hello")},
+	}
+}
+
+// newPNGHeader returns the signature and the header chunk of a PNG image of size w x h, without any pixel data.
+func newPNGHeader(w, h int) []byte {
+	b := new(bytes.Buffer)
+	_ = png.Encode(b, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	// the signature, then the length, the type, the data and the checksum of IHDR
+	hdr := b.Bytes()[:33]
+	binary.BigEndian.PutUint32(hdr[16:], uint32(w))
+	binary.BigEndian.PutUint32(hdr[20:], uint32(h))
+	binary.BigEndian.PutUint32(hdr[29:], crc32.ChecksumIEEE(hdr[12:29]))
+	return hdr
+}
+
+func serve(h http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for key, v := range header {
+		req.Header[key] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler(t *testing.T) {
+	h := NewHandler(newStubSource())
+
+	t.Run("serve the resized image with caching headers", func(t *testing.T) {
+		rec := serve(h, http.MethodGet, "/photos/dog.png?w=20", nil)
+		assertInt(t, rec.Code, http.StatusOK)
+		assertString(t, rec.Header().Get("Content-Type"), "image/png")
+		assertString(t, rec.Header().Get("Cache-Control"), "public, max-age=86400")
+		assertString(t, rec.Header().Get("Last-Modified"), "Thu, 02 Jan 2025 03:04:05 GMT")
+		if rec.Header().Get("ETag") == "" {
+			t.Error("got no ETag")
+		}
+		img, _, err := image.Decode(rec.Body)
+		assertError(t, err, nil)
+		assertInt(t, img.Bounds().Dx(), 20)
+		assertInt(t, img.Bounds().Dy(), 10)
+	})
+
+	t.Run("read parameters from path segments", func(t *testing.T) {
+		rec := serve(h, http.MethodGet, "/w:10/fmt:jpeg/photos/dog.png", nil)
+		assertInt(t, rec.Code, http.StatusOK)
+		assertString(t, rec.Header().Get("Content-Type"), "image/jpeg")
+		img, _, _ := image.Decode(rec.Body)
+		assertInt(t, img.Bounds().Dx(), 10)
+	})
+
+	t.Run("serve the image as is without parameters", func(t *testing.T) {
+		rec := serve(h, http.MethodGet, "/photos/cat.jpg", nil)
+		assertInt(t, rec.Code, http.StatusOK)
+		assertString(t, rec.Header().Get("Content-Type"), "image/jpeg")
+	})
+
+	t.Run("reject images larger than the maximum area", func(t *testing.T) {
+		for _, target := range []string{
+			"/photos/dog.png?w=100000&h=100000",
+			"/photos/dog.png?w=5000&h=5000",
+			"/photos/missing.png?h=99999999999",
+			// the height follows the aspect ratio of the source, 8000 x 4000
+			"/photos/dog.png?w=8000",
+			"/photos/dog.png?w=4000&dpr=2",
+		} {
+			rec := serve(h, http.MethodGet, target, nil)
+			assertInt(t, rec.Code, http.StatusBadRequest)
+		}
+
+		limited := NewHandler(newStubSource())
+		limited.MaxArea = 200
+		assertInt(t, serve(limited, http.MethodGet, "/photos/dog.png?w=20", nil).Code, http.StatusOK)
+		assertInt(t, serve(limited, http.MethodGet, "/photos/dog.png?w=30", nil).Code, http.StatusBadRequest)
+		// the unprocessed source is never rejected
+		assertInt(t, serve(limited, http.MethodGet, "/photos/dog.png", nil).Code, http.StatusOK)
+	})
+
+	t.Run("reject source images beyond the maximum area before decoding them", func(t *testing.T) {
+		src := newStubSource()
+		// only the header of a 100000 x 100000 image, which decoding would fail on
+		src["photos/bomb.png"] = &fstest.MapFile{Data: newPNGHeader(100000, 100000)}
+		h := NewHandler(src)
+		rec := serve(h, http.MethodGet, "/photos/bomb.png?w=20", nil)
+		assertInt(t, rec.Code, http.StatusUnprocessableEntity)
+		if !strings.Contains(rec.Body.String(), ErrSourceTooLarge.Error()) {
+			t.Errorf("got %q, want %q", rec.Body.String(), ErrSourceTooLarge)
+		}
+
+		h.MaxSourceArea = 40*20 - 1
+		assertInt(t, serve(h, http.MethodGet, "/photos/dog.png", nil).Code, http.StatusUnprocessableEntity)
+		h.MaxSourceArea = 40 * 20
+		assertInt(t, serve(h, http.MethodGet, "/photos/dog.png?w=20", nil).Code, http.StatusOK)
+	})
+
+	t.Run("vary the ETag with the parameters", func(t *testing.T) {
+		a := serve(h, http.MethodGet, "/photos/dog.png?w=20", nil).Header().Get("ETag")
+		b := serve(h, http.MethodGet, "/photos/dog.png?w=10&dpr=2", nil).Header().Get("ETag")
+		c := serve(h, http.MethodGet, "/photos/dog.png?w=30", nil).Header().Get("ETag")
+		assertString(t, a, b)
+		if a == c {
+			t.Errorf("got the same ETag %s for different parameters", a)
+		}
+	})
+
+	t.Run("answer If-None-Match with 304", func(t *testing.T) {
+		tag := serve(h, http.MethodGet, "/photos/dog.png?w=20", nil).Header().Get("ETag")
+		rec := serve(h, http.MethodGet, "/photos/dog.png?w=20", http.Header{"If-None-Match": {`"other", ` + tag}})
+		assertInt(t, rec.Code, http.StatusNotModified)
+		assertInt(t, rec.Body.Len(), 0)
+		rec = serve(h, http.MethodGet, "/photos/dog.png?w=20", http.Header{"If-None-Match": {`"other"`}})
+		assertInt(t, rec.Code, http.StatusOK)
+	})
+
+	t.Run("answer If-Modified-Since with 304", func(t *testing.T) {
+		rec := serve(h, http.MethodGet, "/photos/dog.png", http.Header{"If-Modified-Since": {"Thu, 02 Jan 2025 03:04:05 GMT"}})
+		assertInt(t, rec.Code, http.StatusNotModified)
+		rec = serve(h, http.MethodGet, "/photos/dog.png", http.Header{"If-Modified-Since": {"Thu, 02 Jan 2025 03:04:04 GMT"}})
+		assertInt(t, rec.Code, http.StatusOK)
+	})
+
+	t.Run("send headers only for HEAD", func(t *testing.T) {
+		rec := serve(h, http.MethodHead, "/photos/dog.png?w=20", nil)
+		assertInt(t, rec.Code, http.StatusOK)
+		assertInt(t, rec.Body.Len(), 0)
+		if rec.Header().Get("Content-Length") == "" {
+			t.Error("got no Content-Length")
+		}
+	})
+
+	t.Run("negotiate the format with the Accept header", func(t *testing.T) {
+		h := NewHandler(newStubSource())
+		h.Formats = []string{FormatJPEG, FormatPNG}
+		cases := []struct {
+			target string
+			accept string
+			want   string
+		}{
+			{"/photos/dog.png", "image/*", "image/jpeg"},
+			{"/photos/dog.png", "image/webp,image/png", "image/png"},
+			{"/photos/dog.png?fmt=png", "image/jpeg", "image/png"},
+			{"/photos/logo.png", "image/*", "image/png"},
+			{"/photos/cat.jpg", "image/webp", "image/jpeg"},
+		}
+		for _, c := range cases {
+			rec := serve(h, http.MethodGet, c.target, http.Header{"Accept": {c.accept}})
+			assertInt(t, rec.Code, http.StatusOK)
+			if got := rec.Header().Get("Content-Type"); got != c.want {
+				t.Errorf("%s with %s: got %s, want %s", c.target, c.accept, got, c.want)
+			}
+		}
+
+		rec := serve(h, http.MethodGet, "/photos/dog.png", http.Header{"Accept": {"image/png"}})
+		assertString(t, rec.Header().Get("Vary"), "Accept")
+		tag := rec.Header().Get("ETag")
+		if other := serve(h, http.MethodGet, "/photos/dog.png", http.Header{"Accept": {"image/jpeg"}}).Header().Get("ETag"); other == tag {
+			t.Errorf("got the same ETag %s for different formats", tag)
+		}
+		rec = serve(h, http.MethodGet, "/photos/dog.png", http.Header{"Accept": {"image/png"}, "If-None-Match": {tag}})
+		assertInt(t, rec.Code, http.StatusNotModified)
+		assertString(t, rec.Header().Get("Vary"), "Accept")
+	})
+
+	t.Run("serve repeated requests from the cache", func(t *testing.T) {
+		store := NewMemoryStore(1 << 20)
+		h := NewHandler(newStubSource())
+		h.Cache = NewCache(store)
+		a := serve(h, http.MethodGet, "/photos/dog.png?w=20", nil)
+		assertInt(t, a.Code, http.StatusOK)
+		assertInt(t, store.Len(), 1)
+		b := serve(h, http.MethodGet, "/photos/dog.png?w=10&dpr=2", nil)
+		assertInt(t, b.Code, http.StatusOK)
+		assertInt(t, store.Len(), 1)
+		assertString(t, b.Header().Get("Content-Type"), "image/png")
+		if !bytes.Equal(a.Body.Bytes(), b.Body.Bytes()) {
+			t.Error("got a different body from the cache")
+		}
+	})
+
+	t.Run("reply with the matching error status", func(t *testing.T) {
+		cases := []struct {
+			method string
+			target string
+			want   int
+		}{
+			{http.MethodPost, "/photos/dog.png", http.StatusMethodNotAllowed},
+			{http.MethodGet, "/photos/bird.png", http.StatusNotFound},
+			{http.MethodGet, "/photos", http.StatusNotFound},
+			{http.MethodGet, "/notes.txt", http.StatusNotFound},
+			{http.MethodGet, "/photos/dog.png?w=-1", http.StatusBadRequest},
+			{http.MethodGet, "/photos/dog.png?crop=100,100,10,10", http.StatusBadRequest},
+		}
+		for _, c := range cases {
+			rec := serve(h, c.method, c.target, nil)
+			if rec.Code != c.want {
+				t.Errorf("%s %s: got %d, want %d", c.method, c.target, rec.Code, c.want)
+			}
+			if rec.Header().Get("Cache-Control") != "" {
+				t.Errorf("%s %s: got Cache-Control on an error", c.method, c.target)
+			}
+		}
+	})
+}
@@ -0,0 +1,339 @@
+package gato
+
+import (
+	"errors"
+	"image"
+	"math"
+)
+
+var ErrInvalidCLAHE = errors.New("invalid clahe: tile size must not be negative, and clip limit must be 0 or at least 1")
+
+// Histogram counts the pixels of an image by value.
+// Colors are counted straight, so that transparency does not darken them, and fully transparent pixels,
+// which have no color, are only counted in Alpha.
+type Histogram struct {
+	Red   [256]int
+	Green [256]int
+	Blue  [256]int
+	Alpha [256]int
+	// Luma counts the luminances of the pixels, weighed following the luma the histogram is computed with.
+	Luma [256]int
+}
+
+// NewHistogram returns the histogram of src, whose luminances are weighed following luma.
+// Its rows are run with the workers of DefaultPool().
+func NewHistogram(src *image.RGBA, luma Luma) (*Histogram, error) {
+	wt, err := luma.weights()
+	if err != nil {
+		return nil, err
+	}
+	return histogram(&scratch{}, src, wt)
+}
+
+// Histogram returns the histogram of d.Image, as NewHistogram does.
+func (d *Data) Histogram(luma Luma) (*Histogram, error) {
+	return NewHistogram(d.Image, luma)
+}
+
+// histogram returns the histogram of src, whose luminances are weighed with wt.
+// Rows are counted into partial histograms, one per worker running at the same time, which are added up once at the end.
+func histogram(s *scratch, src *image.RGBA, wt [3]float64) (*Histogram, error) {
+	h := src.Bounds().Dy()
+	parts := make(chan *Histogram, max(min(s.workers(), h), 1))
+	for range cap(parts) {
+		parts <- &Histogram{}
+	}
+	w := src.Bounds().Dx()
+	err := s.parallel(h, func(start, end int) {
+		part := <-parts
+		defer func() { parts <- part }()
+		for y := start; y < end; y++ {
+			sp := src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y):]
+			for i := 0; i < 4*w; i += 4 {
+				part.Alpha[sp[i+3]]++
+				if sp[i+3] == 0 {
+					continue
+				}
+				c := straight(sp[i : i+4])
+				part.Red[c[0]]++
+				part.Green[c[1]]++
+				part.Blue[c[2]]++
+				part.Luma[lumaOf(c, wt)]++
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hist := <-parts
+	for range cap(parts) - 1 {
+		part := <-parts
+		for v := range 256 {
+			hist.Red[v] += part.Red[v]
+			hist.Green[v] += part.Green[v]
+			hist.Blue[v] += part.Blue[v]
+			hist.Alpha[v] += part.Alpha[v]
+			hist.Luma[v] += part.Luma[v]
+		}
+	}
+	return hist, nil
+}
+
+// straight returns the straight color of the premultiplied pixel p, which must not be transparent.
+func straight(p []uint8) [3]uint8 {
+	a := int(p[3])
+	if a == 255 {
+		return [3]uint8{p[0], p[1], p[2]}
+	}
+	var c [3]uint8
+	for k := range 3 {
+		c[k] = uint8(min(int(p[k])*255/a, 255))
+	}
+	return c
+}
+
+// lumaOf returns the luminance of the color c weighed with wt.
+func lumaOf(c [3]uint8, wt [3]float64) uint8 {
+	return clamp(wt[0]*float64(c[0]) + wt[1]*float64(c[1]) + wt[2]*float64(c[2]))
+}
+
+// equalizeLUT returns the tone curve that maps the values counted by hist to their rank, spread from 0 to 255,
+// so that the darkest value becomes black and the brightest one white. A histogram of a single value maps to itself.
+func equalizeLUT(hist *[256]int) *[256]uint8 {
+	var cdf [256]int
+	sum, first := 0, -1
+	for v, n := range hist {
+		sum += n
+		cdf[v] = sum
+		if first < 0 && n > 0 {
+			first = cdf[v]
+		}
+	}
+	if first < 0 || first == sum {
+		return toneLUT(func(v float64) float64 { return v })
+	}
+	return toneLUT(func(v float64) float64 {
+		return float64(max(cdf[int(v)]-first, 0)) * 255 / float64(sum-first)
+	})
+}
+
+// Equalize is an operation that spreads the luminances of an image evenly over the whole range following its histogram,
+// e.g. to make a dim scan readable. The same tone curve applies to every channel, so that hues are kept about as they are.
+type Equalize struct {
+	// Luma is the weights of the luminances. If it is not set, it defaults to LumaRec601.
+	Luma Luma `json:"luma,omitempty"`
+}
+
+func (e Equalize) kind() string {
+	return "equalize"
+}
+
+func (e Equalize) validate() error {
+	if _, err := e.Luma.weights(); err != nil {
+		return fieldError("luma", err)
+	}
+	return nil
+}
+
+func (e Equalize) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	wt, err := e.Luma.weights()
+	if err != nil {
+		return nil, err
+	}
+	hist, err := histogram(s, src, wt)
+	if err != nil {
+		return nil, err
+	}
+	return applyLUT(s, src, equalizeLUT(&hist.Luma))
+}
+
+// Apply returns a new image of the source equalized following the operation.
+func (e Equalize) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+	return e.apply(&scratch{}, src)
+}
+
+func (e Equalize) MarshalJSON() ([]byte, error) {
+	type alias Equalize
+	return marshalOperation(e.kind(), alias(e))
+}
+
+func (e *Equalize) UnmarshalJSON(data []byte) error {
+	type alias Equalize
+	v := struct {
+		Op string `json:"op"`
+		*alias
+	}{alias: (*alias)(e)}
+	return decodeStrict(data, &v)
+}
+
+// CLAHE is an operation that equalizes an image tile by tile with Contrast Limited Adaptive Histogram Equalization,
+// which brings out details in both the dark and the bright areas of unevenly lit images, e.g. scanned documents before OCR.
+// Every tile gets its own tone curve, which is interpolated between the centers of the tiles so that no seams show.
+type CLAHE struct {
+	// TileSize is the width and height of the tiles in pixels. If it is not set, it defaults to 64.
+	TileSize int `json:"tile_size,omitempty"`
+	// ClipLimit caps the bins of the histograms at ClipLimit times their mean, spreading the excess over every bin,
+	// so that the noise of flat areas is not amplified. 1 leaves the image about as it is. If it is not set, it defaults to 2.
+	ClipLimit float64 `json:"clip_limit,omitempty"`
+	// Luma is the weights of the luminances. If it is not set, it defaults to LumaRec601.
+	Luma Luma `json:"luma,omitempty"`
+}
+
+func (c CLAHE) kind() string {
+	return "clahe"
+}
+
+func (c CLAHE) validate() error {
+	if c.TileSize < 0 {
+		return fieldError("tile_size", ErrInvalidCLAHE)
+	}
+	if (c.ClipLimit != 0 && !(c.ClipLimit >= 1)) || math.IsInf(c.ClipLimit, 0) {
+		return fieldError("clip_limit", ErrInvalidCLAHE)
+	}
+	if _, err := c.Luma.weights(); err != nil {
+		return fieldError("luma", err)
+	}
+	return nil
+}
+
+func (c CLAHE) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	wt, err := c.Luma.weights()
+	if err != nil {
+		return nil, err
+	}
+	ts := c.TileSize
+	if ts == 0 {
+		ts = 64
+	}
+	limit := c.ClipLimit
+	if limit == 0 {
+		limit = 2
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	nx, ny := (w+ts-1)/ts, (h+ts-1)/ts
+	luts := make([]*[256]uint8, nx*ny)
+	err = s.parallel(ny, func(start, end int) {
+		for ty := start; ty < end; ty++ {
+			for tx := range nx {
+				var hist [256]int
+				for y := ty * ts; y < min((ty+1)*ts, h); y++ {
+					sp := src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y):]
+					for x := tx * ts; x < min((tx+1)*ts, w); x++ {
+						if p := sp[4*x : 4*x+4]; p[3] > 0 {
+							hist[lumaOf(straight(p), wt)]++
+						}
+					}
+				}
+				luts[ty*nx+tx] = clipLUT(&hist, limit)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// tileAt returns the tiles whose centers surround the pixel at i along an axis of n tiles, and the weight of the second one
+	tileAt := func(i, n int) (int, int, float64) {
+		f := (float64(i)+0.5)/float64(ts) - 0.5
+		t0 := min(max(int(math.Floor(f)), 0), n-1)
+		t1 := min(t0+1, n-1)
+		return t0, t1, min(max(f-float64(t0), 0), 1)
+	}
+
+	dst := s.get(w, h)
+	err = s.parallel(h, func(start, end int) {
+		for y := start; y < end; y++ {
+			ty0, ty1, wy := tileAt(y, ny)
+			sp := src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y):]
+			dp := dst.Pix[dst.PixOffset(0, y):]
+			for x := range w {
+				p := sp[4*x : 4*x+4]
+				a := p[3]
+				if a == 0 {
+					continue
+				}
+				tx0, tx1, wx := tileAt(x, nx)
+				l00, l10 := luts[ty0*nx+tx0], luts[ty0*nx+tx1]
+				l01, l11 := luts[ty1*nx+tx0], luts[ty1*nx+tx1]
+				for k, v := range straight(p) {
+					top := float64(l00[v])*(1-wx) + float64(l10[v])*wx
+					bottom := float64(l01[v])*(1-wx) + float64(l11[v])*wx
+					dp[4*x+k] = clamp((top*(1-wy) + bottom*wy) * float64(a) / 255)
+				}
+				dp[4*x+3] = a
+			}
+		}
+	})
+	if err != nil {
+		s.put(dst)
+		return nil, err
+	}
+	return dst, nil
+}
+
+// Apply returns a new image of the source equalized following the operation.
+func (c CLAHE) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c.apply(&scratch{}, src)
+}
+
+func (c CLAHE) MarshalJSON() ([]byte, error) {
+	type alias CLAHE
+	return marshalOperation(c.kind(), alias(c))
+}
+
+func (c *CLAHE) UnmarshalJSON(data []byte) error {
+	type alias CLAHE
+	v := struct {
+		Op string `json:"op"`
+		*alias
+	}{alias: (*alias)(c)}
+	return decodeStrict(data, &v)
+}
+
+// clipLUT returns the equalization curve of a tile whose histogram hist is clipped at limit times its mean bin,
+// the excess being spread evenly over every bin. hist is modified.
+func clipLUT(hist *[256]int, limit float64) *[256]uint8 {
+	total := 0
+	for _, n := range hist {
+		total += n
+	}
+	if total == 0 {
+		return toneLUT(func(v float64) float64 { return v })
+	}
+
+	clip := max(1, int(limit*float64(total)/256))
+	excess := 0
+	for v, n := range hist {
+		if n > clip {
+			excess += n - clip
+			hist[v] = clip
+		}
+	}
+	for v := range hist {
+		hist[v] += excess / 256
+	}
+	// the remainder goes to bins spread over the whole range
+	if rest := excess % 256; rest > 0 {
+		for i := range rest {
+			hist[i*256/rest]++
+		}
+	}
+
+	var cdf [256]int
+	sum := 0
+	for v, n := range hist {
+		sum += n
+		cdf[v] = sum
+	}
+	return toneLUT(func(v float64) float64 {
+		return float64(cdf[int(v)]) * 255 / float64(total)
+	})
+}
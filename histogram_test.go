@@ -0,0 +1,162 @@
+package gato
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// newGrayImage returns an opaque image of size w x h whose pixel at (x, y) is the gray value(x, y).
+func newGrayImage(w, h int, value func(x, y int) uint8) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			v := value(x, y)
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+// grayRange returns the least and the greatest red values of img within r.
+func grayRange(img *image.RGBA, r image.Rectangle) (lo, hi int) {
+	lo, hi = 255, 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			v := int(img.RGBAAt(x, y).R)
+			lo, hi = min(lo, v), max(hi, v)
+		}
+	}
+	return lo, hi
+}
+
+func TestHistogram(t *testing.T) {
+	t.Run("count straight colors and alpha", func(t *testing.T) {
+		src := image.NewRGBA(image.Rect(0, 0, 3, 1))
+		src.SetRGBA(0, 0, color.RGBA{10, 20, 30, 255})
+		src.SetRGBA(1, 0, color.RGBA{50, 50, 50, 128})
+		data := &Data{Image: src}
+
+		hist, err := data.Histogram(LumaRec601)
+		assertError(t, err, nil)
+		assertInt(t, hist.Red[10], 1)
+		assertInt(t, hist.Green[20], 1)
+		assertInt(t, hist.Blue[30], 1)
+		// the translucent gray is counted as the opaque gray it stands for
+		assertInt(t, hist.Red[99], 1)
+		assertInt(t, hist.Luma[99], 1)
+		assertInt(t, hist.Luma[18], 1)
+		// the transparent pixel has no color
+		assertInt(t, hist.Red[0], 0)
+		assertInt(t, hist.Alpha[0], 1)
+		assertInt(t, hist.Alpha[128], 1)
+		assertInt(t, hist.Alpha[255], 1)
+	})
+
+	t.Run("count every pixel once", func(t *testing.T) {
+		hist, err := NewHistogram(newGradientImage(97, 61), LumaRec709)
+		assertError(t, err, nil)
+		sum := 0
+		for _, n := range hist.Luma {
+			sum += n
+		}
+		assertInt(t, sum, 97*61)
+		assertInt(t, hist.Blue[100], 97*61)
+	})
+
+	t.Run("count every pixel once with more workers than rows", func(t *testing.T) {
+		pool := NewPool(4)
+		defer pool.Close()
+		for _, h := range []int{1, 3, 61} {
+			hist, err := histogram(&scratch{pool: pool}, newGradientImage(97, h), [3]float64{1, 0, 0})
+			assertError(t, err, nil)
+			sum := 0
+			for _, n := range hist.Alpha {
+				sum += n
+			}
+			assertInt(t, sum, 97*h)
+			assertInt(t, hist.Blue[100], 97*h)
+		}
+	})
+
+	t.Run("return error when the luma is invalid", func(t *testing.T) {
+		_, err := NewHistogram(newGradientImage(2, 2), "hdr")
+		assertError(t, err, ErrInvalidLuma)
+	})
+}
+
+func TestEqualize(t *testing.T) {
+	t.Run("stretch a dim image over the whole range", func(t *testing.T) {
+		src := newGrayImage(32, 4, func(x, y int) uint8 { return uint8(100 + x) })
+		dst, err := Equalize{}.Apply(src)
+		assertError(t, err, nil)
+		lo, hi := grayRange(dst, dst.Bounds())
+		assertInt(t, lo, 0)
+		assertInt(t, hi, 255)
+		// equal counts are spread evenly
+		assertInt(t, int(dst.RGBAAt(16, 0).R), 132)
+	})
+
+	t.Run("leave a flat image as it is", func(t *testing.T) {
+		src := newGrayImage(4, 4, func(x, y int) uint8 { return 80 })
+		dst, err := Equalize{}.Apply(src)
+		assertError(t, err, nil)
+		assertInt(t, int(dst.RGBAAt(1, 1).R), 80)
+	})
+
+	t.Run("return error when the luma is invalid", func(t *testing.T) {
+		_, err := Equalize{Luma: "hdr"}.Apply(newGradientImage(2, 2))
+		assertValidationError(t, err, -1, "luma", ErrInvalidLuma)
+	})
+}
+
+func TestCLAHE(t *testing.T) {
+	t.Run("enhance the contrast of dark and bright areas alike", func(t *testing.T) {
+		src := newGrayImage(128, 64, func(x, y int) uint8 {
+			if x < 64 {
+				return uint8(40 + (x+y)%8)
+			}
+			return uint8(200 + (x+y)%8)
+		})
+		dst, err := CLAHE{ClipLimit: 4}.Apply(src)
+		assertError(t, err, nil)
+		for _, r := range []image.Rectangle{image.Rect(0, 0, 32, 64), image.Rect(96, 0, 128, 64)} {
+			lo, hi := grayRange(dst, r)
+			if hi-lo < 32 {
+				t.Errorf("got range %d to %d within %v, want it stretched from 7", lo, hi, r)
+			}
+		}
+	})
+
+	t.Run("leave tones about as they are with a clip limit of 1", func(t *testing.T) {
+		src := newGrayImage(64, 64, func(x, y int) uint8 { return uint8((y*64 + x) / 16) })
+		dst, err := CLAHE{ClipLimit: 1}.Apply(src)
+		assertError(t, err, nil)
+		for i := 0; i < len(src.Pix); i += 4 {
+			if d := int(dst.Pix[i]) - int(src.Pix[i]); d < -1 || d > 1 {
+				t.Fatalf("got %d for %d", dst.Pix[i], src.Pix[i])
+			}
+		}
+	})
+
+	t.Run("keep transparent pixels transparent", func(t *testing.T) {
+		src := newGradientImage(10, 10)
+		src.SetRGBA(3, 3, color.RGBA{})
+		dst, err := CLAHE{TileSize: 4}.Apply(src)
+		assertError(t, err, nil)
+		if got := dst.RGBAAt(3, 3); got != (color.RGBA{}) {
+			t.Errorf("got %v, want transparent", got)
+		}
+		assertInt(t, int(dst.RGBAAt(4, 4).A), 255)
+	})
+
+	t.Run("return error when the operation is invalid", func(t *testing.T) {
+		src := newGradientImage(2, 2)
+		_, err := CLAHE{TileSize: -1}.Apply(src)
+		assertValidationError(t, err, -1, "tile_size", ErrInvalidCLAHE)
+		_, err = CLAHE{ClipLimit: 0.5}.Apply(src)
+		assertValidationError(t, err, -1, "clip_limit", ErrInvalidCLAHE)
+		_, err = CLAHE{Luma: "hdr"}.Apply(src)
+		assertValidationError(t, err, -1, "luma", ErrInvalidLuma)
+	})
+}
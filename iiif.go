@@ -0,0 +1,654 @@
+package gato
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// IIIFContext is the JSON-LD context of the IIIF Image API 3.0.
+const IIIFContext = "http://iiif.io/api/image/3/context.json"
+
+var (
+	ErrInvalidRegion      = errors.New("invalid region: only full, square, x,y,w,h and pct:x,y,w,h within the image are available")
+	ErrInvalidSize        = errors.New("invalid size: only max, w,, ,h, pct:n, w,h and !w,h, optionally led by ^, within the limits are available")
+	ErrInvalidRotation    = errors.New("invalid rotation: rotation must be between 0 and 360, optionally led by !")
+	ErrUnsupportedQuality = errors.New("unsupported quality: only default and color are available")
+	ErrUnsupportedFormat  = errors.New("unsupported format: only jpg and png are available")
+)
+
+// IIIFRegion is the region parameter of an IIIF image request.
+type IIIFRegion struct {
+	// Full and Square are the full and square keywords.
+	Full   bool
+	Square bool
+	// Percent reports whether X, Y, Width and Height are percentages of the image dimensions rather than pixels.
+	Percent bool
+	X       float64
+	Y       float64
+	Width   float64
+	Height  float64
+}
+
+// IIIFSize is the size parameter of an IIIF image request.
+type IIIFSize struct {
+	// Upscale reports whether the size was led by ^, which allows it to be larger than the region.
+	Upscale bool
+	// Max is the max keyword.
+	Max bool
+	// Percent is the n of pct:n, or 0.
+	Percent float64
+	// Width and Height are the requested dimensions, 0 when they are not set, e.g. for w, or ,h.
+	Width  int
+	Height int
+	// Confined reports whether the size was led by !, which fits the image within Width x Height keeping its aspect ratio.
+	Confined bool
+}
+
+// IIIFRequest is an image request of the IIIF Image API 3.0, {identifier}/{region}/{size}/{rotation}/{quality}.{format}.
+type IIIFRequest struct {
+	Identifier string
+	Region     IIIFRegion
+	Size       IIIFSize
+	// Rotation is the clockwise rotation in degrees, applied after the mirroring.
+	Rotation float64
+	// Mirror reports whether the image is mirrored horizontally, requested with a rotation led by !.
+	Mirror bool
+	// Quality is default or color. Both keep the colors of the image.
+	Quality string
+	// Format is the extension of the requested format, jpg or png.
+	Format string
+}
+
+// IIIFLimits are the maximum dimensions of the images that an IIIF service returns. Zero values are unlimited.
+type IIIFLimits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxArea   int
+}
+
+// DefaultIIIFLimits are the limits of an IIIFHandler whose Limits are not set, so that no request, upscaled or not,
+// makes it allocate more than DefaultMaxArea pixels.
+var DefaultIIIFLimits = IIIFLimits{MaxWidth: 8192, MaxHeight: 8192, MaxArea: DefaultMaxArea}
+
+// ParseIIIF parses the IIIF image request p, e.g. dog/full/max/0/default.jpg.
+// The identifier is everything before the last four segments, unescaped, so that it may contain slashes either escaped or not.
+// Errors are returned as a *ValidationError whose Field is the invalid parameter.
+func ParseIIIF(p string) (IIIFRequest, error) {
+	segments := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	if len(segments) < 5 {
+		return IIIFRequest{}, fieldError("identifier", fs.ErrNotExist)
+	}
+	n := len(segments)
+	id, err := url.PathUnescape(strings.Join(segments[:n-4], "/"))
+	if err != nil || id == "" {
+		return IIIFRequest{}, fieldError("identifier", fs.ErrNotExist)
+	}
+
+	// the parameters may be escaped as well, e.g. ^ as %5E
+	for i := n - 4; i < n; i++ {
+		if segments[i], err = url.PathUnescape(segments[i]); err != nil {
+			return IIIFRequest{}, fieldError("identifier", fs.ErrNotExist)
+		}
+	}
+
+	r := IIIFRequest{Identifier: id}
+	if r.Region, err = parseIIIFRegion(segments[n-4]); err != nil {
+		return IIIFRequest{}, fieldError("region", err)
+	}
+	if r.Size, err = parseIIIFSize(segments[n-3]); err != nil {
+		return IIIFRequest{}, fieldError("size", err)
+	}
+	rotation := segments[n-2]
+	r.Mirror = strings.HasPrefix(rotation, "!")
+	if r.Rotation, err = strconv.ParseFloat(strings.TrimPrefix(rotation, "!"), 64); err != nil || !(r.Rotation >= 0 && r.Rotation <= 360) {
+		return IIIFRequest{}, fieldError("rotation", ErrInvalidRotation)
+	}
+
+	quality, format, ok := strings.Cut(segments[n-1], ".")
+	switch {
+	case !ok:
+		return IIIFRequest{}, fieldError("format", ErrUnsupportedFormat)
+	case quality != "default" && quality != "color":
+		return IIIFRequest{}, fieldError("quality", ErrUnsupportedQuality)
+	case format != "jpg" && format != "png":
+		return IIIFRequest{}, fieldError("format", ErrUnsupportedFormat)
+	}
+	r.Quality, r.Format = quality, format
+
+	return r, nil
+}
+
+func parseIIIFRegion(s string) (IIIFRegion, error) {
+	switch s {
+	case "full":
+		return IIIFRegion{Full: true}, nil
+	case "square":
+		return IIIFRegion{Square: true}, nil
+	}
+
+	var g IIIFRegion
+	s, g.Percent = strings.CutPrefix(s, "pct:")
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return IIIFRegion{}, ErrInvalidRegion
+	}
+	var v [4]float64
+	for i, part := range parts {
+		var err error
+		if g.Percent {
+			v[i], err = strconv.ParseFloat(part, 64)
+		} else {
+			var n int
+			n, err = strconv.Atoi(part)
+			v[i] = float64(n)
+		}
+		if err != nil || v[i] < 0 || math.IsInf(v[i], 0) {
+			return IIIFRegion{}, ErrInvalidRegion
+		}
+	}
+	if v[2] == 0 || v[3] == 0 {
+		return IIIFRegion{}, ErrInvalidRegion
+	}
+	g.X, g.Y, g.Width, g.Height = v[0], v[1], v[2], v[3]
+	return g, nil
+}
+
+func parseIIIFSize(s string) (IIIFSize, error) {
+	var z IIIFSize
+	s, z.Upscale = strings.CutPrefix(s, "^")
+	if s == "max" {
+		z.Max = true
+		return z, nil
+	}
+	if pct, ok := strings.CutPrefix(s, "pct:"); ok {
+		f, err := strconv.ParseFloat(pct, 64)
+		if err != nil || !(f > 0) || math.IsInf(f, 0) || (f > 100 && !z.Upscale) {
+			return IIIFSize{}, ErrInvalidSize
+		}
+		z.Percent = f
+		return z, nil
+	}
+
+	s, z.Confined = strings.CutPrefix(s, "!")
+	w, h, ok := strings.Cut(s, ",")
+	if !ok || (w == "" && h == "") || (z.Confined && (w == "" || h == "")) {
+		return IIIFSize{}, ErrInvalidSize
+	}
+	for _, d := range []struct {
+		s string
+		v *int
+	}{{w, &z.Width}, {h, &z.Height}} {
+		if d.s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(d.s)
+		if err != nil || n <= 0 {
+			return IIIFSize{}, ErrInvalidSize
+		}
+		*d.v = n
+	}
+	return z, nil
+}
+
+// resolve returns the region of the image of size w x h in pixels, and the size of the returned image.
+func (r IIIFRequest) resolve(w, h int, l IIIFLimits) (region image.Rectangle, size image.Point, err error) {
+	g := r.Region
+	switch {
+	case g.Full:
+		region = image.Rect(0, 0, w, h)
+	case g.Square:
+		side := min(w, h)
+		region = image.Rect(0, 0, side, side).Add(image.Pt((w-side)/2, (h-side)/2))
+	case g.Percent:
+		region = image.Rect(
+			int(math.Round(g.X*float64(w)/100)),
+			int(math.Round(g.Y*float64(h)/100)),
+			int(math.Round((g.X+g.Width)*float64(w)/100)),
+			int(math.Round((g.Y+g.Height)*float64(h)/100)),
+		)
+	default:
+		region = image.Rect(int(g.X), int(g.Y), int(g.X+g.Width), int(g.Y+g.Height))
+	}
+	// regions overflowing the image are cropped to it
+	region = region.Intersect(image.Rect(0, 0, w, h))
+	if region.Empty() {
+		return image.Rectangle{}, image.Point{}, fieldError("region", ErrInvalidRegion)
+	}
+
+	rw, rh := float64(region.Dx()), float64(region.Dy())
+	z := r.Size
+	switch {
+	case z.Max:
+		// the largest size within the limits, which may be larger than the region with ^max,
+		// and whose canvas extended by the rotation is within the limits too, with a pixel to spare for its rounding
+		bw, bh := rotatedSize(region.Dx(), region.Dy(), r.Rotation)
+		bx, by := float64(bw), float64(bh)
+		if math.Mod(r.Rotation, 90) != 0 {
+			bx, by = bx+1, by+1
+		}
+		scale := 1.0
+		if !z.Upscale {
+			scale = math.Min(scale, l.scale(bx, by))
+		} else if l != (IIIFLimits{}) {
+			scale = l.scale(bx, by)
+		}
+		size = image.Pt(max(1, int(math.Floor(rw*scale))), max(1, int(math.Floor(rh*scale))))
+	case z.Percent != 0:
+		size = image.Pt(int(math.Round(rw*z.Percent/100)), int(math.Round(rh*z.Percent/100)))
+	case z.Confined:
+		scale := math.Min(float64(z.Width)/rw, float64(z.Height)/rh)
+		if !z.Upscale {
+			// as large as possible, but never larger than the region
+			scale = math.Min(scale, 1)
+		}
+		size = image.Pt(int(math.Round(rw*scale)), int(math.Round(rh*scale)))
+	case z.Height == 0:
+		size = image.Pt(z.Width, int(math.Round(rh*float64(z.Width)/rw)))
+	case z.Width == 0:
+		size = image.Pt(int(math.Round(rw*float64(z.Height)/rh)), z.Height)
+	default:
+		size = image.Pt(z.Width, z.Height)
+	}
+
+	if size.X < 1 || size.Y < 1 || (!z.Upscale && (size.X > region.Dx() || size.Y > region.Dy())) || !l.allow(size) {
+		return image.Rectangle{}, image.Point{}, fieldError("size", ErrInvalidSize)
+	}
+	// the rotation extends the canvas of the returned image, which is allocated as well
+	if bw, bh := rotatedSize(size.X, size.Y, r.Rotation); !l.allow(image.Pt(bw, bh)) {
+		return image.Rectangle{}, image.Point{}, fieldError("size", ErrInvalidSize)
+	}
+	return region, size, nil
+}
+
+// scale returns the largest scale of an image of size w x h that is within l.
+func (l IIIFLimits) scale(w, h float64) float64 {
+	scale := math.Inf(1)
+	if l.MaxWidth > 0 {
+		scale = math.Min(scale, float64(l.MaxWidth)/w)
+	}
+	if l.MaxHeight > 0 {
+		scale = math.Min(scale, float64(l.MaxHeight)/h)
+	}
+	if l.MaxArea > 0 {
+		scale = math.Min(scale, math.Sqrt(float64(l.MaxArea)/(w*h)))
+	}
+	return scale
+}
+
+// allow reports whether an image of the size is within l.
+func (l IIIFLimits) allow(size image.Point) bool {
+	return (l.MaxWidth <= 0 || size.X <= l.MaxWidth) &&
+		(l.MaxHeight <= 0 || size.Y <= l.MaxHeight) &&
+		(l.MaxArea <= 0 || size.X*size.Y <= l.MaxArea)
+}
+
+// Instruction returns the Instruction that r describes for an image of size w x h, a pipeline of Crop, Resize, mirroring Warp and Rotate.
+// Requests out of the image or of l return a *ValidationError.
+func (r IIIFRequest) Instruction(w, h int, l IIIFLimits, m Interpolation) (Instruction, error) {
+	region, size, err := r.resolve(w, h, l)
+	if err != nil {
+		return Instruction{}, err
+	}
+
+	var steps []Operation
+	if region != image.Rect(0, 0, w, h) {
+		steps = append(steps, Crop{X: region.Min.X, Y: region.Min.Y, Width: region.Dx(), Height: region.Dy()})
+	}
+	if size != region.Size() {
+		steps = append(steps, Resize{Width: size.X, Height: size.Y, Interpolation: m})
+	}
+	if r.Mirror {
+		// x -> width - x, with pixel centers landing on pixel centers
+		steps = append(steps, Warp{Matrix: Affine{-1, 0, float64(size.X), 0, 1, 0}.Homography(), Interpolation: NearestNeighbor})
+	}
+	if angle := math.Mod(r.Rotation, 360); angle != 0 {
+		steps = append(steps, Rotate{Angle: angle, Interpolation: m})
+	}
+	if len(steps) == 0 {
+		// the image as is, copied so that the pipeline is never empty
+		steps = append(steps, Crop{Width: w, Height: h})
+	}
+	return Instruction{Pipeline: steps}, nil
+}
+
+// Canonical returns the canonical form of r for an image of size w x h, e.g. dog/0,0,100,100/50,50/0/default.jpg.
+// Equivalent requests share the same canonical form.
+func (r IIIFRequest) Canonical(w, h int, l IIIFLimits) (string, error) {
+	region, size, err := r.resolve(w, h, l)
+	if err != nil {
+		return "", err
+	}
+	g := "full"
+	if region != image.Rect(0, 0, w, h) {
+		g = fmt.Sprintf("%d,%d,%d,%d", region.Min.X, region.Min.Y, region.Dx(), region.Dy())
+	}
+	z := fmt.Sprintf("%d,%d", size.X, size.Y)
+	if _, maxSize, _ := (IIIFRequest{Region: r.Region, Size: IIIFSize{Max: true}, Rotation: r.Rotation}).resolve(w, h, l); maxSize == size {
+		z = "max"
+	} else if size.X > region.Dx() || size.Y > region.Dy() {
+		z = "^" + z
+	}
+	rotation := strconv.FormatFloat(math.Mod(r.Rotation, 360), 'f', -1, 64)
+	if r.Mirror {
+		rotation = "!" + rotation
+	}
+	return url.PathEscape(r.Identifier) + "/" + g + "/" + z + "/" + rotation + "/default." + r.Format, nil
+}
+
+// Output returns the encoding options of r.
+func (r IIIFRequest) Output() Output {
+	if r.Format == "jpg" {
+		return Output{Format: FormatJPEG}
+	}
+	return Output{Format: r.Format}
+}
+
+// iiifInfo is the image information document of the IIIF Image API 3.0, info.json.
+type iiifInfo struct {
+	Context        string      `json:"@context"`
+	ID             string      `json:"id"`
+	Type           string      `json:"type"`
+	Protocol       string      `json:"protocol"`
+	Profile        string      `json:"profile"`
+	Width          int         `json:"width"`
+	Height         int         `json:"height"`
+	MaxWidth       int         `json:"maxWidth,omitempty"`
+	MaxHeight      int         `json:"maxHeight,omitempty"`
+	MaxArea        int         `json:"maxArea,omitempty"`
+	Tiles          []iiifTiles `json:"tiles,omitempty"`
+	ExtraFormats   []string    `json:"extraFormats,omitempty"`
+	ExtraQualities []string    `json:"extraQualities,omitempty"`
+	ExtraFeatures  []string    `json:"extraFeatures,omitempty"`
+}
+
+type iiifTiles struct {
+	Width        int   `json:"width"`
+	Height       int   `json:"height"`
+	ScaleFactors []int `json:"scaleFactors"`
+}
+
+func newIIIFInfo(id, profile string, w, h int) iiifInfo {
+	return iiifInfo{
+		Context:  IIIFContext,
+		ID:       id,
+		Type:     "ImageService3",
+		Protocol: "http://iiif.io/api/image",
+		Profile:  profile,
+		Width:    w,
+		Height:   h,
+	}
+}
+
+// scaleFactors returns the powers of two that reduce an image of size w x h down to a single tile of size ts.
+func scaleFactors(w, h, ts int) []int {
+	var sfs []int
+	for sf := 1; ; sf *= 2 {
+		sfs = append(sfs, sf)
+		if ceilDiv(w, sf) <= ts && ceilDiv(h, sf) <= ts {
+			return sfs
+		}
+	}
+}
+
+// IIIFHandler is an http.Handler serving the images of Source following the IIIF Image API 3.0 at compliance level 2,
+// e.g. /photos%2Fdog.jpg/full/max/0/default.jpg and /photos%2Fdog.jpg/info.json.
+// Identifiers are the paths of images in Source, and their slashes may be escaped or not.
+type IIIFHandler struct {
+	// Source is the file system that the original images are read from, e.g. os.DirFS("images").
+	Source fs.FS
+	// BaseURL is the URL that the handler is served under, e.g. https://example.com/iiif, which the ids of info.json start with.
+	// If it is not set, it is the scheme and host of the request, as if the handler was served at the root.
+	BaseURL string
+	// Limits are the maximum dimensions of the returned images, advertised in info.json.
+	// If they are not set, they default to DefaultIIIFLimits.
+	Limits IIIFLimits
+	// MaxSourceArea is the maximum number of pixels of the source images, which is read from their header before they are decoded.
+	// Larger sources are rejected with 422 Unprocessable Entity. If it is not set, it defaults to DefaultMaxSourceArea.
+	MaxSourceArea int
+	// TileSize is the size of the tiles advertised in info.json. If it is not set, it defaults to 512.
+	TileSize int
+	// Interpolation is the interpolation method of resizing and rotation. If it is not set, it defaults to Bilinear.
+	Interpolation Interpolation
+	// CacheControl is the value of the Cache-Control header of successful responses. If it is not set, the header is omitted.
+	CacheControl string
+	// Cache stores the encoded images, so that repeated requests are not processed again.
+	Cache *Cache
+	// Pool runs the processing of all requests. If it is not set, DefaultPool() is used.
+	Pool *Pool
+}
+
+// NewIIIFHandler creates a new IIIFHandler instance serving the images of src, cacheable for a day.
+func NewIIIFHandler(src fs.FS) *IIIFHandler {
+	return &IIIFHandler{
+		Source:       src,
+		CacheControl: "public, max-age=86400",
+	}
+}
+
+func (h *IIIFHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	// IIIF resources are meant to be shared between viewers of any origin
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	p := strings.TrimPrefix(r.URL.EscapedPath(), "/")
+	if id, ok := strings.CutSuffix(p, "/info.json"); ok {
+		h.serveInfo(w, r, id)
+		return
+	}
+
+	req, err := ParseIIIF(p)
+	if err != nil {
+		// the base URI of an image redirects to its info.json
+		if id, uerr := url.PathUnescape(p); uerr == nil && fs.ValidPath(id) {
+			if info, serr := fs.Stat(h.Source, id); serr == nil && !info.IsDir() {
+				http.Redirect(w, r, h.baseURL(r)+"/"+url.PathEscape(id)+"/info.json", http.StatusSeeOther)
+				return
+			}
+		}
+		replyError(w, r, err)
+		return
+	}
+	if !fs.ValidPath(req.Identifier) {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := h.Source.Open(req.Identifier)
+	if err != nil {
+		replyError(w, r, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		replyError(w, r, err)
+		return
+	}
+	if info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		replyError(w, r, err)
+		return
+	}
+	if float64(cfg.Width)*float64(cfg.Height) > float64(h.maxSourceArea()) {
+		replyError(w, r, ErrSourceTooLarge)
+		return
+	}
+	canonical, err := req.Canonical(cfg.Width, cfg.Height, h.limits())
+	if err != nil {
+		replyError(w, r, err)
+		return
+	}
+	// the configuration was read from f, so it is opened again for decoding
+	f.Close()
+
+	header := w.Header()
+	header.Set("ETag", etag(req.Identifier, info, Params{}, canonical))
+	header.Set("Link", `<`+h.baseURL(r)+"/"+canonical+`>;rel="canonical"`)
+	if !info.ModTime().IsZero() {
+		header.Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	}
+	if h.CacheControl != "" {
+		header.Set("Cache-Control", h.CacheControl)
+	}
+	if notModified(r, header.Get("ETag"), info.ModTime()) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	i, err := req.Instruction(cfg.Width, cfg.Height, h.limits(), h.Interpolation)
+	if err != nil {
+		replyError(w, r, err)
+		return
+	}
+	render := func(ctx context.Context) ([]byte, error) {
+		f, err := h.Source.Open(req.Identifier)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return h.render(ctx, f, req.Identifier, i, req.Output())
+	}
+	var b []byte
+	if h.Cache != nil {
+		ctx := context.WithoutCancel(r.Context())
+		b, err = h.Cache.Do(strings.Trim(header.Get("ETag"), `"`), func() ([]byte, error) {
+			return render(ctx)
+		})
+	} else {
+		b, err = render(r.Context())
+	}
+	if err != nil {
+		replyError(w, r, err)
+		return
+	}
+
+	header.Set("Content-Type", req.Output().ContentType())
+	header.Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(b)
+	}
+}
+
+// render decodes the image file f named name, processes it following i and encodes it with o, unless ctx is done first.
+func (h *IIIFHandler) render(ctx context.Context, f io.Reader, name string, i Instruction, o Output) ([]byte, error) {
+	// the file may have changed since its configuration was read
+	d, err := decodeSource(ctx, path.Base(name), f, h.maxSourceArea())
+	if err != nil {
+		return nil, err
+	}
+	defer d.Release()
+	prc, err := NewProcessor(i)
+	if err != nil {
+		return nil, err
+	}
+	prc.Pool = h.Pool
+	img, err := prc.ProcessContext(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	defer putBuffer(img)
+
+	b := new(bytes.Buffer)
+	if err := o.Encode(b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// serveInfo replies with the info.json of the image whose escaped identifier is id.
+func (h *IIIFHandler) serveInfo(w http.ResponseWriter, r *http.Request, id string) {
+	name, err := url.PathUnescape(id)
+	if err != nil || !fs.ValidPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := h.Source.Open(name)
+	if err != nil {
+		replyError(w, r, err)
+		return
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		replyError(w, r, err)
+		return
+	}
+
+	ts := h.TileSize
+	if ts == 0 {
+		ts = 512
+	}
+	info := newIIIFInfo(h.baseURL(r)+"/"+url.PathEscape(name), "level2", cfg.Width, cfg.Height)
+	l := h.limits()
+	info.MaxWidth, info.MaxHeight, info.MaxArea = l.MaxWidth, l.MaxHeight, l.MaxArea
+	info.Tiles = []iiifTiles{{ts, ts, scaleFactors(cfg.Width, cfg.Height, ts)}}
+	info.ExtraQualities = []string{"color"}
+	info.ExtraFeatures = []string{"mirroring", "rotationArbitrary", "sizeUpscaling"}
+	b, err := json.Marshal(info)
+	if err != nil {
+		replyError(w, r, err)
+		return
+	}
+
+	contentType := "application/json"
+	if strings.Contains(r.Header.Get("Accept"), "application/ld+json") {
+		contentType = `application/ld+json;profile="` + IIIFContext + `"`
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	if h.CacheControl != "" {
+		w.Header().Set("Cache-Control", h.CacheControl)
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(b)
+	}
+}
+
+func (h *IIIFHandler) maxSourceArea() int {
+	if h.MaxSourceArea > 0 {
+		return h.MaxSourceArea
+	}
+	return DefaultMaxSourceArea
+}
+
+// limits returns h.Limits, which default to DefaultIIIFLimits.
+func (h *IIIFHandler) limits() IIIFLimits {
+	if h.Limits == (IIIFLimits{}) {
+		return DefaultIIIFLimits
+	}
+	return h.Limits
+}
+
+// baseURL returns the URL that the handler is served under for r.
+func (h *IIIFHandler) baseURL(r *http.Request) string {
+	if h.BaseURL != "" {
+		return strings.TrimSuffix(h.BaseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
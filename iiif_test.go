@@ -0,0 +1,275 @@
+package gato
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"io/fs"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseIIIF(t *testing.T) {
+	t.Run("parse every parameter", func(t *testing.T) {
+		r, err := ParseIIIF("/photos%2Fdog.png/pct:10,20,30,40/^!100,50/!90/color.png")
+		assertError(t, err, nil)
+		assertString(t, r.Identifier, "photos/dog.png")
+		if r.Region != (IIIFRegion{Percent: true, X: 10, Y: 20, Width: 30, Height: 40}) {
+			t.Errorf("got region %+v", r.Region)
+		}
+		if r.Size != (IIIFSize{Upscale: true, Confined: true, Width: 100, Height: 50}) {
+			t.Errorf("got size %+v", r.Size)
+		}
+		if r.Rotation != 90 || !r.Mirror {
+			t.Errorf("got rotation %v and mirror %v", r.Rotation, r.Mirror)
+		}
+		assertString(t, r.Quality, "color")
+		assertString(t, r.Format, "png")
+	})
+
+	t.Run("keep unescaped slashes in the identifier", func(t *testing.T) {
+		r, err := ParseIIIF("photos/dog.png/full/max/0/default.jpg")
+		assertError(t, err, nil)
+		assertString(t, r.Identifier, "photos/dog.png")
+	})
+
+	t.Run("return an error naming the invalid parameter", func(t *testing.T) {
+		for _, tc := range []struct {
+			path  string
+			field string
+			err   error
+		}{
+			{"dog/full/max/0", "identifier", fs.ErrNotExist},
+			{"dog/1,2,3/max/0/default.jpg", "region", ErrInvalidRegion},
+			{"dog/0,0,0,10/max/0/default.jpg", "region", ErrInvalidRegion},
+			{"dog/full/,/0/default.jpg", "size", ErrInvalidSize},
+			{"dog/full/!10,/0/default.jpg", "size", ErrInvalidSize},
+			{"dog/full/pct:150/0/default.jpg", "size", ErrInvalidSize},
+			{"dog/full/max/361/default.jpg", "rotation", ErrInvalidRotation},
+			{"dog/full/max/0/gray.jpg", "quality", ErrUnsupportedQuality},
+			{"dog/full/max/0/default.webp", "format", ErrUnsupportedFormat},
+		} {
+			_, err := ParseIIIF(tc.path)
+			assertError(t, err, tc.err)
+			if ve, ok := err.(*ValidationError); !ok || ve.Field != tc.field {
+				t.Errorf("%s: got %v, want an error on %s", tc.path, err, tc.field)
+			}
+		}
+	})
+}
+
+func TestIIIFRequestInstruction(t *testing.T) {
+	// the size of the returned image for an image of 400x200
+	for _, tc := range []struct {
+		path   string
+		limits IIIFLimits
+		width  int
+		height int
+		err    error
+	}{
+		{"dog/full/max/0/default.jpg", IIIFLimits{}, 400, 200, nil},
+		{"dog/full/max/0/default.jpg", IIIFLimits{MaxWidth: 100}, 100, 50, nil},
+		{"dog/full/^max/0/default.jpg", IIIFLimits{MaxArea: 320000}, 800, 400, nil},
+		{"dog/square/50,/0/default.jpg", IIIFLimits{}, 50, 50, nil},
+		{"dog/100,50,1000,1000/max/0/default.jpg", IIIFLimits{}, 300, 150, nil},
+		{"dog/pct:50,50,50,50/max/0/default.jpg", IIIFLimits{}, 200, 100, nil},
+		{"dog/full/,100/0/default.jpg", IIIFLimits{}, 200, 100, nil},
+		{"dog/full/pct:25/0/default.jpg", IIIFLimits{}, 100, 50, nil},
+		{"dog/full/!100,100/0/default.jpg", IIIFLimits{}, 100, 50, nil},
+		{"dog/full/!1000,1000/0/default.jpg", IIIFLimits{}, 400, 200, nil},
+		{"dog/full/^!1000,1000/0/default.jpg", IIIFLimits{}, 1000, 500, nil},
+		{"dog/full/30,40/0/default.jpg", IIIFLimits{}, 30, 40, nil},
+		{"dog/full/max/90/default.jpg", IIIFLimits{}, 200, 400, nil},
+		{"dog/full/max/90/default.jpg", IIIFLimits{MaxHeight: 100}, 50, 100, nil},
+		{"dog/full/200,100/45/default.jpg", IIIFLimits{MaxArea: 20000}, 0, 0, ErrInvalidSize},
+		{"dog/full/800,/0/default.jpg", IIIFLimits{}, 0, 0, ErrInvalidSize},
+		{"dog/full/200,/0/default.jpg", IIIFLimits{MaxWidth: 100}, 0, 0, ErrInvalidSize},
+		{"dog/500,0,10,10/max/0/default.jpg", IIIFLimits{}, 0, 0, ErrInvalidRegion},
+	} {
+		r, err := ParseIIIF(tc.path)
+		assertError(t, err, nil)
+		i, err := r.Instruction(400, 200, tc.limits, "")
+		assertError(t, err, tc.err)
+		if err != nil {
+			continue
+		}
+		prc, err := NewProcessor(i)
+		assertError(t, err, nil)
+		img, err := prc.Process(&Data{Image: newGradientImage(400, 200)})
+		assertError(t, err, nil)
+		if got := img.Bounds().Size(); got != image.Pt(tc.width, tc.height) {
+			t.Errorf("%s: got %v, want %dx%d", tc.path, got, tc.width, tc.height)
+		}
+	}
+
+	t.Run("limit the canvas extended by the rotation", func(t *testing.T) {
+		r, _ := ParseIIIF("dog/full/4096,4096/45/default.jpg")
+		_, err := r.Instruction(4096, 4096, DefaultIIIFLimits, "")
+		assertValidationError(t, err, -1, "size", ErrInvalidSize)
+
+		r, _ = ParseIIIF("dog/full/max/45/default.jpg")
+		_, size, err := r.resolve(4096, 4096, DefaultIIIFLimits)
+		assertError(t, err, nil)
+		w, h := rotatedSize(size.X, size.Y, 45)
+		if w*h > DefaultMaxArea || size.X < 2800 {
+			t.Errorf("got %v rotated to %dx%d, want the largest size within %d pixels", size, w, h, DefaultMaxArea)
+		}
+	})
+
+	t.Run("mirror the image before rotating it", func(t *testing.T) {
+		src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+		src.SetRGBA(0, 0, color.RGBA{255, 0, 0, 255})
+		r, _ := ParseIIIF("dog/full/max/!0/default.png")
+		i, err := r.Instruction(4, 2, IIIFLimits{}, "")
+		assertError(t, err, nil)
+		prc, _ := NewProcessor(i)
+		img, err := prc.Process(&Data{Image: src})
+		assertError(t, err, nil)
+		if img.RGBAAt(3, 0) != (color.RGBA{255, 0, 0, 255}) || img.RGBAAt(0, 0) != (color.RGBA{}) {
+			t.Errorf("got %v at the right and %v at the left", img.RGBAAt(3, 0), img.RGBAAt(0, 0))
+		}
+	})
+}
+
+func TestIIIFRequestCanonical(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{"photos/dog.png/full/max/0/color.jpg", "photos%2Fdog.png/full/max/0/default.jpg"},
+		{"dog/0,0,400,200/400,/360/default.jpg", "dog/full/max/0/default.jpg"},
+		{"dog/square/pct:50/!90/default.png", "dog/100,0,200,200/100,100/!90/default.png"},
+		{"dog/full/^800,/0/default.jpg", "dog/full/^800,400/0/default.jpg"},
+	} {
+		r, err := ParseIIIF(tc.path)
+		assertError(t, err, nil)
+		got, err := r.Canonical(400, 200, IIIFLimits{})
+		assertError(t, err, nil)
+		assertString(t, got, tc.want)
+	}
+}
+
+func TestIIIFHandler(t *testing.T) {
+	h := NewIIIFHandler(newStubSource())
+	h.BaseURL = "https://example.com/iiif"
+	h.Limits = IIIFLimits{MaxWidth: 30}
+
+	t.Run("serve info.json", func(t *testing.T) {
+		rec := serve(h, http.MethodGet, "/photos%2Fdog.png/info.json", nil)
+		assertInt(t, rec.Code, http.StatusOK)
+		assertString(t, rec.Header().Get("Content-Type"), "application/json")
+		assertString(t, rec.Header().Get("Access-Control-Allow-Origin"), "*")
+		var info struct {
+			Context string `json:"@context"`
+			ID      string `json:"id"`
+			Profile string `json:"profile"`
+			Width   int    `json:"width"`
+			Height  int    `json:"height"`
+			Max     int    `json:"maxWidth"`
+			Tiles   []struct {
+				ScaleFactors []int `json:"scaleFactors"`
+			} `json:"tiles"`
+		}
+		assertError(t, json.NewDecoder(rec.Body).Decode(&info), nil)
+		assertString(t, info.Context, IIIFContext)
+		assertString(t, info.ID, "https://example.com/iiif/photos%2Fdog.png")
+		assertString(t, info.Profile, "level2")
+		assertInt(t, info.Width, 40)
+		assertInt(t, info.Height, 20)
+		assertInt(t, info.Max, 30)
+		assertInt(t, len(info.Tiles), 1)
+	})
+
+	t.Run("serve info.json as JSON-LD when it is accepted", func(t *testing.T) {
+		rec := serve(h, http.MethodGet, "/photos%2Fdog.png/info.json", http.Header{"Accept": {"application/ld+json"}})
+		assertString(t, rec.Header().Get("Content-Type"), `application/ld+json;profile="`+IIIFContext+`"`)
+	})
+
+	t.Run("serve the image request", func(t *testing.T) {
+		rec := serve(h, http.MethodGet, "/photos/dog.png/0,0,20,20/10,/0/default.jpg", nil)
+		assertInt(t, rec.Code, http.StatusOK)
+		assertString(t, rec.Header().Get("Content-Type"), "image/jpeg")
+		assertString(t, rec.Header().Get("Link"), `<https://example.com/iiif/photos%2Fdog.png/0,0,20,20/10,10/0/default.jpg>;rel="canonical"`)
+		img, _, err := image.Decode(rec.Body)
+		assertError(t, err, nil)
+		assertInt(t, img.Bounds().Dx(), 10)
+		assertInt(t, img.Bounds().Dy(), 10)
+	})
+
+	t.Run("reject source images beyond the maximum area before decoding them", func(t *testing.T) {
+		src := newStubSource()
+		src["photos/bomb.png"] = &fstest.MapFile{Data: newPNGHeader(100000, 100000)}
+		h := NewIIIFHandler(src)
+		rec := serve(h, http.MethodGet, "/photos%2Fbomb.png/0,0,10,10/10,/0/default.png", nil)
+		assertInt(t, rec.Code, http.StatusUnprocessableEntity)
+		if !strings.Contains(rec.Body.String(), ErrSourceTooLarge.Error()) {
+			t.Errorf("got %q, want %q", rec.Body.String(), ErrSourceTooLarge)
+		}
+		h.MaxSourceArea = 40*20 - 1
+		assertInt(t, serve(h, http.MethodGet, "/photos%2Fdog.png/full/max/0/default.png", nil).Code, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("keep max within the limits", func(t *testing.T) {
+		rec := serve(h, http.MethodGet, "/photos%2Fdog.png/full/max/0/default.png", nil)
+		assertInt(t, rec.Code, http.StatusOK)
+		img, _, err := image.Decode(rec.Body)
+		assertError(t, err, nil)
+		assertInt(t, img.Bounds().Dx(), 30)
+		assertInt(t, img.Bounds().Dy(), 15)
+	})
+
+	t.Run("share the ETag of equivalent requests", func(t *testing.T) {
+		a := serve(h, http.MethodGet, "/photos%2Fdog.png/full/max/0/default.png", nil).Header().Get("ETag")
+		b := serve(h, http.MethodGet, "/photos%2Fdog.png/0,0,40,20/30,/0/color.png", nil).Header().Get("ETag")
+		assertString(t, a, b)
+		rec := serve(h, http.MethodGet, "/photos%2Fdog.png/full/max/0/default.png", http.Header{"If-None-Match": {a}})
+		assertInt(t, rec.Code, http.StatusNotModified)
+	})
+
+	t.Run("redirect the base URI to info.json", func(t *testing.T) {
+		for _, target := range []string{"/photos/dog.png", "/photos%2Fdog.png"} {
+			rec := serve(h, http.MethodGet, target, nil)
+			assertInt(t, rec.Code, http.StatusSeeOther)
+			assertString(t, rec.Header().Get("Location"), "https://example.com/iiif/photos%2Fdog.png/info.json")
+		}
+	})
+
+	t.Run("limit upscaling by default", func(t *testing.T) {
+		unlimited := NewIIIFHandler(newStubSource())
+		rec := serve(unlimited, http.MethodGet, "/photos%2Fdog.png/full/^100000,100000/0/default.jpg", nil)
+		assertInt(t, rec.Code, http.StatusBadRequest)
+		rec = serve(unlimited, http.MethodGet, "/photos%2Fdog.png/full/^800,/0/default.png", nil)
+		assertInt(t, rec.Code, http.StatusOK)
+
+		var info struct {
+			MaxArea int `json:"maxArea"`
+		}
+		rec = serve(unlimited, http.MethodGet, "/photos%2Fdog.png/info.json", nil)
+		assertError(t, json.NewDecoder(rec.Body).Decode(&info), nil)
+		assertInt(t, info.MaxArea, DefaultMaxArea)
+	})
+
+	t.Run("reply with the status code of the error", func(t *testing.T) {
+		for _, tc := range []struct {
+			target string
+			code   int
+		}{
+			{"/photos%2Fbird.png/full/max/0/default.jpg", http.StatusNotFound},
+			{"/photos%2Fbird.png/info.json", http.StatusNotFound},
+			{"/photos%2Fdog.png/full/max/0/bitonal.jpg", http.StatusBadRequest},
+			{"/photos%2Fdog.png/full/max/0/default.gif", http.StatusBadRequest},
+			{"/photos%2Fdog.png/full/40,/0/default.jpg", http.StatusBadRequest},
+			{"/photos%2Fdog.png/100,100,10,10/max/0/default.jpg", http.StatusBadRequest},
+		} {
+			rec := serve(h, http.MethodGet, tc.target, nil)
+			if rec.Code != tc.code {
+				t.Errorf("%s: got %d, want %d", tc.target, rec.Code, tc.code)
+			}
+			if rec.Header().Get("ETag") != "" {
+				t.Errorf("%s: got an ETag on an error", tc.target)
+			}
+		}
+	})
+}
@@ -5,8 +5,6 @@ import (
 	"image"
 	"image/color"
 	"math"
-	"runtime"
-	"sync"
 )
 
 var (
@@ -15,10 +13,15 @@ var (
 )
 
 type interpolator interface {
-	interpolate(src, dst *image.RGBA) error
+	// check reports whether src is large enough to be sampled by the interpolator
+	check(src *image.RGBA) error
+	// at returns the color of src at the continuous coordinates (x, y) from the top-left corner of its bounds, where integer coordinates are pixel centers
+	at(src *image.RGBA, x, y float64) color.RGBA
+	// interpolate resamples src onto the whole of dst with the workers of s, and stops early once the context of s is done
+	interpolate(s *scratch, src, dst *image.RGBA) error
 }
 
-func newInterpolator(method string) interpolator {
+func newInterpolator(method Interpolation) interpolator {
 	var itp interpolator
 	switch method {
 	case NearestNeighbor:
@@ -33,7 +36,19 @@ func newInterpolator(method string) interpolator {
 
 type nearestNeighbor struct{}
 
-func (n *nearestNeighbor) interpolate(src, dst *image.RGBA) error {
+func (n *nearestNeighbor) check(src *image.RGBA) error {
+	return nil
+}
+
+func (n *nearestNeighbor) at(src *image.RGBA, x, y float64) color.RGBA {
+	srcW := src.Bounds().Dx()
+	srcH := src.Bounds().Dy()
+	nX := min(max(int(math.Floor(x+0.5)), 0), srcW-1)
+	nY := min(max(int(math.Floor(y+0.5)), 0), srcH-1)
+	return src.RGBAAt(src.Rect.Min.X+nX, src.Rect.Min.Y+nY)
+}
+
+func (n *nearestNeighbor) interpolate(s *scratch, src, dst *image.RGBA) error {
 	srcW := src.Bounds().Dx()
 	srcH := src.Bounds().Dy()
 	dstW := dst.Bounds().Dx()
@@ -42,29 +57,15 @@ func (n *nearestNeighbor) interpolate(src, dst *image.RGBA) error {
 	scaleX := getScale(srcW, dstW)
 	scaleY := getScale(srcH, dstH)
 
-	numGoroutines := runtime.NumCPU()
-	total := dstW * dstH
-	chunkSize := total / numGoroutines
-
-	var wg sync.WaitGroup
-
-	for i := range numGoroutines {
-		wg.Add(1)
-		go func(start, end int) {
-			defer wg.Done()
-			for ; start < end; start++ {
-				x := start % dstW
-				y := start / dstW
+	return s.parallel(dstH, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := range dstW {
 				transX := int(math.Floor(float64(x) / scaleX))
 				transY := int(math.Floor(float64(y) / scaleY))
-				dst.Set(x, y, src.At(transX, transY))
+				dst.SetRGBA(x, y, src.RGBAAt(src.Rect.Min.X+transX, src.Rect.Min.Y+transY))
 			}
-		}(i*chunkSize, (i+1)*chunkSize)
-	}
-
-	wg.Wait()
-
-	return nil
+		}
+	})
 }
 
 type bilinear struct{}
@@ -81,12 +82,143 @@ func (bl *bilinear) internalDivision(pR, pG, pB, pA *[2]float64, nV, v float64)
 	return r, g, b, a
 }
 
-func (bl *bilinear) interpolate(src, dst *image.RGBA) error {
+func (bl *bilinear) check(src *image.RGBA) error {
+	if src.Bounds().Dx() < 2 || src.Bounds().Dy() < 2 {
+		return ErrBilinearSrcImageTooSmall
+	}
+	return nil
+}
+
+func (bl *bilinear) at(src *image.RGBA, x, y float64) color.RGBA {
 	srcW := src.Bounds().Dx()
 	srcH := src.Bounds().Dy()
-	if srcW < 2 || srcH < 2 {
-		return ErrBilinearSrcImageTooSmall
+
+	// boundary check for edge points
+	edgeX := x < 0 || x > float64(srcW-1)
+	edgeY := y < 0 || y > float64(srcH-1)
+
+	// meaning of prefix
+	// n: nearest (largest integer value no larger than ...)
+	// l: left
+	// r: right
+	// t: top
+	// b: bottom
+	// i: interpolated
+
+	var iColor color.RGBA
+
+	// use just one nearest surrounding point
+	if edgeX && edgeY {
+		var nX int
+		var nY int
+
+		if x < 0 {
+			nX = 0
+		} else {
+			nX = srcW - 1
+		}
+
+		if y < 0 {
+			nY = 0
+		} else {
+			nY = srcH - 1
+		}
+
+		iColor = src.RGBAAt(src.Rect.Min.X+nX, src.Rect.Min.Y+nY)
+	} else if edgeX { // use two surrounding points (only y-axis)
+		var nX float64
+		if x < 0 {
+			nX = 0
+		} else {
+			nX = float64(srcW - 1)
+		}
+
+		nY := math.Floor(y)
+
+		// color values at two points (nX, nY) and (nX, nY+1) for each color channel (RGBA)
+		// index 0: color values at (nX, nY)
+		// index 1: color values at (nX, nY+1)
+		var pR, pG, pB, pA [2]float64
+
+		for i := range 2 {
+			pRGBA := src.RGBAAt(src.Rect.Min.X+int(nX), src.Rect.Min.Y+int(nY)+i)
+			pR[i] = float64(pRGBA.R)
+			pG[i] = float64(pRGBA.G)
+			pB[i] = float64(pRGBA.B)
+			pA[i] = float64(pRGBA.A)
+		}
+
+		iR, iG, iB, iA := bl.internalDivision(&pR, &pG, &pB, &pA, nY, y)
+
+		iColor = color.RGBA{clamp(iR), clamp(iG), clamp(iB), clamp(iA)}
+	} else if edgeY { // use two surrounding points (only x-axis)
+		var nY float64
+
+		if y < 0 {
+			nY = 0
+		} else {
+			nY = float64(srcH - 1)
+		}
+
+		nX := math.Floor(x)
+
+		// color values at two points (nX, nY) and (nX+1, nY) for each color channel (RGBA)
+		// index 0: color values at (nX, nY)
+		// index 1: color values at (nX+1, nY)
+		var pR, pG, pB, pA [2]float64
+
+		for i := range 2 {
+			pRGBA := src.RGBAAt(src.Rect.Min.X+int(nX)+i, src.Rect.Min.Y+int(nY))
+			pR[i] = float64(pRGBA.R)
+			pG[i] = float64(pRGBA.G)
+			pB[i] = float64(pRGBA.B)
+			pA[i] = float64(pRGBA.A)
+		}
+
+		iR, iG, iB, iA := bl.internalDivision(&pR, &pG, &pB, &pA, nX, x)
+
+		iColor = color.RGBA{clamp(iR), clamp(iG), clamp(iB), clamp(iA)}
+	} else { // use four surrounding points (both x-axis and y-axis)
+		nX := math.Floor(x)
+		nY := math.Floor(y)
+
+		// color values at four points (nX, nY), (nX+1, nY), (nX, nY+1) and (nX+1, nY+1) for each color channel (RGBA)
+		// index [0][0]: color values at (nX, nY)
+		// index [0][1]: color values at (nX+1, nY)
+		// index [1][0]: color values at (nX, nY+1)
+		// index [1][1]: color values at (nX+1, nY+1)
+		var pR, pG, pB, pA [2][2]float64
+
+		// temporarily saved color values got from internal division on x-axis
+		// index 0: values got from internal division on y=nY
+		// index 1: values got from internal division on y=nY+1
+		var tmpR, tmpG, tmpB, tmpA [2]float64
+
+		for i := range 2 {
+			for j := range 2 {
+				pRGBA := src.RGBAAt(src.Rect.Min.X+int(nX)+j, src.Rect.Min.Y+int(nY)+i)
+				pR[i][j] = float64(pRGBA.R)
+				pG[i][j] = float64(pRGBA.G)
+				pB[i][j] = float64(pRGBA.B)
+				pA[i][j] = float64(pRGBA.A)
+			}
+			tmpR[i], tmpG[i], tmpB[i], tmpA[i] = bl.internalDivision(&pR[i], &pG[i], &pB[i], &pA[i], nX, x)
+		}
+
+		iR, iG, iB, iA := bl.internalDivision(&tmpR, &tmpG, &tmpB, &tmpA, nY, y)
+
+		iColor = color.RGBA{clamp(iR), clamp(iG), clamp(iB), clamp(iA)}
 	}
+
+	return iColor
+}
+
+func (bl *bilinear) interpolate(s *scratch, src, dst *image.RGBA) error {
+	if err := bl.check(src); err != nil {
+		return err
+	}
+	srcW := src.Bounds().Dx()
+	srcH := src.Bounds().Dy()
 	dstW := dst.Bounds().Dx()
 	dstH := dst.Bounds().Dy()
 
@@ -96,146 +228,17 @@ func (bl *bilinear) interpolate(src, dst *image.RGBA) error {
 	offsetX := getOffset(scaleX)
 	offsetY := getOffset(scaleY)
 
-	numGoroutines := runtime.NumCPU()
-	total := dstW * dstH
-	chunkSize := total / numGoroutines
-
-	var wg sync.WaitGroup
-
-	for i := range numGoroutines {
-		wg.Add(1)
-		go func(start, end int) {
-			defer wg.Done()
-			for ; start < end; start++ {
-				x := start % dstW
-				y := start / dstW
+	return s.parallel(dstH, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := range dstW {
+				// transformed x and y
 				transX := float64(x)/scaleX - offsetX
 				transY := float64(y)/scaleY - offsetY
 
-				// boundary check for edge points
-				edgeX := transX < 0 || transX > float64(srcW-1)
-				edgeY := transY < 0 || transY > float64(srcH-1)
-
-				// meaning of prefix
-				// n: nearest (largest integer value no larger than ...)
-				// l: left
-				// r: right
-				// t: top
-				// b: bottom
-				// i: interpolated
-
-				var iColor color.RGBA
-
-				// use just one nearest surrounding point
-				if edgeX && edgeY {
-					var nX int
-					var nY int
-
-					if transX < 0 {
-						nX = 0
-					} else {
-						nX = srcW - 1
-					}
-
-					if transY < 0 {
-						nY = 0
-					} else {
-						nY = srcH - 1
-					}
-
-					iColor = src.RGBAAt(nX, nY)
-				} else if edgeX { // use two surrounding points (only y-axis)
-					var nX float64
-					if transX < 0 {
-						nX = 0
-					} else {
-						nX = float64(srcW - 1)
-					}
-
-					nY := math.Floor(transY)
-
-					// color values at two points (nX, nY) and (nX, nY+1) for each color channel (RGBA)
-					// index 0: color values at (nX, nY)
-					// index 1: color values at (nX, nY+1)
-					var pR, pG, pB, pA [2]float64
-
-					for i := range 2 {
-						pRGBA := src.RGBAAt(int(nX), int(nY)+i)
-						pR[i] = float64(pRGBA.R)
-						pG[i] = float64(pRGBA.G)
-						pB[i] = float64(pRGBA.B)
-						pA[i] = float64(pRGBA.A)
-					}
-
-					iR, iG, iB, iA := bl.internalDivision(&pR, &pG, &pB, &pA, nY, transY)
-
-					iColor = color.RGBA{clamp(iR), clamp(iG), clamp(iB), clamp(iA)}
-				} else if edgeY { // use two surrounding points (only x-axis)
-					var nY float64
-
-					if transY < 0 {
-						nY = 0
-					} else {
-						nY = float64(srcH - 1)
-					}
-
-					nX := math.Floor(transX)
-
-					// color values at two points (nX, nY) and (nX+1, nY) for each color channel (RGBA)
-					// index 0: color values at (nX, nY)
-					// index 1: color values at (nX+1, nY)
-					var pR, pG, pB, pA [2]float64
-
-					for i := range 2 {
-						pRGBA := src.RGBAAt(int(nX)+i, int(nY))
-						pR[i] = float64(pRGBA.R)
-						pG[i] = float64(pRGBA.G)
-						pB[i] = float64(pRGBA.B)
-						pA[i] = float64(pRGBA.A)
-					}
-
-					iR, iG, iB, iA := bl.internalDivision(&pR, &pG, &pB, &pA, nX, transX)
-
-					iColor = color.RGBA{clamp(iR), clamp(iG), clamp(iB), clamp(iA)}
-				} else { // use four surrounding points (both x-axis and y-axis)
-					nX := math.Floor(transX)
-					nY := math.Floor(transY)
-
-					// color values at four points (nX, nY), (nX+1, nY), (nX, nY+1) and (nX+1, nY+1) for each color channel (RGBA)
-					// index [0][0]: color values at (nX, nY)
-					// index [0][1]: color values at (nX+1, nY)
-					// index [1][0]: color values at (nX, nY+1)
-					// index [1][1]: color values at (nX+1, nY+1)
-					var pR, pG, pB, pA [2][2]float64
-
-					// temporarily saved color values got from internal division on x-axis
-					// index 0: values got from internal division on y=nY
-					// index 1: values got from internal division on y=nY+1
-					var tmpR, tmpG, tmpB, tmpA [2]float64
-
-					for i := range 2 {
-						for j := range 2 {
-							pRGBA := src.RGBAAt(int(nX)+j, int(nY)+i)
-							pR[i][j] = float64(pRGBA.R)
-							pG[i][j] = float64(pRGBA.G)
-							pB[i][j] = float64(pRGBA.B)
-							pA[i][j] = float64(pRGBA.A)
-						}
-						tmpR[i], tmpG[i], tmpB[i], tmpA[i] = bl.internalDivision(&pR[i], &pG[i], &pB[i], &pA[i], nX, transX)
-					}
-
-					iR, iG, iB, iA := bl.internalDivision(&tmpR, &tmpG, &tmpB, &tmpA, nY, transY)
-
-					iColor = color.RGBA{clamp(iR), clamp(iG), clamp(iB), clamp(iA)}
-				}
-				dst.Set(x, y, iColor)
+				dst.SetRGBA(x, y, bl.at(src, transX, transY))
 			}
-		}(i*chunkSize, (i+1)*chunkSize)
-	}
-
-	wg.Wait()
-
-	return nil
+		}
+	})
 }
 
 type bicubic struct{}
@@ -260,12 +263,163 @@ func (bc *bicubic) catmullRomSpline(u float64, p *[4]float64) float64 {
 	return 0.5 * (term1 + term2 + term3 + term4)
 }
 
-func (bc *bicubic) interpolate(src, dst *image.RGBA) error {
+func (bc *bicubic) check(src *image.RGBA) error {
+	if src.Bounds().Dx() < 4 || src.Bounds().Dy() < 4 {
+		return ErrBicubicSrcImageTooSmall
+	}
+	return nil
+}
+
+func (bc *bicubic) at(src *image.RGBA, x, y float64) color.RGBA {
 	srcW := src.Bounds().Dx()
 	srcH := src.Bounds().Dy()
-	if srcW < 4 || srcH < 4 {
-		return ErrBicubicSrcImageTooSmall
+
+	// boundary check
+	outX := x < 1 || x > float64(srcW-2)
+	outY := y < 1 || y > float64(srcH-2)
+
+	var iColor color.RGBA
+
+	// use just one nearest surrounding point
+	if outX && outY {
+		var nX int
+		var nY int
+
+		if x < 0.5 {
+			nX = 0
+		} else if x < 1 {
+			nX = 1
+		} else if x <= float64(srcW)-1.5 {
+			nX = srcW - 2
+		} else {
+			nX = srcW - 1
+		}
+
+		if y < 0.5 {
+			nY = 0
+		} else if y < 1 {
+			nY = 1
+		} else if y <= float64(srcH)-1.5 {
+			nY = srcH - 2
+		} else {
+			nY = srcH - 1
+		}
+
+		iColor = src.RGBAAt(src.Rect.Min.X+nX, src.Rect.Min.Y+nY)
+	} else if outX { // use only y-axis
+		var nX int
+
+		if x < 0.5 {
+			nX = 0
+		} else if x < 1 {
+			nX = 1
+		} else if x <= float64(srcW)-1.5 {
+			nX = srcW - 2
+		} else {
+			nX = srcW - 1
+		}
+
+		floorY := math.Floor(y)
+		fractionY := y - floorY
+
+		intY := int(floorY)
+
+		var pR, pG, pB, pA [4]float64
+
+		for i := range 4 {
+			c := src.RGBAAt(src.Rect.Min.X+nX, src.Rect.Min.Y+intY-1+i)
+			pR[i] = float64(c.R)
+			pG[i] = float64(c.G)
+			pB[i] = float64(c.B)
+			pA[i] = float64(c.A)
+		}
+
+		iR := clamp(bc.catmullRomSpline(fractionY, &pR))
+		iG := clamp(bc.catmullRomSpline(fractionY, &pG))
+		iB := clamp(bc.catmullRomSpline(fractionY, &pB))
+		iA := clamp(bc.catmullRomSpline(fractionY, &pA))
+
+		iColor = color.RGBA{iR, iG, iB, iA}
+	} else if outY { // use only x-axis
+		var nY int
+
+		if y < 0.5 {
+			nY = 0
+		} else if y < 1 {
+			nY = 1
+		} else if y <= float64(srcH)-1.5 {
+			nY = srcH - 2
+		} else {
+			nY = srcH - 1
+		}
+
+		floorX := math.Floor(x)
+		fractionX := x - floorX
+
+		intX := int(floorX)
+
+		var pR, pG, pB, pA [4]float64
+
+		for i := range 4 {
+			c := src.RGBAAt(src.Rect.Min.X+intX-1+i, src.Rect.Min.Y+nY)
+			pR[i] = float64(c.R)
+			pG[i] = float64(c.G)
+			pB[i] = float64(c.B)
+			pA[i] = float64(c.A)
+		}
+
+		iR := clamp(bc.catmullRomSpline(fractionX, &pR))
+		iG := clamp(bc.catmullRomSpline(fractionX, &pG))
+		iB := clamp(bc.catmullRomSpline(fractionX, &pB))
+		iA := clamp(bc.catmullRomSpline(fractionX, &pA))
+
+		iColor = color.RGBA{iR, iG, iB, iA}
+	} else { // use both two axes, x first y later
+		floorX := math.Floor(x)
+		fractionX := x - floorX
+
+		intX := int(floorX)
+
+		floorY := math.Floor(y)
+		fractionY := y - floorY
+
+		intY := int(floorY)
+
+		var tmpR, tmpG, tmpB, tmpA [4]float64
+		var pR, pG, pB, pA [4][4]float64
+
+		for i := range 4 {
+			for j := range 4 {
+				c := src.RGBAAt(src.Rect.Min.X+intX-1+j, src.Rect.Min.Y+intY-1+i)
+				pR[i][j] = float64(c.R)
+				pG[i][j] = float64(c.G)
+				pB[i][j] = float64(c.B)
+				pA[i][j] = float64(c.A)
+			}
+
+			tmpR[i] = bc.catmullRomSpline(fractionX, &pR[i])
+			tmpG[i] = bc.catmullRomSpline(fractionX, &pG[i])
+			tmpB[i] = bc.catmullRomSpline(fractionX, &pB[i])
+			tmpA[i] = bc.catmullRomSpline(fractionX, &pA[i])
+		}
+
+		iR := clamp(bc.catmullRomSpline(fractionY, &tmpR))
+		iG := clamp(bc.catmullRomSpline(fractionY, &tmpG))
+		iB := clamp(bc.catmullRomSpline(fractionY, &tmpB))
+		iA := clamp(bc.catmullRomSpline(fractionY, &tmpA))
+
+		iColor = color.RGBA{iR, iG, iB, iA}
+	}
+
+	return iColor
+}
+
+func (bc *bicubic) interpolate(s *scratch, src, dst *image.RGBA) error {
+	if err := bc.check(src); err != nil {
+		return err
 	}
+	srcW := src.Bounds().Dx()
+	srcH := src.Bounds().Dy()
 	dstW := dst.Bounds().Dx()
 	dstH := dst.Bounds().Dy()
 
@@ -275,168 +429,17 @@ func (bc *bicubic) interpolate(src, dst *image.RGBA) error {
 	offsetX := getOffset(scaleX)
 	offsetY := getOffset(scaleY)
 
-	numGoroutines := runtime.NumCPU()
-	total := dstW * dstH
-	chunkSize := total / numGoroutines
-
-	var wg sync.WaitGroup
-
-	for i := range numGoroutines {
-		wg.Add(1)
-		go func(start, end int) {
-			defer wg.Done()
-			for ; start < end; start++ {
-				x := start % dstW
-				y := start / dstW
-
+	return s.parallel(dstH, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := range dstW {
 				// transformed x and y
 				transX := float64(x)/scaleX - offsetX
 				transY := float64(y)/scaleY - offsetY
 
-				// boundary check
-				outX := transX < 1 || transX > float64(srcW-2)
-				outY := transY < 1 || transY > float64(srcH-2)
-
-				var iColor color.RGBA
-
-				// use just one nearest surrounding point
-				if outX && outY {
-					var nX int
-					var nY int
-
-					if transX < 0.5 {
-						nX = 0
-					} else if transX < 1 {
-						nX = 1
-					} else if transX <= float64(srcW)-1.5 {
-						nX = srcW - 2
-					} else {
-						nX = srcW - 1
-					}
-
-					if transY < 0.5 {
-						nY = 0
-					} else if transY < 1 {
-						nY = 1
-					} else if transY <= float64(srcH)-1.5 {
-						nY = srcH - 2
-					} else {
-						nY = srcH - 1
-					}
-
-					iColor = src.RGBAAt(nX, nY)
-				} else if outX { // use only y-axis
-					var nX int
-
-					if transX < 0.5 {
-						nX = 0
-					} else if transX < 1 {
-						nX = 1
-					} else if transX <= float64(srcW)-1.5 {
-						nX = srcW - 2
-					} else {
-						nX = srcW - 1
-					}
-
-					floorY := math.Floor(transY)
-					fractionY := transY - floorY
-
-					intY := int(floorY)
-
-					var pR, pG, pB, pA [4]float64
-
-					for i := range 4 {
-						c := src.RGBAAt(nX, intY-1+i)
-						pR[i] = float64(c.R)
-						pG[i] = float64(c.G)
-						pB[i] = float64(c.B)
-						pA[i] = float64(c.A)
-					}
-
-					iR := clamp(bc.catmullRomSpline(fractionY, &pR))
-					iG := clamp(bc.catmullRomSpline(fractionY, &pG))
-					iB := clamp(bc.catmullRomSpline(fractionY, &pB))
-					iA := clamp(bc.catmullRomSpline(fractionY, &pA))
-
-					iColor = color.RGBA{iR, iG, iB, iA}
-				} else if outY { // use only x-axis
-					var nY int
-
-					if transY < 0.5 {
-						nY = 0
-					} else if transY < 1 {
-						nY = 1
-					} else if transY <= float64(srcH)-1.5 {
-						nY = srcH - 2
-					} else {
-						nY = srcH - 1
-					}
-
-					floorX := math.Floor(transX)
-					fractionX := transX - floorX
-
-					intX := int(floorX)
-
-					var pR, pG, pB, pA [4]float64
-
-					for i := range 4 {
-						c := src.RGBAAt(intX-1+i, nY)
-						pR[i] = float64(c.R)
-						pG[i] = float64(c.G)
-						pB[i] = float64(c.B)
-						pA[i] = float64(c.A)
-					}
-
-					iR := clamp(bc.catmullRomSpline(fractionX, &pR))
-					iG := clamp(bc.catmullRomSpline(fractionX, &pG))
-					iB := clamp(bc.catmullRomSpline(fractionX, &pB))
-					iA := clamp(bc.catmullRomSpline(fractionX, &pA))
-
-					iColor = color.RGBA{iR, iG, iB, iA}
-				} else { // use both two axes, x first y later
-					floorX := math.Floor(transX)
-					fractionX := transX - floorX
-
-					intX := int(floorX)
-
-					floorY := math.Floor(transY)
-					fractionY := transY - floorY
-
-					intY := int(floorY)
-
-					var tmpR, tmpG, tmpB, tmpA [4]float64
-					var pR, pG, pB, pA [4][4]float64
-
-					for i := range 4 {
-						for j := range 4 {
-							c := src.RGBAAt(intX-1+j, intY-1+i)
-							pR[i][j] = float64(c.R)
-							pG[i][j] = float64(c.G)
-							pB[i][j] = float64(c.B)
-							pA[i][j] = float64(c.A)
-						}
-
-						tmpR[i] = bc.catmullRomSpline(fractionX, &pR[i])
-						tmpG[i] = bc.catmullRomSpline(fractionX, &pG[i])
-						tmpB[i] = bc.catmullRomSpline(fractionX, &pB[i])
-						tmpA[i] = bc.catmullRomSpline(fractionX, &pA[i])
-					}
-
-					iR := clamp(bc.catmullRomSpline(fractionY, &tmpR))
-					iG := clamp(bc.catmullRomSpline(fractionY, &tmpG))
-					iB := clamp(bc.catmullRomSpline(fractionY, &tmpB))
-					iA := clamp(bc.catmullRomSpline(fractionY, &tmpA))
-
-					iColor = color.RGBA{iR, iG, iB, iA}
-				}
-				dst.Set(x, y, iColor)
+				dst.SetRGBA(x, y, bc.at(src, transX, transY))
 			}
-		}(i*chunkSize, (i+1)*chunkSize)
-	}
-
-	wg.Wait()
-
-	return nil
+		}
+	})
 }
 
 // return k s.t. a*k = b
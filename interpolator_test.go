@@ -4,6 +4,7 @@ import (
 	"image"
 	"image/color"
 	"math"
+	"reflect"
 	"testing"
 )
 
@@ -22,7 +23,7 @@ func TestNearestNeighbor(t *testing.T) {
 	}
 	dst := image.NewRGBA(image.Rect(0, 0, dim*scale, dim*scale))
 	nn := &nearestNeighbor{}
-	_ = nn.interpolate(src, dst)
+	_ = nn.interpolate(&scratch{}, src, dst)
 
 	for y := range dim * scale {
 		for x := range dim * scale {
@@ -60,13 +61,37 @@ func TestBilinear(t *testing.T) {
 		bl := &bilinear{}
 		src := image.NewRGBA(image.Rect(0, 0, 1, 1))
 		dst := image.NewRGBA(image.Rect(0, 0, 2, 2))
-		err := bl.interpolate(src, dst)
+		err := bl.interpolate(&scratch{}, src, dst)
 		if err != ErrBilinearSrcImageTooSmall {
 			t.Errorf("got %v, want %v", err, ErrBilinearSrcImageTooSmall)
 		}
 	})
 }
 
+func TestInterpolators(t *testing.T) {
+	t.Run("sample a sub-image as a copy of its area", func(t *testing.T) {
+		src := newGradientImage(16, 12)
+		sub := src.SubImage(image.Rect(3, 2, 13, 10)).(*image.RGBA)
+		area, _ := Crop{X: 3, Y: 2, Width: 10, Height: 8}.Apply(src)
+		for _, m := range []Interpolation{NearestNeighbor, Bilinear, Bicubic} {
+			for _, op := range []interface {
+				Operation
+				Apply(src *image.RGBA) (*image.RGBA, error)
+			}{
+				Resize{Width: 7, Height: 13, Interpolation: m},
+				Warp{Matrix: Affine{1.5, 0.2, -1, 0.1, 0.8, 2}.Homography(), Interpolation: m},
+			} {
+				got, err := op.Apply(sub)
+				assertError(t, err, nil)
+				want, _ := op.Apply(area)
+				if !reflect.DeepEqual(got.Pix, want.Pix) {
+					t.Errorf("%s: got other pixels than the %s of the copy", m, op.kind())
+				}
+			}
+		}
+	})
+}
+
 func TestCatmullRomSpline(t *testing.T) {
 
 }
@@ -88,7 +113,7 @@ func TestBiCubic(t *testing.T) {
 		bc := &bicubic{}
 		src := image.NewRGBA(image.Rect(0, 0, 3, 3))
 		dst := image.NewRGBA(image.Rect(0, 0, 10, 10))
-		err := bc.interpolate(src, dst)
+		err := bc.interpolate(&scratch{}, src, dst)
 		if err != ErrBicubicSrcImageTooSmall {
 			t.Errorf("got %v, want %v", err, ErrBicubicSrcImageTooSmall)
 		}
@@ -0,0 +1,382 @@
+package gato
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LUTInterpolation is the interpolation method of 3D lookup tables, between the entries surrounding a color.
+type LUTInterpolation string
+
+const (
+	Trilinear   LUTInterpolation = "trilinear"
+	Tetrahedral LUTInterpolation = "tetrahedral"
+)
+
+var (
+	ErrInvalidCube             = errors.New("invalid cube file")
+	ErrInvalidLUT              = errors.New("invalid lut: the lookup table must be set")
+	ErrInvalidIntensity        = errors.New("invalid intensity: intensity must be between 0 and 1")
+	ErrInvalidLUTInterpolation = errors.New("invalid interpolation method: only trilinear and tetrahedral are available for lookup tables")
+)
+
+// validate reports whether m is a known interpolation method. The empty method is valid and means Tetrahedral.
+func (m LUTInterpolation) validate() error {
+	switch m {
+	case "", Trilinear, Tetrahedral:
+		return nil
+	}
+	return ErrInvalidLUTInterpolation
+}
+
+func (m LUTInterpolation) MarshalText() ([]byte, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return []byte(m), nil
+}
+
+func (m *LUTInterpolation) UnmarshalText(text []byte) error {
+	if err := LUTInterpolation(text).validate(); err != nil {
+		return fieldError("interpolation", err)
+	}
+	*m = LUTInterpolation(text)
+	return nil
+}
+
+// LUT is a color lookup table, as read from an Adobe or Resolve .cube file.
+// A 1D table maps every channel on its own, and a 3D table maps colors as a whole, e.g. for film looks.
+type LUT struct {
+	Title string
+	// Dimensions is 1 or 3.
+	Dimensions int
+	// Size is the number of entries of a 1D table, or the number of entries along every axis of a 3D table.
+	Size int
+	// DomainMin and DomainMax are the input values of the first and last entries, per channel. They are 0 and 1 by default.
+	DomainMin [3]float64
+	DomainMax [3]float64
+	// Table holds Size entries for a 1D table, or Size^3 entries for a 3D table, with red changing fastest, then green, then blue.
+	Table [][3]float64
+}
+
+// ParseCube reads a LUT from r in the .cube format.
+// Errors point to the invalid line, and wrap ErrInvalidCube.
+func ParseCube(r io.Reader) (*LUT, error) {
+	l := &LUT{DomainMax: [3]float64{1, 1, 1}}
+	sc := bufio.NewScanner(r)
+	line := 0
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: line %d: %s", ErrInvalidCube, line, fmt.Sprintf(format, args...))
+	}
+	// floats parses n numbers of fields
+	floats := func(fields []string, n int) ([]float64, error) {
+		if len(fields) != n {
+			return nil, invalid("want %d numbers, got %d", n, len(fields))
+		}
+		v := make([]float64, n)
+		for i, f := range fields {
+			var err error
+			if v[i], err = strconv.ParseFloat(f, 64); err != nil || math.IsNaN(v[i]) || math.IsInf(v[i], 0) {
+				return nil, invalid("%q is not a number", f)
+			}
+		}
+		return v, nil
+	}
+
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		switch key := fields[0]; key {
+		case "TITLE":
+			title := strings.TrimSpace(strings.TrimPrefix(text, "TITLE"))
+			l.Title = strings.Trim(title, `"`)
+		case "LUT_1D_SIZE", "LUT_3D_SIZE":
+			if l.Size != 0 {
+				return nil, invalid("the size is set twice")
+			}
+			if len(fields) != 2 {
+				return nil, invalid("want a size")
+			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < 2 || (key == "LUT_1D_SIZE" && n > 65536) || (key == "LUT_3D_SIZE" && n > 256) {
+				return nil, invalid("invalid size %q", fields[1])
+			}
+			l.Size = n
+			l.Dimensions = 1
+			if key == "LUT_3D_SIZE" {
+				l.Dimensions = 3
+			}
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			v, err := floats(fields[1:], 3)
+			if err != nil {
+				return nil, err
+			}
+			if key == "DOMAIN_MIN" {
+				l.DomainMin = [3]float64(v)
+			} else {
+				l.DomainMax = [3]float64(v)
+			}
+		case "LUT_1D_INPUT_RANGE", "LUT_3D_INPUT_RANGE":
+			// the Resolve form of the domain, shared by the three channels
+			v, err := floats(fields[1:], 2)
+			if err != nil {
+				return nil, err
+			}
+			l.DomainMin = [3]float64{v[0], v[0], v[0]}
+			l.DomainMax = [3]float64{v[1], v[1], v[1]}
+		default:
+			if l.Size == 0 {
+				return nil, invalid("unknown keyword %q before the size", key)
+			}
+			v, err := floats(fields, 3)
+			if err != nil {
+				return nil, err
+			}
+			l.Table = append(l.Table, [3]float64(v))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if l.Size == 0 {
+		return nil, fmt.Errorf("%w: no LUT_1D_SIZE or LUT_3D_SIZE", ErrInvalidCube)
+	}
+	if want := l.entries(); len(l.Table) != want {
+		return nil, fmt.Errorf("%w: want %d entries, got %d", ErrInvalidCube, want, len(l.Table))
+	}
+	for c := range 3 {
+		if !(l.DomainMin[c] < l.DomainMax[c]) {
+			return nil, fmt.Errorf("%w: the domain minimum must be less than its maximum", ErrInvalidCube)
+		}
+	}
+	return l, nil
+}
+
+// entries returns the number of entries of the table of l.
+func (l *LUT) entries() int {
+	if l.Dimensions == 3 {
+		return l.Size * l.Size * l.Size
+	}
+	return l.Size
+}
+
+// WriteCube writes l to w in the .cube format, which ParseCube reads back.
+func (l *LUT) WriteCube(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	if l.Title != "" {
+		fmt.Fprintf(b, "TITLE %q\n", l.Title)
+	}
+	fmt.Fprintf(b, "LUT_%dD_SIZE %d\n", l.Dimensions, l.Size)
+	if l.DomainMin != [3]float64{} || l.DomainMax != [3]float64{1, 1, 1} {
+		fmt.Fprintf(b, "DOMAIN_MIN %s\nDOMAIN_MAX %s\n", formatTriple(l.DomainMin), formatTriple(l.DomainMax))
+	}
+	for _, e := range l.Table {
+		fmt.Fprintln(b, formatTriple(e))
+	}
+	return b.Flush()
+}
+
+func formatTriple(v [3]float64) string {
+	return strconv.FormatFloat(v[0], 'g', -1, 64) + " " + strconv.FormatFloat(v[1], 'g', -1, 64) + " " + strconv.FormatFloat(v[2], 'g', -1, 64)
+}
+
+// MarshalText marshals l in the .cube format, so that operations holding a LUT store it with them.
+func (l *LUT) MarshalText() ([]byte, error) {
+	var b strings.Builder
+	if err := l.WriteCube(&b); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+func (l *LUT) UnmarshalText(text []byte) error {
+	parsed, err := ParseCube(strings.NewReader(string(text)))
+	if err != nil {
+		return fieldError("lut", err)
+	}
+	*l = *parsed
+	return nil
+}
+
+// lookup maps the straight color c, whose channels range from 0 to 1, through l with the interpolation method m.
+func (l *LUT) lookup(c [3]float64, m LUTInterpolation) [3]float64 {
+	n := l.Size
+	// positions of the channels in the table
+	var pos [3]float64
+	for k := range 3 {
+		t := (c[k] - l.DomainMin[k]) / (l.DomainMax[k] - l.DomainMin[k])
+		pos[k] = min(max(t, 0), 1) * float64(n-1)
+	}
+
+	if l.Dimensions == 1 {
+		var out [3]float64
+		for k := range 3 {
+			i := min(int(pos[k]), n-2)
+			f := pos[k] - float64(i)
+			out[k] = l.Table[i][k]*(1-f) + l.Table[i+1][k]*f
+		}
+		return out
+	}
+
+	var i [3]int
+	var f [3]float64
+	for k := range 3 {
+		i[k] = min(int(pos[k]), n-2)
+		f[k] = pos[k] - float64(i[k])
+	}
+	// corner returns the entry at the offsets of the red, green and blue indices
+	corner := func(dr, dg, db int) [3]float64 {
+		return l.Table[(i[0]+dr)+(i[1]+dg)*n+(i[2]+db)*n*n]
+	}
+	fr, fg, fb := f[0], f[1], f[2]
+
+	var out [3]float64
+	if m == Tetrahedral {
+		// the cube is split into six tetrahedra along its diagonal, and the color is weighed within the one containing it
+		c000, c111 := corner(0, 0, 0), corner(1, 1, 1)
+		var a, b [3]float64
+		var wa, wb, wc float64
+		switch {
+		case fr > fg && fg > fb:
+			a, b, wa, wb, wc = corner(1, 0, 0), corner(1, 1, 0), fr, fg, fb
+		case fr > fg && fr > fb:
+			a, b, wa, wb, wc = corner(1, 0, 0), corner(1, 0, 1), fr, fb, fg
+		case fr > fg:
+			a, b, wa, wb, wc = corner(0, 0, 1), corner(1, 0, 1), fb, fr, fg
+		case fb > fg:
+			a, b, wa, wb, wc = corner(0, 0, 1), corner(0, 1, 1), fb, fg, fr
+		case fb > fr:
+			a, b, wa, wb, wc = corner(0, 1, 0), corner(0, 1, 1), fg, fb, fr
+		default:
+			a, b, wa, wb, wc = corner(0, 1, 0), corner(1, 1, 0), fg, fr, fb
+		}
+		for k := range 3 {
+			out[k] = c000[k] + wa*(a[k]-c000[k]) + wb*(b[k]-a[k]) + wc*(c111[k]-b[k])
+		}
+		return out
+	}
+
+	for db := range 2 {
+		wb := fb
+		if db == 0 {
+			wb = 1 - fb
+		}
+		for dg := range 2 {
+			wg := fg
+			if dg == 0 {
+				wg = 1 - fg
+			}
+			for dr := range 2 {
+				wr := fr
+				if dr == 0 {
+					wr = 1 - fr
+				}
+				e := corner(dr, dg, db)
+				for k := range 3 {
+					out[k] += wr * wg * wb * e[k]
+				}
+			}
+		}
+	}
+	return out
+}
+
+// ColorGrade is an operation that maps the colors of an image through a lookup table, e.g. one loaded with ParseCube.
+type ColorGrade struct {
+	LUT *LUT `json:"lut"`
+	// Interpolation is the interpolation method of 3D tables, Trilinear or Tetrahedral. If it is not set, it defaults to Tetrahedral,
+	// which follows the neutral axis more closely. 1D tables are always interpolated linearly.
+	Interpolation LUTInterpolation `json:"interpolation,omitempty"`
+	// Intensity blends the graded colors with the original ones, from 0 (original) to 1 (graded). If it is not set, it defaults to 1.
+	// It is a pointer so that 0 can be set, e.g. to turn the grade off without removing it from a stored pipeline.
+	Intensity *float64 `json:"intensity,omitempty"`
+}
+
+func (cg ColorGrade) kind() string {
+	return "color_grade"
+}
+
+func (cg ColorGrade) validate() error {
+	if cg.LUT == nil || cg.LUT.Size < 2 || (cg.LUT.Dimensions != 1 && cg.LUT.Dimensions != 3) || len(cg.LUT.Table) != cg.LUT.entries() {
+		return fieldError("lut", ErrInvalidLUT)
+	}
+	if err := cg.Interpolation.validate(); err != nil {
+		return fieldError("interpolation", err)
+	}
+	if cg.Intensity != nil && !(*cg.Intensity >= 0 && *cg.Intensity <= 1) {
+		return fieldError("intensity", ErrInvalidIntensity)
+	}
+	return nil
+}
+
+func (cg ColorGrade) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	m := cg.Interpolation
+	if m == "" {
+		m = Tetrahedral
+	}
+	intensity := 1.0
+	if cg.Intensity != nil {
+		intensity = *cg.Intensity
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := s.get(w, h)
+	err := s.parallel(h, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := range w {
+				c := src.RGBAAt(src.Rect.Min.X+x, src.Rect.Min.Y+y)
+				if c.A == 0 {
+					continue
+				}
+				// the table applies to straight colors
+				a := float64(c.A)
+				in := [3]float64{float64(c.R) / a, float64(c.G) / a, float64(c.B) / a}
+				out := cg.LUT.lookup(in, m)
+				var v [3]uint8
+				for k := range 3 {
+					v[k] = clamp((in[k] + intensity*(out[k]-in[k])) * a)
+				}
+				dst.SetRGBA(x, y, color.RGBA{min(v[0], c.A), min(v[1], c.A), min(v[2], c.A), c.A})
+			}
+		}
+	})
+	if err != nil {
+		s.put(dst)
+		return nil, err
+	}
+	return dst, nil
+}
+
+// Apply returns a new image of the source graded following the operation.
+func (cg ColorGrade) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := cg.validate(); err != nil {
+		return nil, err
+	}
+	return cg.apply(&scratch{}, src)
+}
+
+func (cg ColorGrade) MarshalJSON() ([]byte, error) {
+	type alias ColorGrade
+	return marshalOperation(cg.kind(), alias(cg))
+}
+
+func (cg *ColorGrade) UnmarshalJSON(data []byte) error {
+	type alias ColorGrade
+	v := struct {
+		Op string `json:"op"`
+		*alias
+	}{alias: (*alias)(cg)}
+	return decodeStrict(data, &v)
+}
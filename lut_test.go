@@ -0,0 +1,204 @@
+package gato
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newIdentityLUT returns the 3D lookup table of size n that maps every color to itself.
+func newIdentityLUT(n int) *LUT {
+	l := &LUT{Dimensions: 3, Size: n, DomainMax: [3]float64{1, 1, 1}}
+	for b := range n {
+		for g := range n {
+			for r := range n {
+				l.Table = append(l.Table, [3]float64{float64(r) / float64(n-1), float64(g) / float64(n-1), float64(b) / float64(n-1)})
+			}
+		}
+	}
+	return l
+}
+
+func TestParseCube(t *testing.T) {
+	t.Run("read a 3D table", func(t *testing.T) {
+		cube := `# made by hand
+TITLE "Warm look"
+LUT_3D_SIZE 2
+DOMAIN_MIN 0 0 0
+DOMAIN_MAX 1 1 1
+
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 0.9
+`
+		l, err := ParseCube(strings.NewReader(cube))
+		assertError(t, err, nil)
+		assertString(t, l.Title, "Warm look")
+		assertInt(t, l.Dimensions, 3)
+		assertInt(t, l.Size, 2)
+		assertInt(t, len(l.Table), 8)
+		if l.Table[7] != [3]float64{1, 1, 0.9} {
+			t.Errorf("got %v for the last entry", l.Table[7])
+		}
+	})
+
+	t.Run("read a 1D table with an input range", func(t *testing.T) {
+		l, err := ParseCube(strings.NewReader("LUT_1D_SIZE 3\nLUT_1D_INPUT_RANGE 0 2\n0 0 0\n0.5 0.5 0.5\n1 1 1\n"))
+		assertError(t, err, nil)
+		assertInt(t, l.Dimensions, 1)
+		if l.DomainMax != [3]float64{2, 2, 2} {
+			t.Errorf("got domain maximum %v, want 2", l.DomainMax)
+		}
+	})
+
+	t.Run("write a table that reads back the same", func(t *testing.T) {
+		want := newIdentityLUT(3)
+		want.Title = "Identity"
+		want.DomainMin = [3]float64{-0.1, 0, 0}
+		var b strings.Builder
+		err := want.WriteCube(&b)
+		assertError(t, err, nil)
+		got, err := ParseCube(strings.NewReader(b.String()))
+		assertError(t, err, nil)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("return error when the cube is invalid", func(t *testing.T) {
+		cubes := map[string]string{
+			"missing size":        "0 0 0\n1 1 1\n",
+			"missing entries":     "LUT_3D_SIZE 2\n0 0 0\n1 1 1\n",
+			"invalid number":      "LUT_1D_SIZE 2\n0 0 zero\n1 1 1\n",
+			"too few numbers":     "LUT_1D_SIZE 2\n0 0\n1 1 1\n",
+			"invalid size":        "LUT_3D_SIZE 1\n0 0 0\n",
+			"size set twice":      "LUT_1D_SIZE 2\nLUT_3D_SIZE 2\n",
+			"reversed domain":     "LUT_1D_SIZE 2\nDOMAIN_MIN 1 1 1\nDOMAIN_MAX 0 0 0\n0 0 0\n1 1 1\n",
+			"unknown before size": "LUT_2D_SIZE 2\n",
+		}
+		for name, cube := range cubes {
+			t.Run(name, func(t *testing.T) {
+				_, err := ParseCube(strings.NewReader(cube))
+				assertError(t, err, ErrInvalidCube)
+			})
+		}
+	})
+
+	t.Run("point to the invalid line", func(t *testing.T) {
+		_, err := ParseCube(strings.NewReader("LUT_1D_SIZE 2\n\n0 0 0\n1 1 x\n"))
+		if err == nil || !strings.Contains(err.Error(), "line 4") {
+			t.Errorf("got %v, want an error at line 4", err)
+		}
+	})
+}
+
+func TestColorGrade(t *testing.T) {
+	t.Run("leave colors as they are with an identity table", func(t *testing.T) {
+		src := newGradientImage(16, 16)
+		src.SetRGBA(0, 0, color.RGBA{50, 20, 10, 100})
+		for _, m := range []LUTInterpolation{Trilinear, Tetrahedral} {
+			dst, err := ColorGrade{LUT: newIdentityLUT(5), Interpolation: m}.Apply(src)
+			assertError(t, err, nil)
+			if !reflect.DeepEqual(dst.Pix, src.Pix) {
+				t.Errorf("%s changed the colors", m)
+			}
+		}
+	})
+
+	t.Run("agree between interpolation methods on the grid", func(t *testing.T) {
+		l := newIdentityLUT(3)
+		for i := range l.Table {
+			// a table that is not linear, so that the methods differ between the grid points
+			l.Table[i] = [3]float64{l.Table[i][1] * l.Table[i][2], l.Table[i][0], math.Sqrt(l.Table[i][2])}
+		}
+		for _, c := range [][3]float64{{0, 0.5, 1}, {0.5, 0.5, 0.5}, {1, 0, 0.5}} {
+			tri, tet := l.lookup(c, Trilinear), l.lookup(c, Tetrahedral)
+			for k := range 3 {
+				if math.Abs(tri[k]-tet[k]) > 1e-9 {
+					t.Errorf("got %v and %v for %v", tri, tet, c)
+					break
+				}
+			}
+		}
+	})
+
+	t.Run("invert with a 1D table and blend with intensity", func(t *testing.T) {
+		invert := &LUT{Dimensions: 1, Size: 2, DomainMax: [3]float64{1, 1, 1}, Table: [][3]float64{{1, 1, 1}, {0, 0, 0}}}
+		src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+		src.SetRGBA(0, 0, color.RGBA{200, 100, 0, 255})
+		src.SetRGBA(1, 0, color.RGBA{100, 50, 0, 128})
+
+		dst, err := ColorGrade{LUT: invert}.Apply(src)
+		assertError(t, err, nil)
+		if got := dst.RGBAAt(0, 0); got != (color.RGBA{55, 155, 255, 255}) {
+			t.Errorf("got %v for opaque orange", got)
+		}
+		// the table applies to straight colors, and alpha is kept as it is
+		if got := dst.RGBAAt(1, 0); got != (color.RGBA{28, 78, 128, 128}) {
+			t.Errorf("got %v for translucent orange", got)
+		}
+
+		half := 0.5
+		dst, _ = ColorGrade{LUT: invert, Intensity: &half}.Apply(src)
+		if got := dst.RGBAAt(0, 0); got != (color.RGBA{128, 128, 128, 255}) {
+			t.Errorf("got %v for half inverted orange", got)
+		}
+	})
+
+	t.Run("leave the colors as they are with an intensity of 0", func(t *testing.T) {
+		var cg ColorGrade
+		err := json.Unmarshal([]byte(`{"op":"color_grade","intensity":0}`), &cg)
+		assertError(t, err, nil)
+		cg.LUT = &LUT{Dimensions: 1, Size: 2, DomainMax: [3]float64{1, 1, 1}, Table: [][3]float64{{1, 1, 1}, {0, 0, 0}}}
+		src := newGradientImage(8, 8)
+		dst, err := cg.Apply(src)
+		assertError(t, err, nil)
+		if !reflect.DeepEqual(dst.Pix, src.Pix) {
+			t.Error("got graded colors, want the original ones")
+		}
+	})
+
+	t.Run("store the table in JSON", func(t *testing.T) {
+		intensity := 0.8
+		want := ColorGrade{LUT: newIdentityLUT(2), Interpolation: Trilinear, Intensity: &intensity}
+		b, err := json.Marshal(want)
+		assertError(t, err, nil)
+		var got ColorGrade
+		err = json.Unmarshal(b, &got)
+		assertError(t, err, nil)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("keep the interpolation methods of images and lookup tables apart", func(t *testing.T) {
+		var cg ColorGrade
+		err := json.Unmarshal([]byte(`{"op":"color_grade","interpolation":"bicubic"}`), &cg)
+		assertValidationError(t, err, -1, "interpolation", ErrInvalidLUTInterpolation)
+		var r Resize
+		err = json.Unmarshal([]byte(`{"op":"resize","width":10,"interpolation":"trilinear"}`), &r)
+		assertValidationError(t, err, -1, "interpolation", ErrInvalidInterpolation)
+	})
+
+	t.Run("return error when the operation is invalid", func(t *testing.T) {
+		src := newGradientImage(2, 2)
+		_, err := ColorGrade{}.Apply(src)
+		assertValidationError(t, err, -1, "lut", ErrInvalidLUT)
+		_, err = ColorGrade{LUT: &LUT{Dimensions: 3, Size: 2}}.Apply(src)
+		assertValidationError(t, err, -1, "lut", ErrInvalidLUT)
+		_, err = ColorGrade{LUT: newIdentityLUT(2), Interpolation: "bicubic"}.Apply(src)
+		assertValidationError(t, err, -1, "interpolation", ErrInvalidLUTInterpolation)
+		intensity := 1.5
+		_, err = ColorGrade{LUT: newIdentityLUT(2), Intensity: &intensity}.Apply(src)
+		assertValidationError(t, err, -1, "intensity", ErrInvalidIntensity)
+	})
+}
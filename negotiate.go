@@ -0,0 +1,76 @@
+package gato
+
+import (
+	"strconv"
+	"strings"
+)
+
+// supportsAlpha reports whether images encoded in format keep their transparency.
+func supportsAlpha(format string) bool {
+	return format == FormatPNG
+}
+
+// acceptQuality returns the quality value (0 to 1) that the Accept header accept gives to the MIME type mime.
+// The most specific media range matching mime decides, and an empty header accepts everything.
+func acceptQuality(accept, mime string) float64 {
+	if strings.TrimSpace(accept) == "" {
+		return 1
+	}
+	typ, _, _ := strings.Cut(mime, "/")
+
+	q := 0.0
+	specificity := -1
+	for _, r := range strings.Split(accept, ",") {
+		params := strings.Split(r, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+
+		var s int
+		switch mediaRange {
+		case mime:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s < specificity {
+			continue
+		}
+
+		rq := 1.0
+		for _, p := range params[1:] {
+			key, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.ToLower(key) != "q" {
+				continue
+			}
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				rq = min(max(f, 0), 1)
+			}
+		}
+		specificity, q = s, rq
+	}
+
+	return q
+}
+
+// Negotiate returns the best output format among formats for a request whose Accept header is accept.
+// formats are ordered by preference, which breaks ties between formats of equal quality values.
+// If the image has transparency (hasAlpha), formats that keep it are picked over the ones that do not, whatever their quality values.
+// ok is false when accept rejects all the formats.
+func Negotiate(accept string, hasAlpha bool, formats []string) (format string, ok bool) {
+	best := 0.0
+	bestAlpha := false
+	for _, f := range formats {
+		q := acceptQuality(accept, Output{Format: f}.ContentType())
+		if q == 0 {
+			continue
+		}
+		alpha := hasAlpha && supportsAlpha(f)
+		if !ok || (alpha && !bestAlpha) || (alpha == bestAlpha && q > best) {
+			format, best, bestAlpha, ok = f, q, alpha, true
+		}
+	}
+	return format, ok
+}
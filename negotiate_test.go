@@ -0,0 +1,51 @@
+package gato
+
+import (
+	"testing"
+)
+
+func TestAcceptQuality(t *testing.T) {
+	cases := []struct {
+		accept string
+		mime   string
+		want   float64
+	}{
+		{"", "image/png", 1},
+		{"image/png", "image/png", 1},
+		{"image/png", "image/jpeg", 0},
+		{"image/webp,image/*;q=0.8,*/*;q=0.5", "image/jpeg", 0.8},
+		{"image/*;q=0.8, image/png;q=0.2", "image/png", 0.2},
+		{"text/html, */*;q=0.1", "image/png", 0.1},
+		{"image/png;q=0", "image/png", 0},
+	}
+	for _, c := range cases {
+		got := acceptQuality(c.accept, c.mime)
+		if got != c.want {
+			t.Errorf("acceptQuality(%q, %q) = %v, want %v", c.accept, c.mime, got, c.want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	prefs := []string{FormatJPEG, FormatPNG}
+	cases := []struct {
+		accept   string
+		hasAlpha bool
+		want     string
+		ok       bool
+	}{
+		{"image/webp,image/png", false, FormatPNG, true},
+		{"image/*", false, FormatJPEG, true},
+		{"image/*", true, FormatPNG, true},
+		{"image/jpeg,image/png;q=0.5", false, FormatJPEG, true},
+		{"image/jpeg,image/png;q=0.5", true, FormatPNG, true},
+		{"image/jpeg", true, FormatJPEG, true},
+		{"image/webp", false, "", false},
+	}
+	for _, c := range cases {
+		got, ok := Negotiate(c.accept, c.hasAlpha, prefs)
+		if got != c.want || ok != c.ok {
+			t.Errorf("Negotiate(%q, %v) = (%q, %v), want (%q, %v)", c.accept, c.hasAlpha, got, ok, c.want, c.ok)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package gato
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+)
+
+var ErrInvalidQuality = errors.New("invalid quality: quality must be between 1 and 100")
+
+// Output is a struct that contains the encoding options of a processed image.
+type Output struct {
+	// Format is the format of the encoded image, FormatJPEG or FormatPNG.
+	Format string `json:"format,omitempty"`
+	// Quality is the quality of JPEG encoding, ranging from 1 to 100. If it is not set, it defaults to jpeg.DefaultQuality.
+	Quality int `json:"quality,omitempty"`
+	// Gray encodes the luminance of the image alone, weighed following LumaRec601, which takes a third of the size of color PNGs.
+	// Gray images have no alpha, so transparent areas are composited onto black.
+	Gray bool `json:"gray,omitempty"`
+}
+
+func (o Output) validate() error {
+	switch o.Format {
+	case FormatJPEG, FormatPNG:
+		// do nothing
+	default:
+		return fieldError("format", ErrInvalidFormat)
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return fieldError("quality", ErrInvalidQuality)
+	}
+	return nil
+}
+
+// ContentType returns the MIME type of the format of o.
+func (o Output) ContentType() string {
+	return "image/" + o.Format
+}
+
+// Encode writes img to w following the encoding options.
+// If the format is not set or not supported, it returns an error ErrInvalidFormat.
+func (o Output) Encode(w io.Writer, img image.Image) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	if o.Gray {
+		if rgba, ok := img.(*image.RGBA); ok {
+			gray, err := Grayscale(rgba, LumaRec601)
+			if err != nil {
+				return err
+			}
+			img = gray
+		} else if _, ok := img.(*image.Gray); !ok {
+			// color.GrayModel weighs colors following Rec. 601 as well
+			gray := image.NewGray(img.Bounds())
+			draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+			img = gray
+		}
+	}
+
+	switch o.Format {
+	case FormatPNG:
+		return png.Encode(w, img)
+	default:
+		q := o.Quality
+		if q == 0 {
+			q = jpeg.DefaultQuality
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: q})
+	}
+}
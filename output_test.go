@@ -0,0 +1,51 @@
+package gato
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestOutput(t *testing.T) {
+	img := newGradientImage(8, 8)
+
+	t.Run("encode in the given format", func(t *testing.T) {
+		for _, f := range []string{FormatJPEG, FormatPNG} {
+			b := new(bytes.Buffer)
+			err := Output{Format: f, Quality: 90}.Encode(b, img)
+			assertError(t, err, nil)
+			_, got, _ := image.Decode(b)
+			assertString(t, got, f)
+		}
+	})
+
+	t.Run("encode gray images in grayscale", func(t *testing.T) {
+		for _, f := range []string{FormatJPEG, FormatPNG} {
+			b := new(bytes.Buffer)
+			err := Output{Format: f, Gray: true}.Encode(b, img)
+			assertError(t, err, nil)
+			got, _, err := image.Decode(b)
+			assertError(t, err, nil)
+			if _, ok := got.(*image.Gray); !ok {
+				t.Errorf("%s: got %T, want *image.Gray", f, got)
+			}
+		}
+		b := new(bytes.Buffer)
+		err := Output{Format: FormatPNG, Gray: true}.Encode(b, image.NewNRGBA(image.Rect(0, 0, 4, 4)))
+		assertError(t, err, nil)
+	})
+
+	t.Run("return the content type of the format", func(t *testing.T) {
+		assertString(t, Output{Format: FormatPNG}.ContentType(), "image/png")
+	})
+
+	t.Run("return error when the format is not supported", func(t *testing.T) {
+		err := Output{Format: "webp"}.Encode(new(bytes.Buffer), img)
+		assertError(t, err, ErrInvalidFormat)
+	})
+
+	t.Run("return error when the quality is out of range", func(t *testing.T) {
+		err := Output{Format: FormatJPEG, Quality: 101}.Encode(new(bytes.Buffer), img)
+		assertError(t, err, ErrInvalidQuality)
+	})
+}
@@ -0,0 +1,317 @@
+package gato
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"maps"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidDPR  = errors.New("invalid dpr: dpr must be greater than 0 and at most 8")
+	ErrInvalidGray = errors.New("invalid gray: gray must be a boolean, e.g. 1 or 0")
+)
+
+// Params are the image processing options of a request URL, in the style of imgproxy.
+// They are read from query strings such as ?w=300&fit=cover, or from path segments such as /w:300/fit:cover.
+type Params struct {
+	// Width and Height are read from w and h.
+	Width  int
+	Height int
+	// Fit is read from fit.
+	Fit Fit
+	// Interpolation is read from interp.
+	Interpolation Interpolation
+	// Quality is read from q.
+	Quality int
+	// Format is read from fmt. If it is not set, the format of the source image is kept.
+	Format string
+	// DPR is the device pixel ratio that Width and Height are multiplied by, read from dpr. If it is not set, it defaults to 1.
+	DPR float64
+	// Crop is the area of the source image to keep before resizing, read from crop as x,y,w,h.
+	Crop image.Rectangle
+	// Sharpen sharpens the image after it is reduced, read from sharpen as 1 or 0.
+	Sharpen bool
+	// Gray encodes the image in grayscale, read from gray as 1 or 0.
+	Gray bool
+}
+
+// params maps the key of every parameter to a function that parses its value into p.
+var params = map[string]func(p *Params, v string) error{
+	"w": func(p *Params, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return ErrInvalidDimension
+		}
+		p.Width = n
+		return nil
+	},
+	"h": func(p *Params, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return ErrInvalidDimension
+		}
+		p.Height = n
+		return nil
+	},
+	"fit": func(p *Params, v string) error {
+		if err := Fit(v).validate(); err != nil {
+			return err
+		}
+		p.Fit = Fit(v)
+		return nil
+	},
+	"interp": func(p *Params, v string) error {
+		if _, err := parseInterpolation(Interpolation(v)); err != nil {
+			return err
+		}
+		p.Interpolation = Interpolation(v)
+		return nil
+	},
+	"q": func(p *Params, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			return ErrInvalidQuality
+		}
+		p.Quality = n
+		return nil
+	},
+	"fmt": func(p *Params, v string) error {
+		if v == "jpg" {
+			v = FormatJPEG
+		}
+		if v != FormatJPEG && v != FormatPNG {
+			return ErrInvalidFormat
+		}
+		p.Format = v
+		return nil
+	},
+	"dpr": func(p *Params, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(f > 0 && f <= 8) {
+			return ErrInvalidDPR
+		}
+		p.DPR = f
+		return nil
+	},
+	"crop": func(p *Params, v string) error {
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 {
+			return ErrInvalidCrop
+		}
+		var n [4]int
+		for i, part := range parts {
+			var err error
+			if n[i], err = strconv.Atoi(part); err != nil {
+				return ErrInvalidCrop
+			}
+		}
+		if n[0] < 0 || n[1] < 0 || n[2] <= 0 || n[3] <= 0 {
+			return ErrInvalidCrop
+		}
+		p.Crop = image.Rect(n[0], n[1], n[0]+n[2], n[1]+n[3])
+		return nil
+	},
+	"gray": func(p *Params, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return ErrInvalidGray
+		}
+		p.Gray = b
+		return nil
+	},
+	"sharpen": func(p *Params, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return ErrInvalidSharpen
+		}
+		p.Sharpen = b
+		return nil
+	},
+}
+
+// ParseQuery returns the Params read from the query string q.
+// Keys that are not parameters are ignored. Errors are returned as a *ValidationError whose Field is the invalid key.
+func ParseQuery(q url.Values) (Params, error) {
+	var p Params
+	if err := p.parse(q); err != nil {
+		return Params{}, err
+	}
+	return p, nil
+}
+
+// parse sets the parameters of q on p, keeping the other ones as they are.
+func (p *Params) parse(q url.Values) error {
+	for _, key := range slices.Sorted(maps.Keys(params)) {
+		if !q.Has(key) {
+			continue
+		}
+		if err := params[key](p, q.Get(key)); err != nil {
+			return fieldError(key, err)
+		}
+	}
+	return nil
+}
+
+// ParsePath returns the Params read from the leading key:value segments of path, e.g. /w:300/fit:cover/photos/dog.jpg.
+// The rest of the path after the parameters, e.g. photos/dog.jpg, is returned as rest.
+func ParsePath(path string) (p Params, rest string, err error) {
+	q, rest := pathValues(path)
+	p, err = ParseQuery(q)
+	if err != nil {
+		return Params{}, "", err
+	}
+	return p, rest, nil
+}
+
+// pathValues splits path into the values of its leading key:value segments and the rest of the path.
+func pathValues(path string) (q url.Values, rest string) {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	q = url.Values{}
+	n := 0
+	for _, seg := range segments {
+		key, v, ok := strings.Cut(seg, ":")
+		if _, known := params[key]; !ok || !known {
+			break
+		}
+		q.Set(key, v)
+		n++
+	}
+	return q, strings.Join(segments[n:], "/")
+}
+
+// Canonical returns the equivalent Params in a single canonical form, so that equivalent requests share a cache key.
+// The DPR is folded into the dimensions, and the default fit and interpolation are omitted.
+func (p Params) Canonical() Params {
+	if p.DPR != 0 && p.DPR != 1 {
+		p.Width = int(math.Round(float64(p.Width) * p.DPR))
+		p.Height = int(math.Round(float64(p.Height) * p.DPR))
+	}
+	p.DPR = 0
+	if p.Fit == FitFill {
+		p.Fit = ""
+	}
+	if p.Interpolation == Bilinear {
+		p.Interpolation = ""
+	}
+	return p
+}
+
+// values returns the parameters that are set, keyed by their keys.
+func (p Params) values() url.Values {
+	q := url.Values{}
+	if p.Width != 0 {
+		q.Set("w", strconv.Itoa(p.Width))
+	}
+	if p.Height != 0 {
+		q.Set("h", strconv.Itoa(p.Height))
+	}
+	if p.Fit != "" {
+		q.Set("fit", string(p.Fit))
+	}
+	if p.Interpolation != "" {
+		q.Set("interp", string(p.Interpolation))
+	}
+	if p.Quality != 0 {
+		q.Set("q", strconv.Itoa(p.Quality))
+	}
+	if p.Format != "" {
+		q.Set("fmt", p.Format)
+	}
+	if p.DPR != 0 {
+		q.Set("dpr", strconv.FormatFloat(p.DPR, 'f', -1, 64))
+	}
+	if !p.Crop.Empty() {
+		q.Set("crop", fmt.Sprintf("%d,%d,%d,%d", p.Crop.Min.X, p.Crop.Min.Y, p.Crop.Dx(), p.Crop.Dy()))
+	}
+	if p.Sharpen {
+		q.Set("sharpen", "1")
+	}
+	if p.Gray {
+		q.Set("gray", "1")
+	}
+	return q
+}
+
+// Encode returns the query string of p with its keys sorted, e.g. fit=cover&w=300.
+// ParseQuery reads it back to p, and p.Canonical().Encode() is suitable as a cache key.
+func (p Params) Encode() string {
+	return p.values().Encode()
+}
+
+// Path returns the path segments of p with their keys sorted, e.g. fit:cover/w:300.
+// ParsePath reads it back to p.
+func (p Params) Path() string {
+	q := p.values()
+	var segments []string
+	for _, key := range slices.Sorted(maps.Keys(q)) {
+		// values never contain a slash, so they are kept unescaped
+		segments = append(segments, key+":"+q.Get(key))
+	}
+	return strings.Join(segments, "/")
+}
+
+// Instruction returns the Instruction that p describes.
+// ok is false when p neither resizes nor crops, in which case the source image is kept as is.
+func (p Params) Instruction() (i Instruction, ok bool) {
+	c := p.Canonical()
+	resize := c.Width != 0 || c.Height != 0
+	if c.Crop.Empty() {
+		return Instruction{
+			Width:         c.Width,
+			Height:        c.Height,
+			Interpolation: c.Interpolation,
+			Fit:           c.Fit,
+			Sharpen:       c.Sharpen,
+		}, resize
+	}
+
+	steps := []Operation{Crop{X: c.Crop.Min.X, Y: c.Crop.Min.Y, Width: c.Crop.Dx(), Height: c.Crop.Dy()}}
+	if resize {
+		steps = append(steps, Resize{
+			Width:         c.Width,
+			Height:        c.Height,
+			Interpolation: c.Interpolation,
+			Fit:           c.Fit,
+			Sharpen:       c.Sharpen,
+		})
+	}
+	return Instruction{Pipeline: steps}, true
+}
+
+// area returns the number of pixels of the largest image that processing a source image of size w x h following p allocates,
+// i.e. the resized image, or the scaled image before it is cropped with FitCover.
+func (p Params) area(w, h int) float64 {
+	c := p.Canonical()
+	if !c.Crop.Empty() {
+		w, h = c.Crop.Dx(), c.Crop.Dy()
+	}
+	fw, fh := float64(w), float64(h)
+	switch {
+	case c.Width == 0 && c.Height == 0:
+		return fw * fh
+	case c.Width == 0:
+		return float64(c.Height) / fh * fw * float64(c.Height)
+	case c.Height == 0:
+		return float64(c.Width) / fw * fh * float64(c.Width)
+	case c.Fit == FitCover:
+		scale := max(float64(c.Width)/fw, float64(c.Height)/fh)
+		return scale * fw * scale * fh
+	}
+	return float64(c.Width) * float64(c.Height)
+}
+
+// Output returns the encoding options that p describes.
+// If p.Format is not set, the format of the source image is used.
+func (p Params) Output(source string) Output {
+	f := p.Format
+	if f == "" {
+		f = source
+	}
+	return Output{Format: f, Quality: p.Quality, Gray: p.Gray}
+}
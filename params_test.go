@@ -0,0 +1,134 @@
+package gato
+
+import (
+	"image"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	t.Run("read every parameter", func(t *testing.T) {
+		q, _ := url.ParseQuery("w=300&h=200&fit=cover&interp=bicubic&q=80&fmt=jpg&dpr=1.5&crop=10,20,100,50&sharpen=true&gray=1&other=1")
+		got, err := ParseQuery(q)
+		assertError(t, err, nil)
+		want := Params{
+			Width:         300,
+			Height:        200,
+			Fit:           FitCover,
+			Interpolation: Bicubic,
+			Quality:       80,
+			Format:        FormatJPEG,
+			DPR:           1.5,
+			Crop:          image.Rect(10, 20, 110, 70),
+			Sharpen:       true,
+			Gray:          true,
+		}
+		if got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("return error pointing to the invalid key", func(t *testing.T) {
+		cases := []struct {
+			query string
+			key   string
+			want  error
+		}{
+			{"w=-1", "w", ErrInvalidDimension},
+			{"h=abc", "h", ErrInvalidDimension},
+			{"fit=squash", "fit", ErrInvalidFit},
+			{"interp=full-crimp", "interp", ErrInvalidInterpolation},
+			{"q=0", "q", ErrInvalidQuality},
+			{"fmt=webp", "fmt", ErrInvalidFormat},
+			{"dpr=9", "dpr", ErrInvalidDPR},
+			{"crop=1,2,3", "crop", ErrInvalidCrop},
+			{"sharpen=much", "sharpen", ErrInvalidSharpen},
+			{"gray=maybe", "gray", ErrInvalidGray},
+		}
+		for _, c := range cases {
+			q, _ := url.ParseQuery(c.query)
+			_, err := ParseQuery(q)
+			assertValidationError(t, err, -1, c.key, c.want)
+		}
+	})
+}
+
+func TestParsePath(t *testing.T) {
+	p, rest, err := ParsePath("/w:300/fit:contain/photos/dog.jpg")
+	assertError(t, err, nil)
+	assertString(t, rest, "photos/dog.jpg")
+	if want := (Params{Width: 300, Fit: FitContain}); p != want {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+}
+
+func TestParamsSerialization(t *testing.T) {
+	p := Params{Width: 300, Fit: FitCover, Quality: 80, DPR: 2, Crop: image.Rect(1, 2, 11, 22), Sharpen: true}
+
+	t.Run("encode a sorted query string that reads back", func(t *testing.T) {
+		s := p.Encode()
+		assertString(t, s, "crop=1%2C2%2C10%2C20&dpr=2&fit=cover&q=80&sharpen=1&w=300")
+		q, _ := url.ParseQuery(s)
+		got, _ := ParseQuery(q)
+		if got != p {
+			t.Errorf("got %+v, want %+v", got, p)
+		}
+	})
+
+	t.Run("encode sorted path segments that read back", func(t *testing.T) {
+		s := p.Path()
+		assertString(t, s, "crop:1,2,10,20/dpr:2/fit:cover/q:80/sharpen:1/w:300")
+		got, rest, _ := ParsePath(s)
+		assertString(t, rest, "")
+		if got != p {
+			t.Errorf("got %+v, want %+v", got, p)
+		}
+	})
+
+	t.Run("share the canonical form between equivalent params", func(t *testing.T) {
+		a := Params{Width: 300, DPR: 2, Fit: FitFill, Interpolation: Bilinear}
+		b := Params{Width: 600}
+		assertString(t, a.Canonical().Encode(), b.Canonical().Encode())
+	})
+}
+
+func TestParamsInstruction(t *testing.T) {
+	t.Run("describe a single resize", func(t *testing.T) {
+		i, ok := Params{Width: 150, DPR: 2, Fit: FitContain}.Instruction()
+		if !ok {
+			t.Fatal("got ok false, want true")
+		}
+		if want := (Instruction{Width: 300, Fit: FitContain}); !reflect.DeepEqual(i, want) {
+			t.Errorf("got %+v, want %+v", i, want)
+		}
+		_, err := NewProcessor(i)
+		assertError(t, err, nil)
+	})
+
+	t.Run("crop before resizing", func(t *testing.T) {
+		i, _ := Params{Width: 100, Crop: image.Rect(10, 10, 60, 60)}.Instruction()
+		want := Instruction{Pipeline: []Operation{Crop{X: 10, Y: 10, Width: 50, Height: 50}, Resize{Width: 100}}}
+		if !reflect.DeepEqual(i, want) {
+			t.Errorf("got %+v, want %+v", i, want)
+		}
+	})
+
+	t.Run("keep the image when there is nothing to process", func(t *testing.T) {
+		_, ok := Params{Format: FormatPNG}.Instruction()
+		if ok {
+			t.Error("got ok true, want false")
+		}
+	})
+
+	t.Run("use the source format for the output by default", func(t *testing.T) {
+		assertString(t, Params{}.Output(FormatPNG).Format, FormatPNG)
+		assertString(t, Params{Format: FormatJPEG}.Output(FormatPNG).Format, FormatJPEG)
+	})
+
+	t.Run("encode in grayscale", func(t *testing.T) {
+		if want := (Output{Format: FormatPNG, Gray: true}); (Params{Gray: true}).Output(FormatPNG) != want {
+			t.Errorf("got %+v, want %+v", Params{Gray: true}.Output(FormatPNG), want)
+		}
+	})
+}
@@ -0,0 +1,224 @@
+package gato
+
+import (
+	"context"
+	"image"
+	"math/bits"
+	"sync"
+)
+
+// Operation is a single step of the processing pipeline described by Instruction.Pipeline.
+// Resize, Rotate, Warp, Crop, Pad, Trim, Convolve, Blur, BoxBlur, Sharpen, BrightnessContrast, Gamma, Levels, Curves,
+// Recolor, ColorGrade, Equalize, CLAHE, WhiteBalance, AutoLevels and AutoContrast are operations.
+type Operation interface {
+	// kind returns the name of the operation in JSON, e.g. "resize"
+	kind() string
+	// validate reports whether the operation is well-formed, independently of the image it runs on
+	validate() error
+	// apply runs the operation on src and returns its result in a buffer taken from s
+	// it never returns src itself, so that src can be recycled once the next step is done with it
+	apply(s *scratch, src *image.RGBA) (*image.RGBA, error)
+}
+
+// resizer is implemented by the operations that change the size of images.
+// outputSize returns the size of the output of the operation for an image of the given size, without processing it.
+// ok is false when it cannot be told up front, e.g. when it depends on the content of the image.
+type resizer interface {
+	outputSize(size image.Point) (out image.Point, ok bool)
+}
+
+// outputSize returns the size of the output of ops for an image of the given size, as far as it can be told up front.
+// The operations that do not implement resizer keep the size of their input.
+func outputSize(ops []Operation, size image.Point) (image.Point, bool) {
+	for _, op := range ops {
+		r, ok := op.(resizer)
+		if !ok {
+			continue
+		}
+		if size, ok = r.outputSize(size); !ok {
+			return image.Point{}, false
+		}
+	}
+	return size, true
+}
+
+// scratch hands out the buffers of intermediate images to the steps of a pipeline, and takes them back once they are consumed.
+// It also carries the context of the pipeline, which the steps check between rows, and the Pool running their rows,
+// as well as the step being run, and the function that the corrections of automatic operations are reported to, if any.
+// rows is the buffer of the rows that separable filters hold between their passes.
+type scratch struct {
+	ctx    context.Context
+	pool   *Pool
+	free   []*image.RGBA
+	rows   *[]float32
+	step   int
+	report func(Correction)
+}
+
+// context returns the context of the pipeline, which defaults to context.Background().
+func (s *scratch) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// parallel calls fn on the rows [0, rows) with the workers of the pool of s, which defaults to DefaultPool().
+// Once the context of s is done, no more rows are started and its error is returned.
+func (s *scratch) parallel(rows int, fn func(start, end int)) error {
+	pool := s.pool
+	if pool == nil {
+		pool = DefaultPool()
+	}
+	return pool.run(s.context(), rows, fn)
+}
+
+// workers returns the number of workers that run the rows of parallel, i.e. the most rows run at the same time.
+func (s *scratch) workers() int {
+	if s.pool == nil {
+		return DefaultPool().workers
+	}
+	return s.pool.workers
+}
+
+// get returns a transparent image of size w x h, reusing a free buffer when one is large enough.
+func (s *scratch) get(w, h int) *image.RGBA {
+	n := 4 * w * h
+	for i, img := range s.free {
+		if cap(img.Pix) < n {
+			continue
+		}
+		s.free = append(s.free[:i], s.free[i+1:]...)
+		resetBuffer(img, w, h)
+		return img
+	}
+	return getBuffer(w, h)
+}
+
+// floats returns a buffer of n float32, reusing the one of the previous call when it is large enough.
+// Its values are left as they were, and it is only valid until the next call, as the steps run one after the other.
+func (s *scratch) floats(n int) []float32 {
+	if s.rows == nil || cap(*s.rows) < n {
+		s.rows = getFloats(&rowBuffers, n)
+	}
+	return (*s.rows)[:n]
+}
+
+// line returns a buffer of n float32 for the line that a worker is filtering, which must be given back with putLine.
+// Its values are left as they were.
+func (s *scratch) line(n int) *[]float32 {
+	return getFloats(&lineBuffers, n)
+}
+
+// putLine gives the buffer of a line back for the next lines.
+func (s *scratch) putLine(b *[]float32) {
+	lineBuffers.Put(b)
+}
+
+// put gives the buffer of img back to s.
+func (s *scratch) put(img *image.RGBA) {
+	s.free = append(s.free, img)
+}
+
+// corrected reports the correction op, which the step being run measured and applied, to the report function of s, if any.
+func (s *scratch) corrected(op Operation) {
+	if s.report != nil {
+		s.report(Correction{Step: s.step, Operation: op})
+	}
+}
+
+// release gives the free buffers of s back to the shared buffer pools.
+func (s *scratch) release() {
+	for _, img := range s.free {
+		putBuffer(img)
+	}
+	s.free = nil
+	if s.rows != nil {
+		rowBuffers.Put(s.rows)
+		s.rows = nil
+	}
+}
+
+// rowBuffers and lineBuffers are the pools of the float buffers of separable filters shared by all pipelines,
+// for the rows they hold between their passes and for the lines their workers filter.
+var rowBuffers, lineBuffers sync.Pool
+
+// getFloats returns a buffer of n float32 from pool, or a new one when the pooled one is too small.
+// Its values are left as they were.
+func getFloats(pool *sync.Pool, n int) *[]float32 {
+	if b, ok := pool.Get().(*[]float32); ok && cap(*b) >= n {
+		*b = (*b)[:n]
+		return b
+	}
+	b := make([]float32, n)
+	return &b
+}
+
+// buffers are the pools of image buffers shared by all pipelines, by size class.
+// The buffers of class i have a capacity of 1<<i bytes, so that any image of a similar size can reuse them.
+var buffers [bits.UintSize]sync.Pool
+
+// getBuffer returns a transparent image of size w x h, reusing a pooled buffer when there is one.
+func getBuffer(w, h int) *image.RGBA {
+	n := 4 * w * h
+	if n == 0 {
+		return image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+	class := bits.Len(uint(n - 1))
+	if img, ok := buffers[class].Get().(*image.RGBA); ok {
+		resetBuffer(img, w, h)
+		return img
+	}
+	return &image.RGBA{
+		Pix:    make([]uint8, n, 1<<class),
+		Stride: 4 * w,
+		Rect:   image.Rect(0, 0, w, h),
+	}
+}
+
+// putBuffer gives the buffer of img back to the pool for later calls of getBuffer. img must not be used afterwards.
+func putBuffer(img *image.RGBA) {
+	c := cap(img.Pix)
+	if c == 0 {
+		return
+	}
+	// the class whose buffers img is large enough for
+	class := bits.Len(uint(c)) - 1
+	buffers[class].Put(img)
+}
+
+// resetBuffer resizes img to a transparent image of size w x h, within the capacity of its buffer.
+func resetBuffer(img *image.RGBA, w, h int) {
+	img.Pix = img.Pix[:4*w*h]
+	clear(img.Pix)
+	img.Stride = 4 * w
+	img.Rect = image.Rect(0, 0, w, h)
+}
+
+// run applies the operations to src in order.
+// Intermediate images are recycled through the shared buffer pool, and src itself is left untouched.
+// Rows are run with the workers of pool, or DefaultPool() if it is nil. Once ctx is done, it stops and returns ctx.Err().
+func run(ctx context.Context, pool *Pool, ops []Operation, src *image.RGBA) (*image.RGBA, error) {
+	return runScratch(&scratch{ctx: ctx, pool: pool}, ops, src)
+}
+
+// runScratch is like run, but it takes the buffers, the context and the pool from s, which it releases once done.
+func runScratch(s *scratch, ops []Operation, src *image.RGBA) (*image.RGBA, error) {
+	defer s.release()
+	img := src
+	for step, op := range ops {
+		if err := s.context().Err(); err != nil {
+			return nil, err
+		}
+		s.step = step
+		out, err := op.apply(s, img)
+		if err != nil {
+			return nil, err
+		}
+		if img != src {
+			s.put(img)
+		}
+		img = out
+	}
+	return img, nil
+}
@@ -0,0 +1,77 @@
+package gato
+
+import (
+	"context"
+	"image"
+	"testing"
+)
+
+func TestScratch(t *testing.T) {
+	t.Run("reuse a free buffer that is large enough", func(t *testing.T) {
+		s := &scratch{}
+		a := s.get(4, 4)
+		a.Pix[0] = 255
+		s.put(a)
+		b := s.get(2, 3)
+		if &a.Pix[0] != &b.Pix[0] {
+			t.Errorf("got a new buffer, want the free one")
+		}
+		if b.Bounds() != image.Rect(0, 0, 2, 3) || b.Stride != 8 {
+			t.Errorf("got bounds %v and stride %d, want %v and 8", b.Bounds(), b.Stride, image.Rect(0, 0, 2, 3))
+		}
+		assertInt(t, int(b.Pix[0]), 0)
+	})
+
+	t.Run("allocate when no free buffer is large enough", func(t *testing.T) {
+		s := &scratch{}
+		a := s.get(2, 2)
+		s.put(a)
+		b := s.get(4, 4)
+		if &a.Pix[0] == &b.Pix[0] {
+			t.Errorf("got the free buffer, want a new one")
+		}
+		assertInt(t, len(s.free), 1)
+	})
+}
+
+func TestBuffers(t *testing.T) {
+	t.Run("return a transparent image of the requested size", func(t *testing.T) {
+		a := getBuffer(5, 3)
+		for i := range a.Pix {
+			a.Pix[i] = 255
+		}
+		putBuffer(a)
+
+		// the pool may or may not hand a back, so only the contract is checked
+		b := getBuffer(4, 3)
+		if b.Bounds() != image.Rect(0, 0, 4, 3) || b.Stride != 16 || len(b.Pix) != 48 {
+			t.Errorf("got bounds %v, stride %d and %d bytes, want %v, 16 and 48", b.Bounds(), b.Stride, len(b.Pix), image.Rect(0, 0, 4, 3))
+		}
+		for _, v := range b.Pix {
+			if v != 0 {
+				t.Fatal("got a dirty buffer")
+			}
+		}
+	})
+
+	t.Run("round the capacity up to the size class", func(t *testing.T) {
+		assertInt(t, cap(getBuffer(5, 5).Pix), 128)
+		assertInt(t, cap(getBuffer(4, 4).Pix), 64)
+	})
+}
+
+func TestRun(t *testing.T) {
+	src := newGradientImage(10, 10)
+	ops := []Operation{
+		Pad{Top: 2, Left: 2},
+		Resize{Width: 6},
+		Rotate{Angle: 90},
+	}
+	dst, err := run(context.Background(), nil, ops, src)
+	assertError(t, err, nil)
+	assertInt(t, dst.Bounds().Dx(), 6)
+	assertInt(t, dst.Bounds().Dy(), 6)
+	if got, want := src.RGBAAt(0, 0), newGradientImage(10, 10).RGBAAt(0, 0); got != want {
+		t.Errorf("source image was modified")
+	}
+}
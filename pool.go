@@ -0,0 +1,188 @@
+package gato
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Pool is a fixed set of workers that runs the rows of images being processed, shared by any number of Processors.
+// It bounds the total parallelism of a program, however many images are processed at the same time.
+//
+// Every call splits its rows into chunks, and the workers take the chunks of the waiting calls in turn,
+// so that a large image does not hold back the small ones queued after it.
+type Pool struct {
+	workers int
+	started time.Time
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	jobs      []*job
+	next      int
+	closed    bool
+	busy      int
+	queued    int
+	completed uint64
+	busyTime  time.Duration
+}
+
+// PoolStats are statistics of a Pool at some point in time.
+type PoolStats struct {
+	// Workers is the number of workers.
+	Workers int
+	// Busy is the number of workers running a chunk.
+	Busy int
+	// Jobs is the number of calls that still have chunks waiting for a worker.
+	Jobs int
+	// Queued is the number of chunks waiting for a worker.
+	Queued int
+	// Completed is the number of chunks run since the Pool was created.
+	Completed uint64
+	// Utilization is the ratio of the time the workers were busy since the Pool was created, from 0 to 1.
+	Utilization float64
+}
+
+// job is a call of run whose chunks are not all handed out yet.
+type job struct {
+	ctx     context.Context
+	fn      func(start, end int)
+	rows    int
+	chunk   int
+	next    int
+	pending int
+	wg      sync.WaitGroup
+}
+
+// NewPool creates a new Pool instance with the given number of workers.
+// If workers is not greater than 0, it defaults to runtime.NumCPU().
+func NewPool(workers int) *Pool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	p := &Pool{workers: workers, started: time.Now()}
+	p.cond = sync.NewCond(&p.mu)
+	for range workers {
+		go p.work()
+	}
+	return p
+}
+
+// defaultPool is created on first use, so that importing the package starts no goroutine.
+var defaultPool = sync.OnceValue(func() *Pool {
+	return NewPool(0)
+})
+
+// DefaultPool returns the Pool used by Processors without a Pool of their own, with one worker per CPU.
+func DefaultPool() *Pool {
+	return defaultPool()
+}
+
+// Close stops the workers once the queued chunks are done. Calls made after Close run on the calling goroutine.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// Stats returns the current statistics of p.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	elapsed := time.Since(p.started) * time.Duration(p.workers)
+	var u float64
+	if elapsed > 0 {
+		u = min(float64(p.busyTime)/float64(elapsed), 1)
+	}
+	return PoolStats{
+		Workers:     p.workers,
+		Busy:        p.busy,
+		Jobs:        len(p.jobs),
+		Queued:      p.queued,
+		Completed:   p.completed,
+		Utilization: u,
+	}
+}
+
+// run splits the rows [0, rows) into chunks, calls fn on every chunk with the workers of p, and waits for them to be done.
+// Once ctx is done, no more rows are started and ctx.Err() is returned.
+func (p *Pool) run(ctx context.Context, rows int, fn func(start, end int)) error {
+	if rows <= 0 {
+		return ctx.Err()
+	}
+
+	// a few chunks per worker, so that the workers are shared between calls often enough
+	chunk := max(1, (rows+4*p.workers-1)/(4*p.workers))
+	j := &job{ctx: ctx, fn: fn, rows: rows, chunk: chunk}
+	j.pending = (rows + chunk - 1) / chunk
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		j.runRows(0, rows)
+		return ctx.Err()
+	}
+	j.wg.Add(j.pending)
+	p.jobs = append(p.jobs, j)
+	p.queued += j.pending
+	p.mu.Unlock()
+	p.cond.Broadcast()
+
+	j.wg.Wait()
+
+	return ctx.Err()
+}
+
+// work runs the chunks of the jobs in turn until p is closed.
+func (p *Pool) work() {
+	p.mu.Lock()
+	for {
+		for len(p.jobs) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if len(p.jobs) == 0 {
+			p.mu.Unlock()
+			return
+		}
+
+		// take the next chunk of the next job in turn
+		p.next %= len(p.jobs)
+		j := p.jobs[p.next]
+		start := j.next
+		end := min(start+j.chunk, j.rows)
+		j.next = end
+		if j.next == j.rows {
+			// the job following the removed one takes its turn
+			p.jobs = append(p.jobs[:p.next], p.jobs[p.next+1:]...)
+		} else {
+			p.next++
+		}
+		p.queued--
+		p.busy++
+		p.mu.Unlock()
+
+		t := time.Now()
+		j.runRows(start, end)
+		d := time.Since(t)
+		j.wg.Done()
+
+		p.mu.Lock()
+		p.busy--
+		p.completed++
+		p.busyTime += d
+	}
+}
+
+// runRows calls the function of j one row at a time, until ctx is done.
+func (j *job) runRows(start, end int) {
+	done := j.ctx.Done()
+	for y := start; y < end; y++ {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		j.fn(y, y+1)
+	}
+}
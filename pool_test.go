@@ -0,0 +1,137 @@
+package gato
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	t.Run("call fn on every row once", func(t *testing.T) {
+		p := NewPool(3)
+		defer p.Close()
+		rows := make([]int32, 100)
+		err := p.run(context.Background(), len(rows), func(start, end int) {
+			for y := start; y < end; y++ {
+				atomic.AddInt32(&rows[y], 1)
+			}
+		})
+		assertError(t, err, nil)
+		for y, n := range rows {
+			if n != 1 {
+				t.Fatalf("row %d: got %d calls, want 1", y, n)
+			}
+		}
+	})
+
+	t.Run("stop starting rows once the context is done", func(t *testing.T) {
+		p := NewPool(2)
+		defer p.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var n atomic.Int32
+		err := p.run(ctx, 1000, func(start, end int) {
+			n.Add(1)
+			cancel()
+		})
+		assertError(t, err, context.Canceled)
+		if n.Load() >= 1000 {
+			t.Errorf("got %d rows, want fewer than 1000", n.Load())
+		}
+	})
+
+	t.Run("bound the parallelism of concurrent calls", func(t *testing.T) {
+		p := NewPool(2)
+		defer p.Close()
+		var running, peak atomic.Int32
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = p.run(context.Background(), 20, func(start, end int) {
+					n := running.Add(1)
+					for {
+						m := peak.Load()
+						if n <= m || peak.CompareAndSwap(m, n) {
+							break
+						}
+					}
+					running.Add(-1)
+				})
+			}()
+		}
+		wg.Wait()
+		if peak.Load() > 2 {
+			t.Errorf("got %d rows running at once, want at most 2", peak.Load())
+		}
+	})
+
+	t.Run("take the chunks of waiting calls in turn", func(t *testing.T) {
+		p := NewPool(1)
+		defer p.Close()
+
+		// hold the only worker, so that both calls are queued before any of their chunks run
+		release := make(chan struct{})
+		started := make(chan struct{})
+		go func() {
+			_ = p.run(context.Background(), 1, func(start, end int) {
+				close(started)
+				<-release
+			})
+		}()
+		<-started
+
+		var mu sync.Mutex
+		var order []string
+		record := func(name string) func(start, end int) {
+			return func(start, end int) {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+			}
+		}
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = p.run(context.Background(), 4, record("a"))
+		}()
+		// wait for a to be queued
+		for p.Stats().Jobs != 1 {
+			runtime.Gosched()
+		}
+		go func() {
+			defer wg.Done()
+			_ = p.run(context.Background(), 4, record("b"))
+		}()
+		// wait for b to be queued
+		for p.Stats().Jobs != 2 {
+			runtime.Gosched()
+		}
+
+		stats := p.Stats()
+		assertInt(t, stats.Workers, 1)
+		assertInt(t, stats.Busy, 1)
+		assertInt(t, stats.Queued, 8)
+
+		close(release)
+		wg.Wait()
+		assertString(t, fmt.Sprint(order), "[a b a b a b a b]")
+		assertInt(t, int(p.Stats().Completed), 9)
+	})
+
+	t.Run("run on the calling goroutine once closed", func(t *testing.T) {
+		p := NewPool(1)
+		p.Close()
+		var n int
+		err := p.run(context.Background(), 10, func(start, end int) {
+			n += end - start
+		})
+		assertError(t, err, nil)
+		assertInt(t, n, 10)
+	})
+}
@@ -1,85 +1,272 @@
 package gato
 
 import (
+	"context"
 	"errors"
 	"image"
-	"math"
+	"image/color"
 )
 
+// Interpolation is the method used to sample an image when it is resampled.
+type Interpolation string
+
+const (
+	NearestNeighbor Interpolation = "nearest-neighbor"
+	Bilinear        Interpolation = "bilinear"
+	Bicubic         Interpolation = "bicubic"
+)
+
+// Fit is the way an image fits into a box of given dimensions.
+type Fit string
+
 const (
-	NearestNeighbor = "nearest-neighbor"
-	Bilinear        = "bilinear"
-	Bicubic         = "bicubic"
+	FitFill    Fit = "fill"
+	FitContain Fit = "contain"
+	FitCover   Fit = "cover"
 )
 
+func (m Interpolation) MarshalText() ([]byte, error) {
+	if _, err := parseInterpolation(m); err != nil {
+		return nil, err
+	}
+	return []byte(m), nil
+}
+
+func (m *Interpolation) UnmarshalText(text []byte) error {
+	if _, err := parseInterpolation(Interpolation(text)); err != nil {
+		return fieldError("interpolation", err)
+	}
+	*m = Interpolation(text)
+	return nil
+}
+
+// validate reports whether f is a known fit. The empty fit is valid and means FitFill.
+func (f Fit) validate() error {
+	switch f {
+	case "", FitFill, FitContain, FitCover:
+		return nil
+	}
+	return ErrInvalidFit
+}
+
+func (f Fit) MarshalText() ([]byte, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	return []byte(f), nil
+}
+
+func (f *Fit) UnmarshalText(text []byte) error {
+	if err := Fit(text).validate(); err != nil {
+		return fieldError("fit", err)
+	}
+	*f = Fit(text)
+	return nil
+}
+
 var (
 	ErrInvalidDimension     = errors.New("invalid dimension: one of the dimension is not set or set to 0")
 	ErrInvalidInterpolation = errors.New("invalid interpolation method: only nearest-neighbor, bilinear, and bicubic are available")
+	ErrInvalidFit           = errors.New("invalid fit: only fill, contain, and cover are available")
+	ErrConflictingPipeline  = errors.New("conflicting instruction: resize fields cannot be combined with a pipeline")
+	ErrInvalidDestination   = errors.New("invalid destination: its size must be the size of the processed image")
 )
 
 // Instruction is a struct that contains the instruction for the processor.
+// It either describes a single resize with its own fields, or an ordered pipeline of operations with Pipeline.
+// It marshals to and from JSON following the schema version SchemaVersion.
 type Instruction struct {
-	Width         int
-	Height        int
-	Interpolation string
+	Width         int           `json:"width,omitempty"`
+	Height        int           `json:"height,omitempty"`
+	Interpolation Interpolation `json:"interpolation,omitempty"`
+	// Fit decides how the image fits into Width x Height when both of them are set. If it is not set, it defaults to FitFill.
+	//   - FitFill stretches the image to the exact dimensions
+	//   - FitContain scales the image to fit inside the dimensions and letterboxes the rest with Background
+	//   - FitCover scales the image to cover the dimensions and crops the overflow
+	Fit Fit `json:"fit,omitempty"`
+	// Gravity places the image when it is letterboxed or cropped by Fit. If it is not set, it defaults to GravityCenter.
+	Gravity Gravity `json:"gravity,omitempty"`
+	// Background is the color of the letterbox with FitContain. The zero value is transparent.
+	Background color.RGBA `json:"-"`
+	// Sharpen applies a light unsharp mask after reductions, whose strength follows the scale factor and the interpolation method.
+	// Images that are enlarged or barely reduced are left as they are.
+	Sharpen bool `json:"sharpen,omitempty"`
+	// Pipeline is the ordered list of operations to run, e.g. Trim, Rotate, Resize and Pad.
+	// It cannot be combined with the resize fields above.
+	Pipeline []Operation `json:"-"`
+}
+
+// canonical returns the Instruction with the fields set to their defaults cleared, as Params.Canonical does,
+// so that Instructions processing images the same way are equal. The resize steps of the pipeline are cleared the same way.
+func (i Instruction) canonical() Instruction {
+	i.Interpolation, i.Fit, i.Gravity = canonicalResize(i.Interpolation, i.Fit, i.Gravity)
+	if len(i.Pipeline) > 0 {
+		ops := make([]Operation, len(i.Pipeline))
+		for step, op := range i.Pipeline {
+			if r, ok := op.(Resize); ok {
+				r.Interpolation, r.Fit, r.Gravity = canonicalResize(r.Interpolation, r.Fit, r.Gravity)
+				op = r
+			}
+			ops[step] = op
+		}
+		i.Pipeline = ops
+	}
+	return i
+}
+
+// canonicalResize clears the resize fields that are set to their defaults.
+func canonicalResize(m Interpolation, f Fit, g Gravity) (Interpolation, Fit, Gravity) {
+	if m == Bilinear {
+		m = ""
+	}
+	if f == FitFill {
+		f = ""
+	}
+	if g == GravityCenter {
+		g = ""
+	}
+	return m, f, g
 }
 
 // Processor is a struct that contains the instruction and related helpers
+// It is usually created with NewProcessor. A Processor built as a struct literal validates its Instruction every time it processes an image.
 type Processor struct {
 	Instruction
+	// Interpolator is the interpolator of the single resize. It is nil when the Instruction describes a pipeline.
 	Interpolator interpolator
+	// Pool runs the rows of the processed images. If it is not set, DefaultPool() is used, which is shared by all Processors.
+	Pool  *Pool
+	steps []Operation
+}
+
+// operations returns the steps to run. A Processor built as a struct literal has none yet,
+// so they are built from its Instruction, validated as NewProcessor does.
+func (p *Processor) operations() ([]Operation, error) {
+	if p.steps != nil {
+		return p.steps, nil
+	}
+	np, err := NewProcessor(p.Instruction)
+	if err != nil {
+		return nil, err
+	}
+	return np.steps, nil
 }
 
 // return the processed image following the instructions
 func (p *Processor) Process(d *Data) (*image.RGBA, error) {
-	// setting dimensions
-	w := p.Width
-	h := p.Height
-	if w == 0 {
-		srcW := d.Image.Bounds().Dx()
-		srcH := d.Image.Bounds().Dy()
-		scale := float64(h) / float64(srcH)
-		w = int(math.Round(scale * float64(srcW)))
-	}
-	if h == 0 {
-		srcW := d.Image.Bounds().Dx()
-		srcH := d.Image.Bounds().Dy()
-		scale := float64(w) / float64(srcW)
-		h = int(math.Round(scale * float64(srcH)))
-	}
-
-	rect := image.Rect(0, 0, w, h)
-	rgba := image.NewRGBA(rect)
-
-	err := p.Interpolator.interpolate(d.Image, rgba)
+	return p.ProcessContext(context.Background(), d)
+}
+
+// ProcessContext is like Process, but it stops as soon as ctx is done and returns ctx.Err().
+// Workers check ctx between rows, so that no CPU is spent on a request that was cancelled or timed out.
+func (p *Processor) ProcessContext(ctx context.Context, d *Data) (*image.RGBA, error) {
+	steps, err := p.operations()
 	if err != nil {
 		return nil, err
 	}
+	return run(ctx, p.Pool, steps, d.Image)
+}
 
-	return rgba, nil
+// ProcessReport is like ProcessContext, but it also returns the corrections that the automatic operations of the pipeline,
+// e.g. WhiteBalance and AutoLevels, measured and applied, in order.
+func (p *Processor) ProcessReport(ctx context.Context, d *Data) (*image.RGBA, []Correction, error) {
+	steps, err := p.operations()
+	if err != nil {
+		return nil, nil, err
+	}
+	var corrections []Correction
+	s := &scratch{ctx: ctx, pool: p.Pool, report: func(c Correction) {
+		corrections = append(corrections, c)
+	}}
+	img, err := runScratch(s, steps, d.Image)
+	if err != nil {
+		return nil, nil, err
+	}
+	return img, corrections, nil
+}
+
+// ProcessInto is like Process, but it writes the processed image into dst instead of a new image.
+// dst must have the size of the processed image, e.g. Width x Height when both are set. Otherwise, it returns an error ErrInvalidDestination.
+//
+// Intermediate images are taken from a buffer pool shared by all Processors,
+// so that processing images of similar sizes over and over allocates almost nothing once the pool is warm.
+// The last of them is still copied into dst, as the operations do not render into buffers they are given.
+func (p *Processor) ProcessInto(dst *image.RGBA, d *Data) error {
+	steps, err := p.operations()
+	if err != nil {
+		return err
+	}
+	// the size is checked before any processing whenever it can be told up front, and after it otherwise
+	if size, ok := outputSize(steps, d.Image.Bounds().Size()); ok && dst.Bounds().Size() != size {
+		return ErrInvalidDestination
+	}
+	img, err := run(context.Background(), p.Pool, steps, d.Image)
+	if err != nil {
+		return err
+	}
+	if img != d.Image {
+		defer putBuffer(img)
+	}
+
+	size := img.Bounds().Size()
+	if dst.Bounds().Size() != size {
+		return ErrInvalidDestination
+	}
+	for y := range size.Y {
+		i := dst.PixOffset(dst.Rect.Min.X, dst.Rect.Min.Y+y)
+		copy(dst.Pix[i:i+4*size.X], img.Pix[y*img.Stride:])
+	}
+	return nil
 }
 
 // NewProcessor creates a new Processor instance from an Instruction instance.
-// If the Instruction.Width and Instruction.Height are not set, it returns an error ErrInvalidDimension.
-// It also creates a new Interpolator instance from the Interpolation instruction. If Instruction.Interpolation is not set, it defaults to Bilinear.
+// Every error it returns is a *ValidationError pointing to the invalid field and step of the Instruction.
+//
+// If the Instruction describes a single resize:
+//   - If the Instruction.Width and Instruction.Height are not set, it returns an error ErrInvalidDimension.
+//   - It also creates a new Interpolator instance from the Interpolation instruction. If Instruction.Interpolation is not set, it defaults to Bilinear.
+//   - If Instruction.Fit or Instruction.Gravity is invalid, it returns an error ErrInvalidFit or ErrInvalidGravity.
+//
+// If the Instruction describes a pipeline, every operation is validated up front, and any resize field being set returns an error ErrConflictingPipeline.
 func NewProcessor(i Instruction) (*Processor, error) {
-	if i.Width == 0 && i.Height == 0 {
-		return nil, ErrInvalidDimension
+	if len(i.Pipeline) > 0 {
+		if i.Width != 0 || i.Height != 0 || i.Interpolation != "" || i.Fit != "" || i.Gravity != "" || i.Background != (color.RGBA{}) || i.Sharpen {
+			return nil, fieldError("pipeline", ErrConflictingPipeline)
+		}
+		for step, op := range i.Pipeline {
+			if err := op.validate(); err != nil {
+				return nil, stepError(step, op.kind(), err)
+			}
+		}
+		return &Processor{
+			Instruction: i,
+			steps:       i.Pipeline,
+		}, nil
 	}
 
-	switch i.Interpolation {
-	case "":
+	if i.Interpolation == "" {
 		i.Interpolation = Bilinear
-	case NearestNeighbor, Bilinear, Bicubic:
-		// do nothing
-	default:
-		return nil, ErrInvalidInterpolation
+	}
+	if i.Fit == "" {
+		i.Fit = FitFill
 	}
 
-	itp := newInterpolator(i.Interpolation)
+	resize := Resize{
+		Width:         i.Width,
+		Height:        i.Height,
+		Interpolation: i.Interpolation,
+		Fit:           i.Fit,
+		Gravity:       i.Gravity,
+		Background:    i.Background,
+		Sharpen:       i.Sharpen,
+	}
+	if err := resize.validate(); err != nil {
+		return nil, err
+	}
 
 	return &Processor{
 		Instruction:  i,
-		Interpolator: itp,
+		Interpolator: newInterpolator(i.Interpolation),
+		steps:        []Operation{resize},
 	}, nil
 }
@@ -1,7 +1,11 @@
 package gato
 
 import (
+	"context"
+	"image"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestProcessor(t *testing.T) {
@@ -53,7 +57,7 @@ func TestProcessor(t *testing.T) {
 	})
 
 	t.Run("return error when invalid interpolation method is provided", func(t *testing.T) {
-		m := "full crimp"
+		m := Interpolation("full crimp")
 		i := Instruction{Width: 100, Interpolation: m}
 		_, got := NewProcessor(i)
 		assertError(t, got, ErrInvalidInterpolation)
@@ -93,4 +97,233 @@ func TestProcessor(t *testing.T) {
 		assertInt(t, result.Bounds().Dx(), w)
 		assertInt(t, result.Bounds().Dy(), h)
 	})
+
+	t.Run("return error when invalid fit is provided", func(t *testing.T) {
+		i := Instruction{Width: 100, Height: 100, Fit: "squash"}
+		_, got := NewProcessor(i)
+		assertError(t, got, ErrInvalidFit)
+	})
+
+	t.Run("return error when invalid gravity is provided", func(t *testing.T) {
+		i := Instruction{Width: 100, Height: 100, Gravity: "up"}
+		_, got := NewProcessor(i)
+		assertError(t, got, ErrInvalidGravity)
+	})
+
+	t.Run("letterbox the image with contain fit", func(t *testing.T) {
+		d := &Data{Image: newGradientImage(40, 20)}
+		i := Instruction{Width: 20, Height: 20, Fit: FitContain}
+		p, _ := NewProcessor(i)
+		result, _ := p.Process(d)
+		assertInt(t, result.Bounds().Dx(), 20)
+		assertInt(t, result.Bounds().Dy(), 20)
+		if got := result.RGBAAt(10, 0); got.A != 0 {
+			t.Errorf("got %v, want transparent letterbox", got)
+		}
+		if got := result.RGBAAt(10, 10); got.A != 255 {
+			t.Errorf("got %v, want opaque image", got)
+		}
+	})
+
+	t.Run("crop the overflow with cover fit", func(t *testing.T) {
+		d := &Data{Image: newGradientImage(40, 20)}
+		i := Instruction{Width: 20, Height: 20, Fit: FitCover, Gravity: GravityWest}
+		p, _ := NewProcessor(i)
+		result, _ := p.Process(d)
+		assertInt(t, result.Bounds().Dx(), 20)
+		assertInt(t, result.Bounds().Dy(), 20)
+		if got, want := result.RGBAAt(0, 0), d.Image.RGBAAt(0, 0); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("run the operations of a pipeline in order", func(t *testing.T) {
+		d := &Data{Image: newGradientImage(40, 20)}
+		i := Instruction{Pipeline: []Operation{
+			Rotate{Angle: 90},
+			Resize{Width: 10},
+			Pad{Width: 30, Height: 30},
+		}}
+		p, err := NewProcessor(i)
+		assertError(t, err, nil)
+		result, _ := p.Process(d)
+		assertInt(t, result.Bounds().Dx(), 30)
+		assertInt(t, result.Bounds().Dy(), 30)
+		if got := result.RGBAAt(15, 15); got.A != 255 {
+			t.Errorf("got %v, want opaque image", got)
+		}
+		if got := result.RGBAAt(0, 15); got.A != 0 {
+			t.Errorf("got %v, want transparent padding", got)
+		}
+	})
+
+	t.Run("report the corrections of automatic operations", func(t *testing.T) {
+		d := &Data{Image: newGradientImage(40, 20)}
+		p, _ := NewProcessor(Instruction{Pipeline: []Operation{
+			Resize{Width: 20},
+			WhiteBalance{},
+			AutoContrast{},
+		}})
+		result, corrections, err := p.ProcessReport(context.Background(), d)
+		assertError(t, err, nil)
+		assertInt(t, len(corrections), 2)
+		assertInt(t, corrections[0].Step, 1)
+		assertInt(t, corrections[1].Step, 2)
+		if _, ok := corrections[0].Operation.(Recolor); !ok {
+			t.Errorf("got %T, want Recolor", corrections[0].Operation)
+		}
+		want, _ := p.Process(d)
+		if !reflect.DeepEqual(result.Pix, want.Pix) {
+			t.Errorf("got an image other than the one of Process")
+		}
+	})
+
+	t.Run("validate every operation of a pipeline up front", func(t *testing.T) {
+		i := Instruction{Pipeline: []Operation{
+			Trim{},
+			Resize{Width: 100, Fit: "squash"},
+		}}
+		_, got := NewProcessor(i)
+		assertError(t, got, ErrInvalidFit)
+	})
+
+	t.Run("return error when resize fields are combined with a pipeline", func(t *testing.T) {
+		i := Instruction{Width: 100, Pipeline: []Operation{Trim{}}}
+		_, got := NewProcessor(i)
+		assertError(t, got, ErrConflictingPipeline)
+	})
+
+	t.Run("stop processing when the context is done", func(t *testing.T) {
+		d := &Data{Image: newGradientImage(40, 20)}
+		p, _ := NewProcessor(Instruction{Pipeline: []Operation{Rotate{Angle: 30}, Resize{Width: 10}}})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, got := p.ProcessContext(ctx, d)
+		assertError(t, got, context.Canceled)
+
+		ctx, cancel = context.WithTimeout(context.Background(), -time.Second)
+		defer cancel()
+		_, got = p.ProcessContext(ctx, d)
+		assertError(t, got, context.DeadlineExceeded)
+	})
+
+	t.Run("write the processed image into the destination", func(t *testing.T) {
+		d := &Data{Image: newGradientImage(40, 20)}
+		p, _ := NewProcessor(Instruction{Width: 20, Height: 10})
+		want, _ := p.Process(d)
+
+		// a sub-image, to check that the offset and stride of dst are respected
+		canvas := image.NewRGBA(image.Rect(0, 0, 30, 30))
+		dst := canvas.SubImage(image.Rect(5, 5, 25, 15)).(*image.RGBA)
+		err := p.ProcessInto(dst, d)
+		assertError(t, err, nil)
+		for y := range 10 {
+			for x := range 20 {
+				if got := dst.RGBAAt(5+x, 5+y); got != want.RGBAAt(x, y) {
+					t.Fatalf("at (%d, %d) got %v, want %v", x, y, got, want.RGBAAt(x, y))
+				}
+			}
+		}
+		if got := canvas.RGBAAt(4, 4); got.A != 0 {
+			t.Errorf("got %v outside of dst, want it untouched", got)
+		}
+	})
+
+	t.Run("return error when the destination does not have the processed size", func(t *testing.T) {
+		d := &Data{Image: newGradientImage(40, 20)}
+		p, _ := NewProcessor(Instruction{Width: 20, Height: 10})
+		err := p.ProcessInto(image.NewRGBA(image.Rect(0, 0, 10, 10)), d)
+		assertError(t, err, ErrInvalidDestination)
+	})
+
+	t.Run("process following the instruction of a processor built as a struct literal", func(t *testing.T) {
+		d := &Data{Image: newGradientImage(40, 20)}
+		p := &Processor{Instruction: Instruction{Width: 20, Height: 10}}
+		img, err := p.Process(d)
+		assertError(t, err, nil)
+		assertInt(t, img.Bounds().Dx(), 20)
+		assertInt(t, img.Bounds().Dy(), 10)
+		if img == d.Image {
+			t.Error("got the source image, want a new image")
+		}
+
+		err = p.ProcessInto(image.NewRGBA(image.Rect(0, 0, 20, 10)), d)
+		assertError(t, err, nil)
+
+		_, err = (&Processor{}).Process(d)
+		assertValidationError(t, err, -1, "width", ErrInvalidDimension)
+	})
+
+	t.Run("reject the destination before running the pipeline", func(t *testing.T) {
+		d := &Data{Image: newGradientImage(40, 20)}
+		spy := &spyOperation{}
+		p, err := NewProcessor(Instruction{Pipeline: []Operation{
+			Crop{Width: 30, Height: 20},
+			Pad{Top: 2},
+			Rotate{Angle: 90},
+			spy,
+			Resize{Height: 16},
+		}})
+		assertError(t, err, nil)
+		err = p.ProcessInto(image.NewRGBA(image.Rect(0, 0, 10, 10)), d)
+		assertError(t, err, ErrInvalidDestination)
+		assertInt(t, spy.runs, 0)
+
+		err = p.ProcessInto(image.NewRGBA(image.Rect(0, 0, 12, 16)), d)
+		assertError(t, err, nil)
+		assertInt(t, spy.runs, 1)
+	})
+}
+
+// spyOperation is an operation that counts its runs and leaves images as they are.
+type spyOperation struct {
+	runs int
+}
+
+func (sp *spyOperation) kind() string {
+	return "spy"
+}
+
+func (sp *spyOperation) validate() error {
+	return nil
+}
+
+func (sp *spyOperation) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	sp.runs++
+	dst := s.get(src.Bounds().Dx(), src.Bounds().Dy())
+	crop(dst, src, image.Point{})
+	return dst, nil
+}
+
+func BenchmarkProcess(b *testing.B) {
+	d := &Data{Image: newGradientImage(640, 480)}
+	p, _ := NewProcessor(Instruction{Width: 320, Height: 240})
+	b.ReportAllocs()
+	for b.Loop() {
+		_, _ = p.Process(d)
+	}
+}
+
+func BenchmarkProcessInto(b *testing.B) {
+	d := &Data{Image: newGradientImage(640, 480)}
+	p, _ := NewProcessor(Instruction{Width: 320, Height: 240})
+	dst := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	b.ReportAllocs()
+	for b.Loop() {
+		_ = p.ProcessInto(dst, d)
+	}
+}
+
+func BenchmarkProcessIntoPipeline(b *testing.B) {
+	d := &Data{Image: newGradientImage(640, 480)}
+	p, _ := NewProcessor(Instruction{Pipeline: []Operation{
+		Crop{X: 20, Y: 20, Width: 600, Height: 440},
+		Resize{Width: 300, Height: 300, Fit: FitContain},
+		Rotate{Angle: 90},
+	}})
+	dst := image.NewRGBA(image.Rect(0, 0, 300, 300))
+	b.ReportAllocs()
+	for b.Loop() {
+		_ = p.ProcessInto(dst, d)
+	}
 }
@@ -0,0 +1,234 @@
+package gato
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+)
+
+var ErrInvalidLevels = errors.New("invalid levels: the number of levels and the minimum size must not be negative")
+
+// Pyramid describes a chain of images reduced by 2x from one level to the next, such as the mipmaps of a texture or the overviews of a map.
+// Level 0 is the image itself, and every level halves the dimensions of the previous one, rounding down, until the image is 1x1.
+type Pyramid struct {
+	// Levels is the maximum number of levels, including level 0. If it is not set, the chain goes down to MinSize.
+	Levels int
+	// MinSize stops the chain at the first level whose width and height are both at most MinSize, e.g. 256 for map overviews.
+	// If it is not set, it defaults to 1.
+	MinSize int
+	// Interpolation is the interpolation method whose kernel reduces each level. If it is not set, it defaults to Bilinear,
+	// which averages every 2x2 block of pixels. Odd dimensions widen the blocks, so that their last row or column is not dropped.
+	Interpolation Interpolation
+	// Gamma averages pixels in linear light instead of sRGB values, so that reduced levels do not get darker than the image.
+	Gamma bool
+	// PowerOfTwo pads level 0 at its right and bottom edges to the next power of two in each dimension, e.g. for textures.
+	PowerOfTwo bool
+	// Extend is how the padding of PowerOfTwo is filled. If it is not set, it defaults to ExtendColor.
+	Extend Extend
+	// Background is the color of the padding with ExtendColor. The zero value is transparent.
+	Background color.RGBA
+	// Pool runs the rows of the reductions. If it is not set, DefaultPool() is used.
+	Pool *Pool
+}
+
+func (py Pyramid) validate() error {
+	if py.Levels < 0 {
+		return fieldError("levels", ErrInvalidLevels)
+	}
+	if py.MinSize < 0 {
+		return fieldError("min_size", ErrInvalidLevels)
+	}
+	if _, err := parseInterpolation(py.Interpolation); err != nil {
+		return fieldError("interpolation", err)
+	}
+	if err := py.Extend.validate(); err != nil {
+		return fieldError("extend", err)
+	}
+	return nil
+}
+
+// reductionTaps returns the source pixels that every pixel of an axis reduced from srcN to dstN pixels weighs,
+// as the first of them and the weights from there on. The kernel of the interpolation method is stretched to the scale
+// of the reduction, which is 2 for even dimensions and a little more for odd ones, so that the last source pixel is still taken.
+func reductionTaps(method Interpolation, srcN, dstN int) (first []int, weights [][]float32) {
+	scale := float64(srcN) / float64(dstN)
+	first = make([]int, dstN)
+	weights = make([][]float32, dstN)
+	for x := range dstN {
+		// the center of the destination pixel, in source pixels
+		c := (float64(x)+0.5)*scale - 0.5
+		var wts []float64
+		switch method {
+		case NearestNeighbor:
+			first[x], weights[x] = int(math.Floor(c)), []float32{1}
+			continue
+		case Bicubic:
+			first[x] = int(math.Floor(c-scale)) + 1
+			for i := first[x]; float64(i) < c+scale; i++ {
+				wts = append(wts, catmullRom((float64(i)-c)*2/scale))
+			}
+		default:
+			// the area of the destination pixel, as a box
+			first[x] = int(math.Floor(c - scale/2 + 0.5))
+			for i := first[x]; float64(i)-0.5 < c+scale/2; i++ {
+				wts = append(wts, min(float64(i)+0.5, c+scale/2)-max(float64(i)-0.5, c-scale/2))
+			}
+		}
+		sum := 0.0
+		for _, wt := range wts {
+			sum += wt
+		}
+		weights[x] = make([]float32, len(wts))
+		for i, wt := range wts {
+			weights[x][i] = float32(wt / sum)
+		}
+	}
+	return first, weights
+}
+
+// catmullRom returns the weight of the Catmull-Rom spline at the distance t from its center, in samples.
+func catmullRom(t float64) float64 {
+	t = math.Abs(t)
+	switch {
+	case t < 1:
+		return 1.5*t*t*t - 2.5*t*t + 1
+	case t < 2:
+		return -0.5*t*t*t + 2.5*t*t - 4*t + 2
+	}
+	return 0
+}
+
+// Build returns the levels of the pyramid of src, from level 0.
+// Every level is a new image, and src itself is left untouched. Once ctx is done, it stops and returns ctx.Err().
+func (py Pyramid) Build(ctx context.Context, src *image.RGBA) ([]*image.RGBA, error) {
+	if err := py.validate(); err != nil {
+		return nil, err
+	}
+	minSize := max(py.MinSize, 1)
+
+	s := &scratch{ctx: ctx, pool: py.Pool}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	var level *image.RGBA
+	if py.PowerOfTwo {
+		var err error
+		level, err = Pad{Width: nextPowerOfTwo(w), Height: nextPowerOfTwo(h), Gravity: GravityNorthWest, Extend: py.Extend, Background: py.Background}.apply(s, src)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		level = s.get(w, h)
+		crop(level, src, image.Point{})
+	}
+
+	levels := []*image.RGBA{level}
+	for py.Levels == 0 || len(levels) < py.Levels {
+		if level.Bounds().Dx() <= minSize && level.Bounds().Dy() <= minSize {
+			break
+		}
+		next, err := reduce2x(s, level, py.Interpolation, py.Gamma)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, next)
+		level = next
+	}
+
+	return levels, nil
+}
+
+// nextPowerOfTwo returns the smallest power of two that is not less than n.
+func nextPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+// reduce2x returns src reduced by 2x with the kernel of the interpolation method, rounding odd dimensions down.
+// With gamma, pixels are averaged in linear light.
+func reduce2x(s *scratch, src *image.RGBA, method Interpolation, gamma bool) (*image.RGBA, error) {
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+	dstW, dstH := max(srcW/2, 1), max(srcH/2, 1)
+	firstX, weightsX := reductionTaps(method, srcW, dstW)
+	firstY, weightsY := reductionTaps(method, srcH, dstH)
+	dst := s.get(dstW, dstH)
+
+	err := s.parallel(dstH, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := range dstW {
+				var acc [4]float32
+				for j, wy := range weightsY[y] {
+					sy := extendIndex(firstY[y]+j, srcH, ExtendEdge)
+					for i, wx := range weightsX[x] {
+						sx := extendIndex(firstX[x]+i, srcW, ExtendEdge)
+						c := src.RGBAAt(src.Rect.Min.X+sx, src.Rect.Min.Y+sy)
+						wt := wx * wy
+						r, g, b := float32(c.R), float32(c.G), float32(c.B)
+						if gamma && c.A > 0 {
+							// linearize the straight color, and premultiply it again
+							a := float32(c.A) / 255
+							r = srgbToLinear(r/a) * a
+							g = srgbToLinear(g/a) * a
+							b = srgbToLinear(b/a) * a
+						}
+						acc[0] += wt * r
+						acc[1] += wt * g
+						acc[2] += wt * b
+						acc[3] += wt * float32(c.A)
+					}
+				}
+
+				a := clamp(float64(acc[3]))
+				if gamma && a > 0 {
+					fa := float32(a) / 255
+					for k := range 3 {
+						acc[k] = linearToSRGB(acc[k]/fa) * fa
+					}
+				}
+				// premultiplied colors never exceed their alpha, which the negative lobes of bicubic could break
+				dst.SetRGBA(x, y, color.RGBA{
+					min(clamp(float64(acc[0])), a),
+					min(clamp(float64(acc[1])), a),
+					min(clamp(float64(acc[2])), a),
+					a,
+				})
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dst, nil
+}
+
+// srgbLinear maps every 8-bit sRGB value to linear light, ranging from 0 to 255.
+var srgbLinear = func() (lut [256]float32) {
+	for i := range lut {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		lut[i] = float32(v * 255)
+	}
+	return lut
+}()
+
+// srgbToLinear converts the sRGB value v, ranging from 0 to 255, to linear light.
+func srgbToLinear(v float32) float32 {
+	return srgbLinear[clamp(float64(v))]
+}
+
+// linearToSRGB converts the linear light value v, ranging from 0 to 255, to sRGB.
+func linearToSRGB(v float32) float32 {
+	l := min(max(float64(v)/255, 0), 1)
+	if l <= 0.0031308 {
+		return float32(l * 12.92 * 255)
+	}
+	return float32((1.055*math.Pow(l, 1/2.4) - 0.055) * 255)
+}
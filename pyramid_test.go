@@ -0,0 +1,126 @@
+package gato
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestPyramid(t *testing.T) {
+	sizes := func(levels []*image.RGBA) []image.Point {
+		var got []image.Point
+		for _, l := range levels {
+			got = append(got, l.Bounds().Size())
+		}
+		return got
+	}
+	assertSizes := func(t *testing.T, got, want []image.Point) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		}
+	}
+
+	t.Run("halve every level down to 1x1", func(t *testing.T) {
+		src := newGradientImage(40, 20)
+		levels, err := Pyramid{}.Build(context.Background(), src)
+		assertError(t, err, nil)
+		assertSizes(t, sizes(levels), []image.Point{{40, 20}, {20, 10}, {10, 5}, {5, 2}, {2, 1}, {1, 1}})
+		if &levels[0].Pix[0] == &src.Pix[0] {
+			t.Error("got the source as level 0, want a copy")
+		}
+	})
+
+	t.Run("stop at the number of levels or the minimum size", func(t *testing.T) {
+		src := newGradientImage(40, 20)
+		levels, _ := Pyramid{Levels: 3}.Build(context.Background(), src)
+		assertSizes(t, sizes(levels), []image.Point{{40, 20}, {20, 10}, {10, 5}})
+		levels, _ = Pyramid{MinSize: 10}.Build(context.Background(), src)
+		assertSizes(t, sizes(levels), []image.Point{{40, 20}, {20, 10}, {10, 5}})
+	})
+
+	t.Run("pad to powers of two", func(t *testing.T) {
+		src := newGradientImage(5, 3)
+		levels, err := Pyramid{PowerOfTwo: true, Extend: ExtendEdge}.Build(context.Background(), src)
+		assertError(t, err, nil)
+		assertSizes(t, sizes(levels), []image.Point{{8, 4}, {4, 2}, {2, 1}, {1, 1}})
+		if got, want := levels[0].RGBAAt(7, 3), src.RGBAAt(4, 2); got != want {
+			t.Errorf("got %v, want the edge pixel %v", got, want)
+		}
+	})
+
+	t.Run("average 2x2 blocks in sRGB or in linear light", func(t *testing.T) {
+		// columns alternate between black and white
+		src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		for y := range 4 {
+			for x := range 4 {
+				v := uint8(255 * (x % 2))
+				src.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+			}
+		}
+		levels, _ := Pyramid{Levels: 2}.Build(context.Background(), src)
+		assertInt(t, int(levels[1].RGBAAt(0, 0).R), 128)
+		levels, _ = Pyramid{Levels: 2, Gamma: true}.Build(context.Background(), src)
+		assertInt(t, int(levels[1].RGBAAt(0, 0).R), 188)
+	})
+
+	t.Run("take the last row and column of odd dimensions", func(t *testing.T) {
+		// only the last row and the last column are white
+		src := newGrayImage(5, 5, func(x, y int) uint8 {
+			if x == 4 || y == 4 {
+				return 255
+			}
+			return 0
+		})
+		for _, m := range []Interpolation{Bilinear, Bicubic} {
+			levels, err := Pyramid{Levels: 2, Interpolation: m}.Build(context.Background(), src)
+			assertError(t, err, nil)
+			assertInt(t, int(levels[1].RGBAAt(0, 0).R), 0)
+			for _, p := range []image.Point{{1, 0}, {0, 1}, {1, 1}} {
+				if got := levels[1].RGBAAt(p.X, p.Y).R; got < 64 {
+					t.Errorf("%s: got %d at %v, want the white edge taken", m, got, p)
+				}
+			}
+		}
+		levels, _ := Pyramid{Levels: 2}.Build(context.Background(), src)
+		// the last destination pixel covers 2.5 source pixels, of which 1 is white
+		assertInt(t, int(levels[1].RGBAAt(1, 0).R), 102)
+	})
+
+	t.Run("keep a uniform image uniform with any kernel", func(t *testing.T) {
+		src := image.NewRGBA(image.Rect(0, 0, 9, 9))
+		c := color.RGBA{60, 40, 20, 128}
+		for y := range 9 {
+			for x := range 9 {
+				src.SetRGBA(x, y, c)
+			}
+		}
+		for _, m := range []Interpolation{NearestNeighbor, Bilinear, Bicubic} {
+			for _, gamma := range []bool{false, true} {
+				levels, err := Pyramid{Interpolation: m, Gamma: gamma}.Build(context.Background(), src)
+				assertError(t, err, nil)
+				for i, l := range levels {
+					if got := l.RGBAAt(0, 0); absDiff(got.R, c.R) > 1 || absDiff(got.G, c.G) > 1 || absDiff(got.B, c.B) > 1 || got.A != c.A {
+						t.Errorf("%s, gamma %v, level %d: got %v, want %v", m, gamma, i, got, c)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("return error when the pyramid is invalid", func(t *testing.T) {
+		src := newGradientImage(4, 4)
+		_, err := Pyramid{Levels: -1}.Build(context.Background(), src)
+		assertError(t, err, ErrInvalidLevels)
+		_, err = Pyramid{Interpolation: "lanczos"}.Build(context.Background(), src)
+		assertError(t, err, ErrInvalidInterpolation)
+		_, err = Pyramid{PowerOfTwo: true, Extend: "wrap"}.Build(context.Background(), src)
+		assertError(t, err, ErrInvalidExtend)
+	})
+}
@@ -0,0 +1,169 @@
+package gato
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Resize is an operation that scales an image.
+// Its fields have the same meaning as the ones of Instruction.
+type Resize struct {
+	Width         int           `json:"width,omitempty"`
+	Height        int           `json:"height,omitempty"`
+	Interpolation Interpolation `json:"interpolation,omitempty"`
+	Fit           Fit           `json:"fit,omitempty"`
+	Gravity       Gravity       `json:"gravity,omitempty"`
+	Background    color.RGBA    `json:"-"`
+	Sharpen       bool          `json:"sharpen,omitempty"`
+}
+
+// parseInterpolation returns the interpolator of the interpolation method.
+// If method is not set, it defaults to Bilinear.
+func parseInterpolation(method Interpolation) (interpolator, error) {
+	switch method {
+	case "":
+		method = Bilinear
+	case NearestNeighbor, Bilinear, Bicubic:
+		// do nothing
+	default:
+		return nil, ErrInvalidInterpolation
+	}
+	return newInterpolator(method), nil
+}
+
+func (r Resize) kind() string {
+	return "resize"
+}
+
+func (r Resize) validate() error {
+	if r.Width < 0 || (r.Width == 0 && r.Height == 0) {
+		return fieldError("width", ErrInvalidDimension)
+	}
+	if r.Height < 0 {
+		return fieldError("height", ErrInvalidDimension)
+	}
+	if err := r.Fit.validate(); err != nil {
+		return fieldError("fit", err)
+	}
+	if _, _, err := anchor(r.Gravity); err != nil {
+		return fieldError("gravity", err)
+	}
+	if _, err := parseInterpolation(r.Interpolation); err != nil {
+		return fieldError("interpolation", err)
+	}
+	return nil
+}
+
+// dimensions returns the dimensions of the output image for a source image of size srcW x srcH.
+func (r Resize) dimensions(srcW, srcH int) (w, h int) {
+	w = r.Width
+	h = r.Height
+	if w == 0 {
+		scale := float64(h) / float64(srcH)
+		w = int(math.Round(scale * float64(srcW)))
+	}
+	if h == 0 {
+		scale := float64(w) / float64(srcW)
+		h = int(math.Round(scale * float64(srcH)))
+	}
+	return w, h
+}
+
+func (r Resize) outputSize(size image.Point) (image.Point, bool) {
+	w, h := r.dimensions(size.X, size.Y)
+	return image.Pt(w, h), true
+}
+
+func (r Resize) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	itp, err := parseInterpolation(r.Interpolation)
+	if err != nil {
+		return nil, err
+	}
+
+	// setting dimensions
+	srcW := src.Bounds().Dx()
+	srcH := src.Bounds().Dy()
+	w, h := r.dimensions(srcW, srcH)
+
+	// dimensions of the resized image before it is letterboxed or cropped
+	rw, rh := w, h
+	if r.Width != 0 && r.Height != 0 && (r.Fit == FitContain || r.Fit == FitCover) {
+		scaleX := getScale(srcW, w)
+		scaleY := getScale(srcH, h)
+		scale := min(scaleX, scaleY)
+		if r.Fit == FitCover {
+			scale = max(scaleX, scaleY)
+		}
+		rw = max(1, int(math.Round(scale*float64(srcW))))
+		rh = max(1, int(math.Round(scale*float64(srcH))))
+	}
+
+	rgba := s.get(rw, rh)
+	if err := itp.interpolate(s, src, rgba); err != nil {
+		return nil, err
+	}
+	if r.Sharpen {
+		// the scale of the least reduced dimension, so that stretched images are not oversharpened
+		scale := max(float64(rw)/float64(srcW), float64(rh)/float64(srcH))
+		if sh, ok := reductionSharpen(r.Interpolation, scale); ok {
+			sharpened, err := sh.apply(s, rgba)
+			if err != nil {
+				return nil, err
+			}
+			s.put(rgba)
+			rgba = sharpened
+		}
+	}
+
+	switch {
+	case rw < w || rh < h:
+		defer s.put(rgba)
+		return Pad{Width: w, Height: h, Gravity: r.Gravity, Background: r.Background}.apply(s, rgba)
+	case rw > w || rh > h:
+		defer s.put(rgba)
+		off, err := gravityOffset(r.Gravity, image.Pt(rw, rh), image.Pt(w, h))
+		if err != nil {
+			return nil, err
+		}
+		dst := s.get(w, h)
+		crop(dst, rgba, off)
+		return dst, nil
+	}
+
+	return rgba, nil
+}
+
+// Apply returns a new image of the source resized following the operation.
+func (r Resize) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	return r.apply(&scratch{}, src)
+}
+
+func (r Resize) MarshalJSON() ([]byte, error) {
+	type alias Resize
+	return marshalOperation(r.kind(), struct {
+		alias
+		Background *hexColor `json:"background,omitempty"`
+	}{alias(r), newHexColor(r.Background)})
+}
+
+func (r *Resize) UnmarshalJSON(data []byte) error {
+	type alias Resize
+	v := struct {
+		Op string `json:"op"`
+		*alias
+		Background string `json:"background"`
+	}{alias: (*alias)(r)}
+	if err := decodeStrict(data, &v); err != nil {
+		return err
+	}
+	bg, err := decodeColor("background", v.Background)
+	if err != nil {
+		return err
+	}
+	r.Background = bg
+	return nil
+}
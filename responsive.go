@@ -0,0 +1,221 @@
+package gato
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"image"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ResponsiveSet describes the renditions of an image for the srcset attribute, e.g. widths of 320, 640 and 1280 pixels in jpeg and png.
+type ResponsiveSet struct {
+	// Widths are the widths of the renditions. Widths larger than the source image are dropped, so that it is never enlarged.
+	Widths []int
+	// Formats are the formats of the renditions, by order of preference. The last one is the fallback of the <img> element.
+	// If it is not set, the format of the source image is kept.
+	Formats []string
+	// Interpolation is the interpolation method of the reductions. If it is not set, it defaults to Bilinear.
+	Interpolation Interpolation
+	// Quality is the quality of JPEG renditions. If it is not set, it defaults to jpeg.DefaultQuality.
+	Quality int
+	// URL returns the URL of a rendition. If it is not set, renditions are named like dog_640.jpg.
+	URL func(name string, width int, format string) string
+	// Sizes is the sizes attribute of the <picture> snippet, e.g. "(max-width: 600px) 100vw, 50vw".
+	Sizes string
+	// Alt is the alt attribute of the <img> element.
+	Alt string
+	// Pool runs the rows of the reductions. If it is not set, DefaultPool() is used.
+	Pool *Pool
+}
+
+// Rendition is one encoded image of a ResponsiveImage.
+type Rendition struct {
+	Width  int
+	Height int
+	Format string
+	URL    string
+	// Data is the encoded image.
+	Data []byte
+}
+
+// ResponsiveImage is the set of renditions made by ResponsiveSet.Generate.
+type ResponsiveImage struct {
+	// Renditions are ordered by format, following ResponsiveSet.Formats, and by width, from the narrowest.
+	Renditions []Rendition
+	Formats    []string
+	Sizes      string
+	Alt        string
+}
+
+func (rs ResponsiveSet) validate() error {
+	if len(rs.Widths) == 0 {
+		return fieldError("widths", ErrInvalidDimension)
+	}
+	for _, w := range rs.Widths {
+		if w <= 0 {
+			return fieldError("widths", ErrInvalidDimension)
+		}
+	}
+	for _, f := range rs.Formats {
+		if err := (Output{Format: f, Quality: rs.Quality}).validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := parseInterpolation(rs.Interpolation); err != nil {
+		return fieldError("interpolation", err)
+	}
+	return nil
+}
+
+// Generate makes every rendition of the image of d.
+//
+// The image is decoded once, and every width is reduced from the narrowest image made so far that is at least as wide,
+// from the widest to the narrowest, so that no reduction starts again from the full-size source.
+// Once ctx is done, it stops and returns ctx.Err().
+func (rs ResponsiveSet) Generate(ctx context.Context, d *Data) (*ResponsiveImage, error) {
+	if err := rs.validate(); err != nil {
+		return nil, err
+	}
+	formats := rs.Formats
+	if len(formats) == 0 {
+		formats = []string{d.Format}
+	}
+
+	srcW := d.Image.Bounds().Dx()
+	var widths []int
+	for _, w := range rs.Widths {
+		if w <= srcW && !slices.Contains(widths, w) {
+			widths = append(widths, w)
+		}
+	}
+	if len(widths) == 0 {
+		widths = []int{srcW}
+	}
+	// from the widest, so that every reduction can start from the previous one
+	slices.SortFunc(widths, func(a, b int) int { return b - a })
+
+	images := make([]*image.RGBA, 0, len(widths))
+	defer func() {
+		for _, img := range images {
+			if img != d.Image {
+				putBuffer(img)
+			}
+		}
+	}()
+	srcH := d.Image.Bounds().Dy()
+	from := d.Image
+	for _, w := range widths {
+		img := from
+		if w != from.Bounds().Dx() {
+			// the height follows the source rather than the previous reduction, so that rounding errors do not add up
+			h := max(1, int(math.Round(float64(w)*float64(srcH)/float64(srcW))))
+			var err error
+			img, err = run(ctx, rs.Pool, []Operation{Resize{Width: w, Height: h, Interpolation: rs.Interpolation}}, from)
+			if err != nil {
+				return nil, err
+			}
+		}
+		images = append(images, img)
+		from = img
+	}
+
+	ri := &ResponsiveImage{Formats: formats, Sizes: rs.Sizes, Alt: rs.Alt}
+	for _, f := range formats {
+		o := Output{Format: f, Quality: rs.Quality}
+		for i := len(images) - 1; i >= 0; i-- {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			img := images[i]
+			b := new(bytes.Buffer)
+			if err := o.Encode(b, img); err != nil {
+				return nil, err
+			}
+			w, h := img.Bounds().Dx(), img.Bounds().Dy()
+			ri.Renditions = append(ri.Renditions, Rendition{
+				Width:  w,
+				Height: h,
+				Format: f,
+				URL:    rs.url(d.Name, w, f),
+				Data:   b.Bytes(),
+			})
+		}
+	}
+
+	return ri, nil
+}
+
+func (rs ResponsiveSet) url(name string, width int, format string) string {
+	if rs.URL != nil {
+		return rs.URL(name, width, format)
+	}
+	ext := format
+	if ext == FormatJPEG {
+		ext = "jpg"
+	}
+	return fmt.Sprintf("%s_%d.%s", name, width, ext)
+}
+
+// SrcSet returns the srcset attribute listing the renditions of the format, e.g. "dog_320.jpg 320w, dog_640.jpg 640w".
+func (ri *ResponsiveImage) SrcSet(format string) string {
+	var candidates []string
+	for _, r := range ri.Renditions {
+		if r.Format == format {
+			candidates = append(candidates, r.URL+" "+strconv.Itoa(r.Width)+"w")
+		}
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// Picture returns a <picture> element with one <source> per preferred format and an <img> of the fallback format.
+// The src of the <img> is its widest rendition.
+// If Formats is not set, the formats of the renditions are used in their order. If there is no rendition either, it returns an empty string.
+func (ri *ResponsiveImage) Picture() string {
+	formats := ri.Formats
+	if len(formats) == 0 {
+		for _, r := range ri.Renditions {
+			if !slices.Contains(formats, r.Format) {
+				formats = append(formats, r.Format)
+			}
+		}
+	}
+	if len(formats) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("<picture>\n")
+	for _, f := range formats[:len(formats)-1] {
+		b.WriteString("  <source" + attr("type", Output{Format: f}.ContentType()) + attr("srcset", ri.SrcSet(f)) + ri.sizes() + ">\n")
+	}
+
+	fallback := formats[len(formats)-1]
+	var widest Rendition
+	for _, r := range ri.Renditions {
+		if r.Format == fallback && r.Width > widest.Width {
+			widest = r
+		}
+	}
+	b.WriteString("  <img" + attr("src", widest.URL) + attr("srcset", ri.SrcSet(fallback)) + ri.sizes() +
+		attr("width", strconv.Itoa(widest.Width)) + attr("height", strconv.Itoa(widest.Height)) + attr("alt", ri.Alt) + ">\n")
+	b.WriteString("</picture>")
+	return b.String()
+}
+
+// sizes returns the sizes attribute, or nothing when it is not set.
+func (ri *ResponsiveImage) sizes() string {
+	if ri.Sizes == "" {
+		return ""
+	}
+	return attr("sizes", ri.Sizes)
+}
+
+// attr returns the HTML attribute name="value" with its leading space, escaping value.
+func attr(name, value string) string {
+	return " " + name + `="` + html.EscapeString(value) + `"`
+}
@@ -0,0 +1,101 @@
+package gato
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"strconv"
+	"testing"
+)
+
+func TestResponsiveSet(t *testing.T) {
+	d := &Data{Name: "dog", Format: FormatPNG, Image: newGradientImage(400, 300)}
+
+	t.Run("make every width in every format", func(t *testing.T) {
+		rs := ResponsiveSet{Widths: []int{100, 320, 200, 800}, Formats: []string{FormatPNG, FormatJPEG}}
+		ri, err := rs.Generate(context.Background(), d)
+		assertError(t, err, nil)
+
+		want := []struct {
+			w, h   int
+			format string
+			url    string
+		}{
+			{100, 75, FormatPNG, "dog_100.png"},
+			{200, 150, FormatPNG, "dog_200.png"},
+			{320, 240, FormatPNG, "dog_320.png"},
+			{100, 75, FormatJPEG, "dog_100.jpg"},
+			{200, 150, FormatJPEG, "dog_200.jpg"},
+			{320, 240, FormatJPEG, "dog_320.jpg"},
+		}
+		assertInt(t, len(ri.Renditions), len(want))
+		for i, w := range want {
+			r := ri.Renditions[i]
+			if r.Width != w.w || r.Height != w.h || r.Format != w.format || r.URL != w.url {
+				t.Errorf("rendition %d: got %dx%d %s %s, want %dx%d %s %s", i, r.Width, r.Height, r.Format, r.URL, w.w, w.h, w.format, w.url)
+			}
+			img, format, err := image.Decode(bytes.NewReader(r.Data))
+			assertError(t, err, nil)
+			assertString(t, format, w.format)
+			assertInt(t, img.Bounds().Dx(), w.w)
+		}
+		if d.Image.Bounds().Dx() != 400 {
+			t.Error("source image was modified")
+		}
+	})
+
+	t.Run("keep the source size when every width is larger", func(t *testing.T) {
+		ri, err := ResponsiveSet{Widths: []int{1000}}.Generate(context.Background(), d)
+		assertError(t, err, nil)
+		assertInt(t, len(ri.Renditions), 1)
+		assertInt(t, ri.Renditions[0].Width, 400)
+		assertString(t, ri.Renditions[0].Format, FormatPNG)
+	})
+
+	t.Run("write the srcset and picture snippet", func(t *testing.T) {
+		rs := ResponsiveSet{
+			Widths:  []int{100, 200},
+			Formats: []string{FormatPNG, FormatJPEG},
+			URL: func(name string, width int, format string) string {
+				return "/img/" + name + "?w=" + strconv.Itoa(width) + "&fmt=" + format
+			},
+			Sizes: "50vw",
+			Alt:   `a "good" dog`,
+		}
+		ri, err := rs.Generate(context.Background(), d)
+		assertError(t, err, nil)
+		assertString(t, ri.SrcSet(FormatJPEG), "/img/dog?w=100&fmt=jpeg 100w, /img/dog?w=200&fmt=jpeg 200w")
+		assertString(t, ri.Picture(), `<picture>
+  <source type="image/png" srcset="/img/dog?w=100&amp;fmt=png 100w, /img/dog?w=200&amp;fmt=png 200w" sizes="50vw">
+  <img src="/img/dog?w=200&amp;fmt=jpeg" srcset="/img/dog?w=100&amp;fmt=jpeg 100w, /img/dog?w=200&amp;fmt=jpeg 200w" sizes="50vw" width="200" height="150" alt="a &#34;good&#34; dog">
+</picture>`)
+	})
+
+	t.Run("write the picture snippet without formats", func(t *testing.T) {
+		assertString(t, (&ResponsiveImage{}).Picture(), "")
+
+		ri := &ResponsiveImage{Renditions: []Rendition{
+			{Width: 100, Height: 75, Format: FormatPNG, URL: "dog_100.png"},
+			{Width: 200, Height: 150, Format: FormatPNG, URL: "dog_200.png"},
+		}}
+		assertString(t, ri.Picture(), `<picture>
+  <img src="dog_200.png" srcset="dog_100.png 100w, dog_200.png 200w" width="200" height="150" alt="">
+</picture>`)
+	})
+
+	t.Run("return error when the set is invalid", func(t *testing.T) {
+		_, err := ResponsiveSet{}.Generate(context.Background(), d)
+		assertError(t, err, ErrInvalidDimension)
+		_, err = ResponsiveSet{Widths: []int{100, 0}}.Generate(context.Background(), d)
+		assertError(t, err, ErrInvalidDimension)
+		_, err = ResponsiveSet{Widths: []int{100}, Formats: []string{"webp"}}.Generate(context.Background(), d)
+		assertError(t, err, ErrInvalidFormat)
+	})
+
+	t.Run("stop when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := ResponsiveSet{Widths: []int{100}}.Generate(ctx, d)
+		assertError(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,149 @@
+package gato
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+var ErrInvalidAngle = errors.New("invalid angle: the angle must be a finite number")
+
+// Rotate is an operation that rotates an image clockwise around its center.
+// Rotations by multiples of 90 degrees move pixels exactly, and any other angle extends the canvas to fit the whole rotated image.
+type Rotate struct {
+	// Angle is the clockwise rotation in degrees.
+	Angle float64 `json:"angle,omitempty"`
+	// Interpolation is the method used to sample the source image for angles other than multiples of 90 degrees. If it is not set, it defaults to Bilinear.
+	Interpolation Interpolation `json:"interpolation,omitempty"`
+	// Background is the color of the corners uncovered by the rotation. The zero value is transparent.
+	Background color.RGBA `json:"-"`
+}
+
+func (r Rotate) kind() string {
+	return "rotate"
+}
+
+func (r Rotate) validate() error {
+	if math.IsNaN(r.Angle) || math.IsInf(r.Angle, 0) {
+		return fieldError("angle", ErrInvalidAngle)
+	}
+	if _, err := parseInterpolation(r.Interpolation); err != nil {
+		return fieldError("interpolation", err)
+	}
+	return nil
+}
+
+func (r Rotate) outputSize(size image.Point) (image.Point, bool) {
+	w, h := rotatedSize(size.X, size.Y, r.Angle)
+	return image.Pt(w, h), true
+}
+
+func (r Rotate) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	angle := math.Mod(r.Angle, 360)
+	if angle < 0 {
+		angle += 360
+	}
+
+	srcW := src.Bounds().Dx()
+	srcH := src.Bounds().Dy()
+
+	dstW, dstH := rotatedSize(srcW, srcH, angle)
+	switch angle {
+	case 0, 90, 180, 270:
+		dst := s.get(dstW, dstH)
+		err := s.parallel(dstH, func(start, end int) {
+			for y := start; y < end; y++ {
+				for x := range dstW {
+					// source pixel that lands on (x, y)
+					var sx, sy int
+					switch angle {
+					case 0:
+						sx, sy = x, y
+					case 90:
+						sx, sy = y, srcH-1-x
+					case 180:
+						sx, sy = srcW-1-x, srcH-1-y
+					case 270:
+						sx, sy = srcW-1-y, x
+					}
+					dst.SetRGBA(x, y, src.RGBAAt(src.Rect.Min.X+sx, src.Rect.Min.Y+sy))
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		return dst, nil
+	}
+
+	rad := angle * math.Pi / 180
+	sin, cos := math.Sin(rad), math.Cos(rad)
+
+	// move the source center to the origin, rotate, and move the origin to the destination center
+	cx, cy := float64(srcW)/2, float64(srcH)/2
+	dx, dy := float64(dstW)/2, float64(dstH)/2
+	m := Affine{
+		cos, -sin, dx - cos*cx + sin*cy,
+		sin, cos, dy - sin*cx - cos*cy,
+	}
+
+	return Warp{
+		Matrix:        m.Homography(),
+		Width:         dstW,
+		Height:        dstH,
+		Interpolation: r.Interpolation,
+		Background:    r.Background,
+	}.apply(s, src)
+}
+
+// rotatedSize returns the size of the canvas that Rotate extends an image of size w x h to, for an angle in degrees.
+func rotatedSize(w, h int, angle float64) (int, int) {
+	angle = math.Mod(angle, 360)
+	if angle < 0 {
+		angle += 360
+	}
+	switch angle {
+	case 0, 180:
+		return w, h
+	case 90, 270:
+		return h, w
+	}
+	rad := angle * math.Pi / 180
+	sin, cos := math.Abs(math.Sin(rad)), math.Abs(math.Cos(rad))
+	return int(math.Ceil(float64(w)*cos + float64(h)*sin - 1e-9)), int(math.Ceil(float64(w)*sin + float64(h)*cos - 1e-9))
+}
+
+// Apply returns a new image of the source rotated following the operation.
+func (r Rotate) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	return r.apply(&scratch{}, src)
+}
+
+func (r Rotate) MarshalJSON() ([]byte, error) {
+	type alias Rotate
+	return marshalOperation(r.kind(), struct {
+		alias
+		Background *hexColor `json:"background,omitempty"`
+	}{alias(r), newHexColor(r.Background)})
+}
+
+func (r *Rotate) UnmarshalJSON(data []byte) error {
+	type alias Rotate
+	v := struct {
+		Op string `json:"op"`
+		*alias
+		Background string `json:"background"`
+	}{alias: (*alias)(r)}
+	if err := decodeStrict(data, &v); err != nil {
+		return err
+	}
+	bg, err := decodeColor("background", v.Background)
+	if err != nil {
+		return err
+	}
+	r.Background = bg
+	return nil
+}
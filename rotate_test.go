@@ -0,0 +1,74 @@
+package gato
+
+import (
+	"image"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestRotate(t *testing.T) {
+	t.Run("rotate by multiples of 90 degrees exactly", func(t *testing.T) {
+		src := newGradientImage(4, 3)
+		cases := []struct {
+			angle        float64
+			w, h         int
+			x, y, sx, sy int
+		}{
+			{0, 4, 3, 1, 2, 1, 2},
+			{90, 3, 4, 0, 0, 0, 2},
+			{180, 4, 3, 0, 0, 3, 2},
+			{-90, 3, 4, 0, 0, 3, 0},
+			{450, 3, 4, 2, 3, 3, 0},
+		}
+		for _, c := range cases {
+			dst, err := Rotate{Angle: c.angle}.Apply(src)
+			assertError(t, err, nil)
+			assertInt(t, dst.Bounds().Dx(), c.w)
+			assertInt(t, dst.Bounds().Dy(), c.h)
+			if got, want := dst.RGBAAt(c.x, c.y), src.RGBAAt(c.sx, c.sy); got != want {
+				t.Errorf("angle %v: got %v, want %v", c.angle, got, want)
+			}
+		}
+	})
+
+	t.Run("extend the canvas for other angles", func(t *testing.T) {
+		src := newGradientImage(10, 10)
+		dst, err := Rotate{Angle: 45}.Apply(src)
+		assertError(t, err, nil)
+		assertInt(t, dst.Bounds().Dx(), 15)
+		assertInt(t, dst.Bounds().Dy(), 15)
+		if got := dst.RGBAAt(0, 0); got.A != 0 {
+			t.Errorf("got %v, want transparent corner", got)
+		}
+		if got := dst.RGBAAt(7, 7); got.A != 255 {
+			t.Errorf("got %v, want opaque center", got)
+		}
+	})
+
+	t.Run("rotate a sub-image as a copy of its area", func(t *testing.T) {
+		src := newGradientImage(12, 10)
+		sub := src.SubImage(image.Rect(2, 3, 9, 8)).(*image.RGBA)
+		area, _ := Crop{X: 2, Y: 3, Width: 7, Height: 5}.Apply(src)
+		for _, angle := range []float64{0, 90, 180, 270, 30} {
+			got, err := Rotate{Angle: angle}.Apply(sub)
+			assertError(t, err, nil)
+			want, _ := Rotate{Angle: angle}.Apply(area)
+			if !reflect.DeepEqual(got.Pix, want.Pix) {
+				t.Errorf("%v degrees: got other pixels than the rotated copy", angle)
+			}
+		}
+	})
+
+	t.Run("return error when invalid interpolation method is provided", func(t *testing.T) {
+		_, err := Rotate{Angle: 30, Interpolation: "full crimp"}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidInterpolation)
+	})
+
+	t.Run("return error when the angle is not finite", func(t *testing.T) {
+		for _, angle := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+			_, err := Rotate{Angle: angle}.Apply(newGradientImage(4, 4))
+			assertValidationError(t, err, -1, "angle", ErrInvalidAngle)
+		}
+	})
+}
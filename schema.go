@@ -0,0 +1,233 @@
+package gato
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// SchemaVersion is the version of the JSON schema that Instruction and operations marshal to.
+const SchemaVersion = 1
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+	ErrUnknownOperation   = errors.New("unknown operation")
+	ErrUnknownField       = errors.New("unknown field")
+	ErrInvalidColor       = errors.New("invalid color: only #rgb, #rrggbb, and #rrggbbaa are available")
+)
+
+// ValidationError describes which field of which step of an Instruction is invalid.
+// It wraps the cause, so that errors.Is still matches the sentinel errors such as ErrInvalidDimension.
+type ValidationError struct {
+	// Step is the index of the operation in Instruction.Pipeline, or -1 when the error is about the Instruction itself.
+	Step int
+	// Op is the name of the operation, e.g. "resize". It is empty when Step is -1.
+	Op string
+	// Field is the JSON name of the invalid field, e.g. "width".
+	Field string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	path := e.Field
+	if e.Step >= 0 {
+		path = fmt.Sprintf("pipeline[%d] (%s)", e.Step, e.Op)
+		if e.Field != "" {
+			path += "." + e.Field
+		}
+	}
+	if path == "" {
+		return e.Err.Error()
+	}
+	return path + ": " + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// fieldError returns a *ValidationError for the field of the Instruction itself.
+func fieldError(field string, err error) error {
+	return &ValidationError{Step: -1, Field: field, Err: err}
+}
+
+// stepError places err at the step of the pipeline whose operation is named op.
+func stepError(step int, op string, err error) error {
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		return &ValidationError{Step: step, Op: op, Field: ve.Field, Err: ve.Err}
+	}
+	return &ValidationError{Step: step, Op: op, Err: err}
+}
+
+// decodeStrict decodes data into v, rejecting unknown fields.
+// Errors about a single field are returned as a *ValidationError.
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+
+	var te *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &te):
+		return fieldError(te.Field, err)
+	case strings.HasPrefix(err.Error(), `json: unknown field "`):
+		field := strings.TrimSuffix(strings.TrimPrefix(err.Error(), `json: unknown field "`), `"`)
+		return fieldError(field, ErrUnknownField)
+	}
+	return err
+}
+
+// hexColor is a color.RGBA that marshals to text as #rrggbbaa.
+type hexColor color.RGBA
+
+// newHexColor returns nil for the zero (transparent) color, so that it is omitted from JSON.
+func newHexColor(c color.RGBA) *hexColor {
+	if c == (color.RGBA{}) {
+		return nil
+	}
+	h := hexColor(c)
+	return &h
+}
+
+func (h hexColor) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("#%02x%02x%02x%02x", h.R, h.G, h.B, h.A)), nil
+}
+
+// decodeColor parses the color s of the field, which is transparent when s is empty.
+func decodeColor(field, s string) (color.RGBA, error) {
+	if s == "" {
+		return color.RGBA{}, nil
+	}
+	c, err := parseHexColor(s)
+	if err != nil {
+		return color.RGBA{}, fieldError(field, err)
+	}
+	return c, nil
+}
+
+// parseHexColor parses a color written as #rgb, #rrggbb or #rrggbbaa. The leading # is optional.
+func parseHexColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	switch len(s) {
+	case 3:
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]}) + "ff"
+	case 6:
+		s += "ff"
+	case 8:
+		// do nothing
+	default:
+		return color.RGBA{}, ErrInvalidColor
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, ErrInvalidColor
+	}
+	return color.RGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
+
+// operations maps the name of every operation to a function decoding it from JSON.
+var operations = map[string]func(data []byte) (Operation, error){
+	"auto_contrast":       decodeOperation[AutoContrast],
+	"auto_levels":         decodeOperation[AutoLevels],
+	"blur":                decodeOperation[Blur],
+	"box_blur":            decodeOperation[BoxBlur],
+	"brightness_contrast": decodeOperation[BrightnessContrast],
+	"clahe":               decodeOperation[CLAHE],
+	"color_grade":         decodeOperation[ColorGrade],
+	"convolve":            decodeOperation[Convolve],
+	"crop":                decodeOperation[Crop],
+	"curves":              decodeOperation[Curves],
+	"equalize":            decodeOperation[Equalize],
+	"gamma":               decodeOperation[Gamma],
+	"levels":              decodeOperation[Levels],
+	"pad":                 decodeOperation[Pad],
+	"recolor":             decodeOperation[Recolor],
+	"resize":              decodeOperation[Resize],
+	"rotate":              decodeOperation[Rotate],
+	"sharpen":             decodeOperation[Sharpen],
+	"trim":                decodeOperation[Trim],
+	"warp":                decodeOperation[Warp],
+	"white_balance":       decodeOperation[WhiteBalance],
+}
+
+func decodeOperation[T Operation](data []byte) (Operation, error) {
+	var op T
+	if err := json.Unmarshal(data, &op); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// marshalOperation marshals v, which holds the fields of an operation, into a JSON object led by the name of the operation.
+func marshalOperation(op string, v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	head := fmt.Sprintf(`{"op":%q`, op)
+	if len(b) == 2 { // {}
+		return []byte(head + "}"), nil
+	}
+	return append([]byte(head+","), b[1:]...), nil
+}
+
+func (i Instruction) MarshalJSON() ([]byte, error) {
+	type alias Instruction
+	return json.Marshal(struct {
+		Version int `json:"version"`
+		alias
+		Background *hexColor   `json:"background,omitempty"`
+		Pipeline   []Operation `json:"pipeline,omitempty"`
+	}{SchemaVersion, alias(i), newHexColor(i.Background), i.Pipeline})
+}
+
+// UnmarshalJSON decodes an Instruction stored with any schema version up to SchemaVersion.
+// A missing version is read as SchemaVersion. Errors about a single field or step are returned as a *ValidationError.
+func (i *Instruction) UnmarshalJSON(data []byte) error {
+	type alias Instruction
+	v := struct {
+		Version int `json:"version"`
+		*alias
+		Background string            `json:"background"`
+		Pipeline   []json.RawMessage `json:"pipeline"`
+	}{alias: (*alias)(i)}
+	if err := decodeStrict(data, &v); err != nil {
+		return err
+	}
+	if v.Version < 0 || v.Version > SchemaVersion {
+		return fieldError("version", ErrUnsupportedVersion)
+	}
+	bg, err := decodeColor("background", v.Background)
+	if err != nil {
+		return err
+	}
+	i.Background = bg
+
+	i.Pipeline = nil
+	for step, raw := range v.Pipeline {
+		var head struct {
+			Op string `json:"op"`
+		}
+		if err := json.Unmarshal(raw, &head); err != nil {
+			return stepError(step, "", err)
+		}
+		decode, ok := operations[head.Op]
+		if !ok {
+			return stepError(step, head.Op, fieldError("op", ErrUnknownOperation))
+		}
+		op, err := decode(raw)
+		if err != nil {
+			return stepError(step, head.Op, err)
+		}
+		i.Pipeline = append(i.Pipeline, op)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package gato
+
+import (
+	"encoding/json"
+	"errors"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func assertValidationError(t testing.TB, got error, step int, field string, want error) {
+	t.Helper()
+	var ve *ValidationError
+	if !errors.As(got, &ve) {
+		t.Fatalf("got %v, want *ValidationError", got)
+	}
+	if ve.Step != step || ve.Field != field {
+		t.Errorf("got step %d and field %q, want step %d and field %q", ve.Step, ve.Field, step, field)
+	}
+	assertError(t, got, want)
+}
+
+func TestInstructionJSON(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 255}
+
+	t.Run("marshal a single resize with the schema version", func(t *testing.T) {
+		i := Instruction{Width: 300, Fit: FitContain, Background: white}
+		b, err := json.Marshal(i)
+		assertError(t, err, nil)
+		assertString(t, string(b), `{"version":1,"width":300,"fit":"contain","background":"#ffffffff"}`)
+	})
+
+	t.Run("marshal a pipeline with the name of every operation", func(t *testing.T) {
+		i := Instruction{Pipeline: []Operation{Trim{Color: &white, Tolerance: 8}, Resize{Width: 100}, Pad{}}}
+		b, err := json.Marshal(i)
+		assertError(t, err, nil)
+		assertString(t, string(b), `{"version":1,"pipeline":[{"op":"trim","tolerance":8,"color":"#ffffffff"},{"op":"resize","width":100},{"op":"pad"}]}`)
+	})
+
+	t.Run("round trip every operation", func(t *testing.T) {
+		outWhite, intensity := uint8(250), 0.5
+		want := Instruction{Pipeline: []Operation{
+			Trim{Color: &white, Tolerance: 8},
+			Rotate{Angle: 30, Interpolation: Bicubic, Background: white},
+			Warp{Matrix: IdentityHomography, Width: 10, Height: 20, Interpolation: NearestNeighbor},
+			Resize{Width: 100, Height: 50, Fit: FitCover, Gravity: GravityNorth},
+			Pad{Width: 120, Height: 120, Extend: ExtendMirror},
+			Convolve{Horizontal: []float64{-1, 0, 1}, Extend: ExtendColor, Background: white},
+			Blur{Sigma: 1.5, Extend: ExtendMirror},
+			BoxBlur{Radius: 4, Passes: 3},
+			Sharpen{Amount: 0.8, Radius: 1.2, Threshold: 4},
+			Resize{Width: 50, Sharpen: true},
+			BrightnessContrast{Brightness: 0.1, Contrast: -0.2},
+			Gamma{Gamma: 2.2},
+			Levels{InBlack: 10, InWhite: 240, Gamma: 1.2, OutWhite: &outWhite},
+			Curves{Points: [][2]uint8{{0, 0}, {128, 160}, {255, 255}}},
+			Recolor{Matrix: SepiaMatrix()},
+			ColorGrade{LUT: newIdentityLUT(2), Intensity: &intensity},
+			Equalize{Luma: LumaRec709},
+			CLAHE{TileSize: 32, ClipLimit: 3},
+			WhiteBalance{Method: WhitePatch, Percentile: 98},
+			AutoLevels{Low: 0.5, High: 99.5},
+			AutoContrast{High: 99},
+		}}
+		b, _ := json.Marshal(want)
+		var got Instruction
+		err := json.Unmarshal(b, &got)
+		assertError(t, err, nil)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("read a missing version as the current one", func(t *testing.T) {
+		var i Instruction
+		err := json.Unmarshal([]byte(`{"width":100,"interpolation":"bicubic"}`), &i)
+		assertError(t, err, nil)
+		assertInt(t, i.Width, 100)
+		assertString(t, string(i.Interpolation), string(Bicubic))
+	})
+
+	t.Run("return error when the version is not supported", func(t *testing.T) {
+		var i Instruction
+		err := json.Unmarshal([]byte(`{"version":2,"width":100}`), &i)
+		assertValidationError(t, err, -1, "version", ErrUnsupportedVersion)
+	})
+
+	t.Run("return error when the enum is invalid", func(t *testing.T) {
+		var i Instruction
+		err := json.Unmarshal([]byte(`{"width":100,"fit":"squash"}`), &i)
+		assertValidationError(t, err, -1, "fit", ErrInvalidFit)
+	})
+
+	t.Run("point to the step and field of an invalid operation", func(t *testing.T) {
+		var i Instruction
+		err := json.Unmarshal([]byte(`{"pipeline":[{"op":"trim"},{"op":"pad","gravity":"up"}]}`), &i)
+		assertValidationError(t, err, 1, "gravity", ErrInvalidGravity)
+		assertString(t, err.Error(), "pipeline[1] (pad).gravity: "+ErrInvalidGravity.Error())
+	})
+
+	t.Run("return error when the operation is unknown", func(t *testing.T) {
+		var i Instruction
+		err := json.Unmarshal([]byte(`{"pipeline":[{"op":"crimp"}]}`), &i)
+		assertValidationError(t, err, 0, "op", ErrUnknownOperation)
+	})
+
+	t.Run("return error when the field is unknown", func(t *testing.T) {
+		var i Instruction
+		err := json.Unmarshal([]byte(`{"pipeline":[{"op":"resize","widht":100}]}`), &i)
+		assertValidationError(t, err, 0, "widht", ErrUnknownField)
+	})
+
+	t.Run("return error when the color is invalid", func(t *testing.T) {
+		var i Instruction
+		err := json.Unmarshal([]byte(`{"pipeline":[{"op":"pad","top":1,"background":"#12"}]}`), &i)
+		assertValidationError(t, err, 0, "background", ErrInvalidColor)
+	})
+}
+
+func TestParseHexColor(t *testing.T) {
+	cases := map[string]color.RGBA{
+		"#fff":      {255, 255, 255, 255},
+		"#102030":   {16, 32, 48, 255},
+		"10203040":  {16, 32, 48, 64},
+		"#00000000": {},
+	}
+	for s, want := range cases {
+		got, err := parseHexColor(s)
+		assertError(t, err, nil)
+		if got != want {
+			t.Errorf("%s: got %v, want %v", s, got, want)
+		}
+	}
+
+	_, err := parseHexColor("#ggg")
+	assertError(t, err, ErrInvalidColor)
+}
+
+func TestNewProcessorValidationError(t *testing.T) {
+	t.Run("point to the field of a single resize", func(t *testing.T) {
+		_, err := NewProcessor(Instruction{Width: 100, Gravity: "up"})
+		assertValidationError(t, err, -1, "gravity", ErrInvalidGravity)
+	})
+
+	t.Run("point to the step and field of a pipeline", func(t *testing.T) {
+		_, err := NewProcessor(Instruction{Pipeline: []Operation{Trim{}, Resize{Height: -1, Width: 10}}})
+		assertValidationError(t, err, 1, "height", ErrInvalidDimension)
+	})
+}
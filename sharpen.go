@@ -0,0 +1,110 @@
+package gato
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+var ErrInvalidSharpen = errors.New("invalid sharpen: amount and radius must be greater than 0")
+
+// Sharpen is an operation that sharpens an image with an unsharp mask.
+// The difference between the image and its Gaussian blur of sigma Radius is added back to it, Amount times,
+// wherever it is at least Threshold, so that flat areas and noise are left as they are.
+type Sharpen struct {
+	// Amount is the strength of the sharpening, e.g. 0.5 adds half of the difference.
+	Amount float64 `json:"amount"`
+	// Radius is the sigma of the blur in pixels, which is about the width of the sharpened edges.
+	Radius float64 `json:"radius"`
+	// Threshold is the minimum difference, from 0 to 255, of a channel between the image and its blur for it to be sharpened.
+	Threshold uint8 `json:"threshold,omitempty"`
+}
+
+func (sh Sharpen) kind() string {
+	return "sharpen"
+}
+
+func (sh Sharpen) validate() error {
+	if !(sh.Amount > 0) || math.IsInf(sh.Amount, 0) {
+		return fieldError("amount", ErrInvalidSharpen)
+	}
+	if !(sh.Radius > 0) || math.IsInf(sh.Radius, 0) {
+		return fieldError("radius", ErrInvalidSharpen)
+	}
+	return nil
+}
+
+func (sh Sharpen) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	blurred, err := Blur{Sigma: sh.Radius}.apply(s, src)
+	if err != nil {
+		return nil, err
+	}
+	// the blurred image is only the mask, so its buffer takes the sharpened image
+	dst := blurred
+
+	w := src.Bounds().Dx()
+	threshold := int(sh.Threshold)
+	err = s.parallel(src.Bounds().Dy(), func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := range w {
+				c := src.RGBAAt(src.Rect.Min.X+x, src.Rect.Min.Y+y)
+				b := dst.RGBAAt(x, y)
+				v := [3]uint8{c.R, c.G, c.B}
+				for k, m := range [3]uint8{b.R, b.G, b.B} {
+					d := int(v[k]) - int(m)
+					if d >= threshold || -d >= threshold {
+						v[k] = min(clamp(float64(v[k])+sh.Amount*float64(d)), c.A)
+					}
+				}
+				// alpha is kept as it is, so that edges against transparency do not get halos
+				dst.SetRGBA(x, y, color.RGBA{v[0], v[1], v[2], c.A})
+			}
+		}
+	})
+	if err != nil {
+		s.put(dst)
+		return nil, err
+	}
+
+	return dst, nil
+}
+
+// Apply returns a new image of the source sharpened following the operation.
+func (sh Sharpen) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := sh.validate(); err != nil {
+		return nil, err
+	}
+	return sh.apply(&scratch{}, src)
+}
+
+func (sh Sharpen) MarshalJSON() ([]byte, error) {
+	type alias Sharpen
+	return marshalOperation(sh.kind(), alias(sh))
+}
+
+func (sh *Sharpen) UnmarshalJSON(data []byte) error {
+	type alias Sharpen
+	v := struct {
+		Op string `json:"op"`
+		*alias
+	}{alias: (*alias)(sh)}
+	return decodeStrict(data, &v)
+}
+
+// reductionSharpen returns the unsharp mask that restores the crispness lost by reducing an image to scale times its size
+// with the interpolation method m, and false when the image needs none.
+//
+// The amount grows with the reduction, as every halving averages more detail away, up to a 16x reduction.
+// Bicubic gets half of it, as its negative lobes already sharpen, and NearestNeighbor none, as it does not smooth at all.
+func reductionSharpen(m Interpolation, scale float64) (Sharpen, bool) {
+	if scale > 0.9 || m == NearestNeighbor {
+		return Sharpen{}, false
+	}
+	amount := 0.15 * min(math.Log2(1/scale), 4)
+	if m == Bicubic {
+		amount /= 2
+	}
+	// the blur is narrower than a pixel of the reduced image, so that only the finest edges are enhanced
+	return Sharpen{Amount: amount, Radius: 0.6, Threshold: 2}, true
+}
@@ -0,0 +1,112 @@
+package gato
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// newEdgeImage returns an opaque image of size w x h, dark on its left half and light on its right half.
+func newEdgeImage(w, h int, dark, light uint8) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			v := dark
+			if x >= w/2 {
+				v = light
+			}
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestSharpen(t *testing.T) {
+	t.Run("increase the contrast of edges", func(t *testing.T) {
+		src := newEdgeImage(10, 4, 100, 150)
+		dst, err := Sharpen{Amount: 1, Radius: 1}.Apply(src)
+		assertError(t, err, nil)
+		if got := dst.RGBAAt(4, 2).R; got >= 100 {
+			t.Errorf("got %d on the dark side of the edge, want less than 100", got)
+		}
+		if got := dst.RGBAAt(5, 2).R; got <= 150 {
+			t.Errorf("got %d on the light side of the edge, want more than 150", got)
+		}
+		assertInt(t, int(dst.RGBAAt(0, 2).R), 100)
+		assertInt(t, int(dst.RGBAAt(5, 2).A), 255)
+	})
+
+	t.Run("leave differences below the threshold as they are", func(t *testing.T) {
+		src := newEdgeImage(10, 4, 100, 106)
+		dst, err := Sharpen{Amount: 1, Radius: 1, Threshold: 10}.Apply(src)
+		assertError(t, err, nil)
+		assertInt(t, int(dst.RGBAAt(4, 2).R), 100)
+		assertInt(t, int(dst.RGBAAt(5, 2).R), 106)
+	})
+
+	t.Run("keep premultiplied colors within their alpha", func(t *testing.T) {
+		src := image.NewRGBA(image.Rect(0, 0, 6, 1))
+		src.SetRGBA(3, 0, color.RGBA{128, 128, 128, 128})
+		dst, err := Sharpen{Amount: 4, Radius: 1}.Apply(src)
+		assertError(t, err, nil)
+		if got := dst.RGBAAt(3, 0); got.R > got.A {
+			t.Errorf("got %v, whose colors exceed its alpha", got)
+		}
+	})
+
+	t.Run("return error when amount or radius is invalid", func(t *testing.T) {
+		_, err := Sharpen{Radius: 1}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidSharpen)
+		_, err = Sharpen{Amount: 1, Radius: -1}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidSharpen)
+	})
+}
+
+func TestReductionSharpen(t *testing.T) {
+	cases := []struct {
+		m      Interpolation
+		scale  float64
+		amount float64
+		ok     bool
+	}{
+		{Bilinear, 1, 0, false},
+		{Bilinear, 2, 0, false},
+		{Bilinear, 0.95, 0, false},
+		{NearestNeighbor, 0.25, 0, false},
+		{Bilinear, 0.5, 0.15, true},
+		{"", 0.25, 0.3, true},
+		{Bicubic, 0.25, 0.15, true},
+		{Bilinear, 0.01, 0.6, true},
+	}
+	for _, c := range cases {
+		sh, ok := reductionSharpen(c.m, c.scale)
+		if ok != c.ok || sh.Amount < c.amount-1e-9 || sh.Amount > c.amount+1e-9 {
+			t.Errorf("%s at %v: got %v and %v, want an amount of %v and %v", c.m, c.scale, sh.Amount, ok, c.amount, c.ok)
+		}
+	}
+}
+
+func TestResizeSharpen(t *testing.T) {
+	// the edge falls in the middle of a pixel of the reduced image, which sharpening pushes apart from its neighbors
+	src := newEdgeImage(40, 8, 60, 200)
+	soft, err := Resize{Width: 10}.Apply(src)
+	assertError(t, err, nil)
+	sharp, err := Resize{Width: 10, Sharpen: true}.Apply(src)
+	assertError(t, err, nil)
+	assertInt(t, sharp.Bounds().Dx(), 10)
+	assertInt(t, sharp.Bounds().Dy(), 2)
+	if sharp.RGBAAt(4, 1).R >= soft.RGBAAt(4, 1).R || sharp.RGBAAt(5, 1).R <= soft.RGBAAt(5, 1).R {
+		t.Errorf("got %v and %v, want more contrast than %v and %v", sharp.RGBAAt(4, 1), sharp.RGBAAt(5, 1), soft.RGBAAt(4, 1), soft.RGBAAt(5, 1))
+	}
+
+	t.Run("leave enlarged images as they are", func(t *testing.T) {
+		src := newGradientImage(10, 10)
+		a, _ := Resize{Width: 20}.Apply(src)
+		b, _ := Resize{Width: 20, Sharpen: true}.Apply(src)
+		for i := range a.Pix {
+			if a.Pix[i] != b.Pix[i] {
+				t.Fatal("got a sharpened enlargement")
+			}
+		}
+	})
+}
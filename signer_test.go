@@ -0,0 +1,97 @@
+package gato
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &Signer{
+		Keys:    map[string][]byte{"old": []byte("old secret"), "new": []byte("new secret")},
+		KeyID:   "new",
+		Presets: []string{"thumbnail"},
+		Now:     func() time.Time { return now },
+	}
+
+	verify := func(target string) error {
+		u, _ := url.Parse(target)
+		return s.Verify(u)
+	}
+
+	t.Run("accept a signed URL", func(t *testing.T) {
+		signed, err := s.Sign("/photos/dog.jpg?w=300&fit=cover", time.Time{})
+		assertError(t, err, nil)
+		assertError(t, verify(signed), nil)
+	})
+
+	t.Run("accept URLs signed with any active key", func(t *testing.T) {
+		old := &Signer{Keys: s.Keys, KeyID: "old"}
+		signed, _ := old.Sign("/photos/dog.jpg?w=300", time.Time{})
+		assertError(t, verify(signed), nil)
+
+		rotated := &Signer{Keys: map[string][]byte{"new": []byte("new secret")}}
+		u, _ := url.Parse(signed)
+		assertError(t, rotated.Verify(u), ErrUnknownKey)
+	})
+
+	t.Run("reject a tampered URL", func(t *testing.T) {
+		signed, _ := s.Sign("/photos/dog.jpg?w=300", time.Time{})
+		assertError(t, verify(strings.Replace(signed, "w=300", "w=3000", 1)), ErrInvalidSignature)
+		assertError(t, verify(strings.Replace(signed, "dog", "cat", 1)), ErrInvalidSignature)
+		assertError(t, verify(signed+"&h=10"), ErrInvalidSignature)
+	})
+
+	t.Run("reject an expired URL", func(t *testing.T) {
+		signed, _ := s.Sign("/photos/dog.jpg?w=300", now.Add(time.Minute))
+		assertError(t, verify(signed), nil)
+		signed, _ = s.Sign("/photos/dog.jpg?w=300", now.Add(-time.Minute))
+		assertError(t, verify(signed), ErrExpiredSignature)
+	})
+
+	t.Run("accept unsigned requests of an allowed preset only", func(t *testing.T) {
+		assertError(t, verify("/photos/dog.jpg?preset=thumbnail"), nil)
+		assertError(t, verify("/photos/dog.jpg?preset=thumbnail&w=3000"), ErrMissingSignature)
+		assertError(t, verify("/photos/dog.jpg?preset=banner"), ErrMissingSignature)
+		assertError(t, verify("/photos/dog.jpg?w=300"), ErrMissingSignature)
+		assertError(t, verify("/w:3000/photos/dog.jpg?preset=thumbnail"), ErrMissingSignature)
+	})
+}
+
+func TestHandlerSigning(t *testing.T) {
+	s := &Signer{Keys: map[string][]byte{"k": []byte("secret")}, KeyID: "k", Presets: []string{"thumbnail"}}
+	h := NewHandler(newStubSource())
+	h.Signer = s
+	h.Presets = map[string]Params{"thumbnail": {Width: 10, Format: FormatJPEG}}
+
+	t.Run("reject unsigned requests before opening the source", func(t *testing.T) {
+		rec := serve(h, http.MethodGet, "/photos/missing.png?w=20", nil)
+		assertInt(t, rec.Code, http.StatusForbidden)
+	})
+
+	t.Run("serve signed requests", func(t *testing.T) {
+		signed, _ := s.Sign("/photos/dog.png?w=20", time.Time{})
+		rec := serve(h, http.MethodGet, signed, nil)
+		assertInt(t, rec.Code, http.StatusOK)
+	})
+
+	t.Run("serve allowed presets", func(t *testing.T) {
+		rec := serve(h, http.MethodGet, "/photos/dog.png?preset=thumbnail", nil)
+		assertInt(t, rec.Code, http.StatusOK)
+		assertString(t, rec.Header().Get("Content-Type"), "image/jpeg")
+	})
+
+	t.Run("reject unsigned presets overridden by path parameters", func(t *testing.T) {
+		rec := serve(h, http.MethodGet, "/w:300/h:400/photos/dog.png?preset=thumbnail", nil)
+		assertInt(t, rec.Code, http.StatusForbidden)
+	})
+
+	t.Run("reject unknown presets", func(t *testing.T) {
+		signed, _ := s.Sign("/photos/dog.png?preset=banner", time.Time{})
+		rec := serve(h, http.MethodGet, signed, nil)
+		assertInt(t, rec.Code, http.StatusBadRequest)
+	})
+}
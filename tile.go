@@ -0,0 +1,297 @@
+package gato
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"math/bits"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// Layout is the file layout of the tiles written by a Tiler.
+type Layout string
+
+const (
+	// LayoutDZI is the Deep Zoom layout: name.dzi, and name_files/level/column_row.ext for the tiles.
+	LayoutDZI Layout = "dzi"
+	// LayoutIIIF is the static layout of the IIIF Image API 3.0 at compliance level 0: name/info.json, and name/x,y,w,h/w,h/0/default.ext for the tiles.
+	LayoutIIIF Layout = "iiif"
+)
+
+var (
+	ErrInvalidLayout   = errors.New("invalid layout: only dzi and iiif are available")
+	ErrInvalidTileSize = errors.New("invalid tile size: tile size must be greater than 0, and overlap must not be negative")
+)
+
+// Tiler cuts an image into tiles of a fixed size across every zoom level, for deep-zoom viewers such as OpenSeadragon.
+// Every zoom level halves the previous one, rounding up, down to the level that fits in a single tile (IIIF) or that is 1x1 (DZI).
+type Tiler struct {
+	// Layout is the file layout of the tiles. If it is not set, it defaults to LayoutDZI.
+	Layout Layout
+	// TileSize is the width and height of tiles, without their overlap. If it is not set, it defaults to 256.
+	TileSize int
+	// Overlap is the number of pixels that tiles share with their neighbors on each side. It is only available with LayoutDZI.
+	Overlap int
+	// Output is the encoding of tiles. If Output.Format is not set, it defaults to FormatJPEG.
+	Output Output
+	// Interpolation is the interpolation method of the reductions between levels. If it is not set, it defaults to Bilinear.
+	Interpolation Interpolation
+	// BaseURL is the URL that the IIIF image directory is served under, e.g. https://example.com/iiif.
+	// The id of info.json is BaseURL followed by the name of the image. If it is not set, the id is the name alone.
+	BaseURL string
+	// Workers is the number of tiles encoded at the same time. If it is not greater than 0, it defaults to runtime.NumCPU().
+	Workers int
+	// Pool runs the rows of the reductions. If it is not set, DefaultPool() is used.
+	Pool *Pool
+}
+
+// tile is the area of a zoom level written to a file.
+type tile struct {
+	rect image.Rectangle
+	path string
+}
+
+func (t Tiler) validate() error {
+	switch t.Layout {
+	case "", LayoutDZI, LayoutIIIF:
+		// do nothing
+	default:
+		return fieldError("layout", ErrInvalidLayout)
+	}
+	if t.TileSize < 0 {
+		return fieldError("tile_size", ErrInvalidTileSize)
+	}
+	if t.Overlap < 0 || (t.Overlap > 0 && t.Layout == LayoutIIIF) {
+		return fieldError("overlap", ErrInvalidTileSize)
+	}
+	if err := t.output().validate(); err != nil {
+		return err
+	}
+	if _, err := parseInterpolation(t.Interpolation); err != nil {
+		return fieldError("interpolation", err)
+	}
+	return nil
+}
+
+func (t Tiler) tileSize() int {
+	if t.TileSize == 0 {
+		return 256
+	}
+	return t.TileSize
+}
+
+func (t Tiler) output() Output {
+	o := t.Output
+	if o.Format == "" {
+		o.Format = FormatJPEG
+	}
+	return o
+}
+
+// ext returns the file extension of the tiles.
+func (t Tiler) ext() string {
+	if f := t.output().Format; f != FormatJPEG {
+		return f
+	}
+	return "jpg"
+}
+
+// Write writes the tiles of src, and the descriptor of the image, into dir following the layout.
+// The image is named name, e.g. name.dzi or name/info.json. Once ctx is done, it stops and returns ctx.Err().
+func (t Tiler) Write(ctx context.Context, src *image.RGBA, dir, name string) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	ts := t.tileSize()
+
+	if t.Layout == LayoutIIIF {
+		scaleFactors := scaleFactors(w, h, ts)
+		root := filepath.Join(dir, name)
+		err := t.writeLevels(ctx, src, len(scaleFactors), func(k int, lw, lh int) []tile {
+			sf := scaleFactors[k]
+			var tiles []tile
+			for y := 0; y < lh; y += ts {
+				for x := 0; x < lw; x += ts {
+					r := image.Rect(x, y, min(x+ts, lw), min(y+ts, lh))
+					// the region is in the coordinates of the full image
+					region := fmt.Sprintf("%d,%d,%d,%d", x*sf, y*sf, min(ts*sf, w-x*sf), min(ts*sf, h-y*sf))
+					size := fmt.Sprintf("%d,%d", r.Dx(), r.Dy())
+					tiles = append(tiles, tile{r, filepath.Join(root, region, size, "0", "default."+t.ext())})
+				}
+			}
+			return tiles
+		})
+		if err != nil {
+			return err
+		}
+		return t.writeInfo(filepath.Join(root, "info.json"), name, w, h, scaleFactors)
+	}
+
+	// the levels of Deep Zoom go up from 1x1 to the full image
+	maxLevel := bits.Len(uint(max(w, h) - 1))
+	files := filepath.Join(dir, name+"_files")
+	err := t.writeLevels(ctx, src, maxLevel+1, func(k int, lw, lh int) []tile {
+		level := maxLevel - k
+		var tiles []tile
+		for row := 0; row*ts < lh; row++ {
+			for col := 0; col*ts < lw; col++ {
+				r := image.Rect(col*ts-t.Overlap, row*ts-t.Overlap, (col+1)*ts+t.Overlap, (row+1)*ts+t.Overlap)
+				r = r.Intersect(image.Rect(0, 0, lw, lh))
+				tiles = append(tiles, tile{r, filepath.Join(files, fmt.Sprint(level), fmt.Sprintf("%d_%d.%s", col, row, t.ext()))})
+			}
+		}
+		return tiles
+	})
+	if err != nil {
+		return err
+	}
+	return t.writeDZI(filepath.Join(dir, name+".dzi"), w, h)
+}
+
+// writeLevels writes the tiles of n zoom levels of src, from the full image.
+// Level k is the image reduced by 2^k, and tiles returns the tiles of the level k whose size is lw x lh.
+// Every level is reduced from the previous one, which is released once its tiles are written.
+func (t Tiler) writeLevels(ctx context.Context, src *image.RGBA, n int, tiles func(k int, lw, lh int) []tile) error {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	level := src
+	release := func() {
+		if level != src {
+			putBuffer(level)
+		}
+	}
+	defer release()
+
+	for k := range n {
+		if k > 0 {
+			next, err := t.reduce(ctx, level, ceilDiv(w, 1<<k), ceilDiv(h, 1<<k))
+			if err != nil {
+				return err
+			}
+			release()
+			level = next
+		}
+		if err := t.writeTiles(ctx, level, tiles(k, level.Bounds().Dx(), level.Bounds().Dy())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reduce resizes img to lw x lh with the interpolation method of t, or with NearestNeighbor when img is too small for it.
+func (t Tiler) reduce(ctx context.Context, img *image.RGBA, lw, lh int) (*image.RGBA, error) {
+	m := t.Interpolation
+	if itp, _ := parseInterpolation(m); itp.check(img) != nil {
+		m = NearestNeighbor
+	}
+	return run(ctx, t.Pool, []Operation{Resize{Width: lw, Height: lh, Interpolation: m}}, img)
+}
+
+// writeTiles encodes the tiles of img and writes them with the workers of t.
+func (t Tiler) writeTiles(ctx context.Context, img *image.RGBA, tiles []tile) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := t.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	queue := make(chan tile)
+	var once sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := new(bytes.Buffer)
+			for tl := range queue {
+				b.Reset()
+				if err := t.writeTile(b, img, tl); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+	for _, tl := range tiles {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- tl
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// writeTile encodes the area of img of tl into b, and writes it to the file of tl.
+func (t Tiler) writeTile(b *bytes.Buffer, img *image.RGBA, tl tile) error {
+	dst := getBuffer(tl.rect.Dx(), tl.rect.Dy())
+	defer putBuffer(dst)
+	crop(dst, img, tl.rect.Min)
+	if err := t.output().Encode(b, dst); err != nil {
+		return err
+	}
+	return writeFile(tl.path, b.Bytes())
+}
+
+// writeDZI writes the Deep Zoom descriptor of an image of size w x h.
+func (t Tiler) writeDZI(p string, w, h int) error {
+	type size struct {
+		Width  int `xml:"Width,attr"`
+		Height int `xml:"Height,attr"`
+	}
+	b, err := xml.MarshalIndent(struct {
+		XMLName  xml.Name `xml:"http://schemas.microsoft.com/deepzoom/2008 Image"`
+		TileSize int      `xml:"TileSize,attr"`
+		Overlap  int      `xml:"Overlap,attr"`
+		Format   string   `xml:"Format,attr"`
+		Size     size     `xml:"Size"`
+	}{TileSize: t.tileSize(), Overlap: t.Overlap, Format: t.ext(), Size: size{w, h}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(p, append([]byte(xml.Header), b...))
+}
+
+// writeInfo writes the info.json of the IIIF image named name, of size w x h.
+func (t Tiler) writeInfo(p, name string, w, h int, scaleFactors []int) error {
+	id := name
+	if t.BaseURL != "" {
+		id = t.BaseURL + "/" + name
+	}
+	info := newIIIFInfo(id, "level0", w, h)
+	info.Tiles = []iiifTiles{{t.tileSize(), t.tileSize(), scaleFactors}}
+	// jpg is the default format of level 0, so other formats are listed as extra ones
+	info.ExtraFormats = extraFormats(t.ext())
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(p, b)
+}
+
+func extraFormats(ext string) []string {
+	if ext == "jpg" {
+		return nil
+	}
+	return []string{ext}
+}
+
+// ceilDiv returns a / b rounded up.
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
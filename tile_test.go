@@ -0,0 +1,128 @@
+package gato
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// decodeTileSize returns the size of the image file at p.
+func decodeTileSize(t *testing.T, p string) image.Point {
+	t.Helper()
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatalf("got no tile %s", p)
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	assertError(t, err, nil)
+	return image.Pt(cfg.Width, cfg.Height)
+}
+
+func TestTiler(t *testing.T) {
+	src := newGradientImage(300, 200)
+
+	t.Run("write the Deep Zoom layout", func(t *testing.T) {
+		dir := t.TempDir()
+		err := Tiler{TileSize: 128, Overlap: 1}.Write(context.Background(), src, dir, "scan")
+		assertError(t, err, nil)
+
+		dzi, err := os.ReadFile(filepath.Join(dir, "scan.dzi"))
+		assertError(t, err, nil)
+		for _, want := range []string{
+			`<Image xmlns="http://schemas.microsoft.com/deepzoom/2008" TileSize="128" Overlap="1" Format="jpg">`,
+			`<Size Width="300" Height="200"></Size>`,
+		} {
+			if !strings.Contains(string(dzi), want) {
+				t.Errorf("got %s, want it to contain %s", dzi, want)
+			}
+		}
+
+		cases := []struct {
+			name string
+			want image.Point
+		}{
+			{"9/0_0.jpg", image.Pt(129, 129)},
+			{"9/1_0.jpg", image.Pt(130, 129)},
+			{"9/2_1.jpg", image.Pt(45, 73)},
+			{"8/1_0.jpg", image.Pt(23, 100)},
+			{"0/0_0.jpg", image.Pt(1, 1)},
+		}
+		for _, c := range cases {
+			if got := decodeTileSize(t, filepath.Join(dir, "scan_files", filepath.FromSlash(c.name))); got != c.want {
+				t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+			}
+		}
+		levels, _ := os.ReadDir(filepath.Join(dir, "scan_files"))
+		assertInt(t, len(levels), 10)
+	})
+
+	t.Run("write the IIIF level 0 layout", func(t *testing.T) {
+		dir := t.TempDir()
+		err := Tiler{Layout: LayoutIIIF, TileSize: 128, Output: Output{Format: FormatPNG}, BaseURL: "https://example.com/iiif"}.Write(context.Background(), src, dir, "scan")
+		assertError(t, err, nil)
+
+		b, err := os.ReadFile(filepath.Join(dir, "scan", "info.json"))
+		assertError(t, err, nil)
+		var info struct {
+			ID      string `json:"id"`
+			Profile string `json:"profile"`
+			Width   int    `json:"width"`
+			Tiles   []struct {
+				Width        int   `json:"width"`
+				ScaleFactors []int `json:"scaleFactors"`
+			} `json:"tiles"`
+			ExtraFormats []string `json:"extraFormats"`
+		}
+		assertError(t, json.Unmarshal(b, &info), nil)
+		assertString(t, info.ID, "https://example.com/iiif/scan")
+		assertString(t, info.Profile, "level0")
+		assertInt(t, info.Width, 300)
+		assertInt(t, info.Tiles[0].Width, 128)
+		sf, _ := json.Marshal(info.Tiles[0].ScaleFactors)
+		assertString(t, string(sf), "[1,2,4]")
+		assertString(t, strings.Join(info.ExtraFormats, ","), "png")
+
+		cases := []struct {
+			path string
+			want image.Point
+		}{
+			{"0,0,128,128/128,128/0/default.png", image.Pt(128, 128)},
+			{"256,128,44,72/44,72/0/default.png", image.Pt(44, 72)},
+			{"256,0,44,200/22,100/0/default.png", image.Pt(22, 100)},
+			{"0,0,300,200/75,50/0/default.png", image.Pt(75, 50)},
+		}
+		for _, c := range cases {
+			if got := decodeTileSize(t, filepath.Join(dir, "scan", filepath.FromSlash(c.path))); got != c.want {
+				t.Errorf("%s: got %v, want %v", c.path, got, c.want)
+			}
+		}
+	})
+
+	t.Run("return error when the tiler is invalid", func(t *testing.T) {
+		cases := []struct {
+			tiler Tiler
+			want  error
+		}{
+			{Tiler{Layout: "zoomify"}, ErrInvalidLayout},
+			{Tiler{TileSize: -1}, ErrInvalidTileSize},
+			{Tiler{Layout: LayoutIIIF, Overlap: 1}, ErrInvalidTileSize},
+			{Tiler{Output: Output{Format: "gif"}}, ErrInvalidFormat},
+		}
+		for _, c := range cases {
+			err := c.tiler.Write(context.Background(), src, t.TempDir(), "scan")
+			assertError(t, err, c.want)
+		}
+	})
+
+	t.Run("stop when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := Tiler{}.Write(ctx, src, t.TempDir(), "scan")
+		assertError(t, err, context.Canceled)
+	})
+}
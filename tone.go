@@ -0,0 +1,340 @@
+package gato
+
+import (
+	"errors"
+	"image"
+	"math"
+)
+
+var (
+	ErrInvalidTone       = errors.New("invalid tone: brightness and contrast must be between -1 and 1, and gamma must be greater than 0")
+	ErrInvalidInputRange = errors.New("invalid input range: the black point must be less than the white point")
+	ErrInvalidCurve      = errors.New("invalid curve: a curve needs at least two points whose inputs are increasing")
+)
+
+// toneLUT returns the lookup table mapping every 8-bit value v to f(v), rounded and clamped.
+func toneLUT(f func(v float64) float64) *[256]uint8 {
+	var lut [256]uint8
+	for i := range lut {
+		lut[i] = clamp(f(float64(i)))
+	}
+	return &lut
+}
+
+// applyLUT returns src whose colors are mapped through lut, in a buffer taken from s.
+// The lookup applies to straight colors, so that transparency does not change the tone, and alpha is kept as it is.
+func applyLUT(s *scratch, src *image.RGBA, lut *[256]uint8) (*image.RGBA, error) {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := s.get(w, h)
+	err := s.parallel(h, func(start, end int) {
+		for y := start; y < end; y++ {
+			sp := src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y):]
+			dp := dst.Pix[dst.PixOffset(0, y):]
+			for i := 0; i < 4*w; i += 4 {
+				switch a := sp[i+3]; a {
+				case 0:
+					// transparent pixels are left transparent
+				case 255:
+					dp[i], dp[i+1], dp[i+2], dp[i+3] = lut[sp[i]], lut[sp[i+1]], lut[sp[i+2]], 255
+				default:
+					for k := range 3 {
+						c := min(int(sp[i+k])*255/int(a), 255)
+						dp[i+k] = uint8((int(lut[c])*int(a) + 127) / 255)
+					}
+					dp[i+3] = a
+				}
+			}
+		}
+	})
+	if err != nil {
+		s.put(dst)
+		return nil, err
+	}
+	return dst, nil
+}
+
+// BrightnessContrast is an operation that adjusts the brightness and the contrast of an image.
+type BrightnessContrast struct {
+	// Brightness shifts every value by Brightness times 255, from -1 (black) to 1 (white).
+	Brightness float64 `json:"brightness,omitempty"`
+	// Contrast scales values around the middle gray, from -1 (flat gray) to 1 (black and white only).
+	Contrast float64 `json:"contrast,omitempty"`
+}
+
+func (bc BrightnessContrast) kind() string {
+	return "brightness_contrast"
+}
+
+func (bc BrightnessContrast) validate() error {
+	if !(bc.Brightness >= -1 && bc.Brightness <= 1) {
+		return fieldError("brightness", ErrInvalidTone)
+	}
+	if !(bc.Contrast >= -1 && bc.Contrast <= 1) {
+		return fieldError("contrast", ErrInvalidTone)
+	}
+	return nil
+}
+
+func (bc BrightnessContrast) lut() *[256]uint8 {
+	// the slope of the contrast goes from flat to vertical as the angle goes from 0 to 90 degrees
+	slope := math.Tan((bc.Contrast + 1) * math.Pi / 4)
+	return toneLUT(func(v float64) float64 {
+		return (v-127.5)*slope + 127.5 + bc.Brightness*255
+	})
+}
+
+func (bc BrightnessContrast) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	return applyLUT(s, src, bc.lut())
+}
+
+// Apply returns a new image of the source adjusted following the operation.
+func (bc BrightnessContrast) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := bc.validate(); err != nil {
+		return nil, err
+	}
+	return bc.apply(&scratch{}, src)
+}
+
+func (bc BrightnessContrast) MarshalJSON() ([]byte, error) {
+	type alias BrightnessContrast
+	return marshalOperation(bc.kind(), alias(bc))
+}
+
+func (bc *BrightnessContrast) UnmarshalJSON(data []byte) error {
+	type alias BrightnessContrast
+	v := struct {
+		Op string `json:"op"`
+		*alias
+	}{alias: (*alias)(bc)}
+	return decodeStrict(data, &v)
+}
+
+// Gamma is an operation that applies a gamma correction to an image, mapping every value v from 0 to 1 to v^(1/Gamma).
+// Gammas greater than 1 brighten the mid-tones, and gammas less than 1 darken them.
+type Gamma struct {
+	Gamma float64 `json:"gamma"`
+}
+
+func (g Gamma) kind() string {
+	return "gamma"
+}
+
+func (g Gamma) validate() error {
+	if !(g.Gamma > 0) || math.IsInf(g.Gamma, 0) {
+		return fieldError("gamma", ErrInvalidTone)
+	}
+	return nil
+}
+
+func (g Gamma) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	return applyLUT(s, src, toneLUT(func(v float64) float64 {
+		return 255 * math.Pow(v/255, 1/g.Gamma)
+	}))
+}
+
+// Apply returns a new image of the source corrected following the operation.
+func (g Gamma) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+	return g.apply(&scratch{}, src)
+}
+
+func (g Gamma) MarshalJSON() ([]byte, error) {
+	type alias Gamma
+	return marshalOperation(g.kind(), alias(g))
+}
+
+func (g *Gamma) UnmarshalJSON(data []byte) error {
+	type alias Gamma
+	v := struct {
+		Op string `json:"op"`
+		*alias
+	}{alias: (*alias)(g)}
+	return decodeStrict(data, &v)
+}
+
+// Levels is an operation that maps the input range [InBlack, InWhite] to the output range [OutBlack, OutWhite],
+// with a gamma correction in between. Values outside of the input range are clipped.
+type Levels struct {
+	InBlack uint8 `json:"in_black,omitempty"`
+	// InWhite is the input white point. If it is not set, it defaults to 255.
+	InWhite uint8 `json:"in_white,omitempty"`
+	// Gamma is the gamma correction of the mid-tones, as with the Gamma operation. If it is not set, it defaults to 1.
+	Gamma    float64 `json:"gamma,omitempty"`
+	OutBlack uint8   `json:"out_black,omitempty"`
+	// OutWhite is the output white point. If it is not set, it defaults to 255.
+	// It is a pointer so that 0 can be set, e.g. with an OutBlack of 255 to invert the image.
+	OutWhite *uint8 `json:"out_white,omitempty"`
+}
+
+func (l Levels) kind() string {
+	return "levels"
+}
+
+// defaults returns l with its unset input white point and gamma set to their defaults.
+func (l Levels) defaults() Levels {
+	if l.InWhite == 0 {
+		l.InWhite = 255
+	}
+	if l.Gamma == 0 {
+		l.Gamma = 1
+	}
+	return l
+}
+
+func (l Levels) validate() error {
+	d := l.defaults()
+	if d.InBlack >= d.InWhite {
+		return fieldError("in_black", ErrInvalidInputRange)
+	}
+	if !(d.Gamma > 0) || math.IsInf(d.Gamma, 0) {
+		return fieldError("gamma", ErrInvalidTone)
+	}
+	return nil
+}
+
+func (l Levels) lut() *[256]uint8 {
+	d := l.defaults()
+	inB, inW := float64(d.InBlack), float64(d.InWhite)
+	outB, outW := float64(d.OutBlack), 255.0
+	if d.OutWhite != nil {
+		outW = float64(*d.OutWhite)
+	}
+	return toneLUT(func(v float64) float64 {
+		t := min(max((v-inB)/(inW-inB), 0), 1)
+		return outB + math.Pow(t, 1/d.Gamma)*(outW-outB)
+	})
+}
+
+func (l Levels) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	return applyLUT(s, src, l.lut())
+}
+
+// Apply returns a new image of the source adjusted following the operation.
+func (l Levels) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := l.validate(); err != nil {
+		return nil, err
+	}
+	return l.apply(&scratch{}, src)
+}
+
+func (l Levels) MarshalJSON() ([]byte, error) {
+	type alias Levels
+	return marshalOperation(l.kind(), alias(l))
+}
+
+func (l *Levels) UnmarshalJSON(data []byte) error {
+	type alias Levels
+	v := struct {
+		Op string `json:"op"`
+		*alias
+	}{alias: (*alias)(l)}
+	return decodeStrict(data, &v)
+}
+
+// Curves is an operation that maps values through a tone curve passing through Points.
+// The curve between the points is a monotone cubic spline, which never overshoots them, e.g. to bend the mid-tones
+// without clipping. Before the first point and after the last one, the curve is flat.
+type Curves struct {
+	// Points are the (input, output) pairs of the curve, by increasing input, e.g. [[0, 0], [128, 160], [255, 255]].
+	Points [][2]uint8 `json:"points"`
+}
+
+func (c Curves) kind() string {
+	return "curves"
+}
+
+func (c Curves) validate() error {
+	if len(c.Points) < 2 {
+		return fieldError("points", ErrInvalidCurve)
+	}
+	for i := 1; i < len(c.Points); i++ {
+		if c.Points[i][0] <= c.Points[i-1][0] {
+			return fieldError("points", ErrInvalidCurve)
+		}
+	}
+	return nil
+}
+
+func (c Curves) lut() *[256]uint8 {
+	xs := make([]float64, len(c.Points))
+	ys := make([]float64, len(c.Points))
+	for i, p := range c.Points {
+		xs[i], ys[i] = float64(p[0]), float64(p[1])
+	}
+	return toneLUT(monotoneSpline(xs, ys))
+}
+
+func (c Curves) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	return applyLUT(s, src, c.lut())
+}
+
+// Apply returns a new image of the source mapped following the operation.
+func (c Curves) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c.apply(&scratch{}, src)
+}
+
+func (c Curves) MarshalJSON() ([]byte, error) {
+	type alias Curves
+	return marshalOperation(c.kind(), alias(c))
+}
+
+func (c *Curves) UnmarshalJSON(data []byte) error {
+	type alias Curves
+	v := struct {
+		Op string `json:"op"`
+		*alias
+	}{alias: (*alias)(c)}
+	return decodeStrict(data, &v)
+}
+
+// monotoneSpline returns the cubic Hermite spline through the points (xs[i], ys[i]) whose tangents are limited
+// following Fritsch and Carlson, so that it is monotone wherever the points are. xs must be increasing.
+func monotoneSpline(xs, ys []float64) func(x float64) float64 {
+	n := len(xs)
+	slopes := make([]float64, n-1)
+	for i := range slopes {
+		slopes[i] = (ys[i+1] - ys[i]) / (xs[i+1] - xs[i])
+	}
+
+	tangents := make([]float64, n)
+	tangents[0], tangents[n-1] = slopes[0], slopes[n-2]
+	for i := 1; i < n-1; i++ {
+		// extrema and flat segments get a flat tangent
+		if slopes[i-1]*slopes[i] > 0 {
+			tangents[i] = (slopes[i-1] + slopes[i]) / 2
+		}
+	}
+	for i, d := range slopes {
+		if d == 0 {
+			tangents[i], tangents[i+1] = 0, 0
+			continue
+		}
+		a, b := tangents[i]/d, tangents[i+1]/d
+		if h := a*a + b*b; h > 9 {
+			t := 3 / math.Sqrt(h)
+			tangents[i], tangents[i+1] = t*a*d, t*b*d
+		}
+	}
+
+	return func(x float64) float64 {
+		switch {
+		case x <= xs[0]:
+			return ys[0]
+		case x >= xs[n-1]:
+			return ys[n-1]
+		}
+		i := 0
+		for x > xs[i+1] {
+			i++
+		}
+		h := xs[i+1] - xs[i]
+		t := (x - xs[i]) / h
+		t2, t3 := t*t, t*t*t
+		return (2*t3-3*t2+1)*ys[i] + (t3-2*t2+t)*h*tangents[i] + (-2*t3+3*t2)*ys[i+1] + (t3-t2)*h*tangents[i+1]
+	}
+}
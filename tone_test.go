@@ -0,0 +1,148 @@
+package gato
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// newRampImage returns an opaque gray image of 256x1 whose pixel x has the value x.
+func newRampImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 1))
+	for x := range 256 {
+		img.SetRGBA(x, 0, color.RGBA{uint8(x), uint8(x), uint8(x), 255})
+	}
+	return img
+}
+
+func TestApplyLUT(t *testing.T) {
+	t.Run("map straight colors and keep alpha", func(t *testing.T) {
+		src := image.NewRGBA(image.Rect(0, 0, 3, 1))
+		src.SetRGBA(0, 0, color.RGBA{100, 0, 0, 255})
+		// straight (200, 0, 0) at half transparency
+		src.SetRGBA(1, 0, color.RGBA{100, 0, 0, 128})
+		var invert [256]uint8
+		for i := range invert {
+			invert[i] = uint8(255 - i)
+		}
+		dst, err := applyLUT(&scratch{}, src, &invert)
+		assertError(t, err, nil)
+		if got := dst.RGBAAt(0, 0); got != (color.RGBA{155, 255, 255, 255}) {
+			t.Errorf("got %v for the opaque pixel", got)
+		}
+		if got := dst.RGBAAt(1, 0); got != (color.RGBA{28, 128, 128, 128}) {
+			t.Errorf("got %v for the translucent pixel", got)
+		}
+		if got := dst.RGBAAt(2, 0); got != (color.RGBA{}) {
+			t.Errorf("got %v for the transparent pixel", got)
+		}
+	})
+}
+
+func TestBrightnessContrast(t *testing.T) {
+	cases := []struct {
+		op      BrightnessContrast
+		in, out uint8
+	}{
+		{BrightnessContrast{}, 77, 77},
+		{BrightnessContrast{Brightness: 0.2}, 100, 151},
+		{BrightnessContrast{Brightness: -1}, 200, 0},
+		{BrightnessContrast{Contrast: 1.0 / 3}, 227, 255},
+		{BrightnessContrast{Contrast: 1.0 / 3}, 100, 80},
+		{BrightnessContrast{Contrast: -1}, 10, 128},
+	}
+	for _, c := range cases {
+		if got := c.op.lut()[c.in]; got != c.out {
+			t.Errorf("%+v: got %d for %d, want %d", c.op, got, c.in, c.out)
+		}
+	}
+
+	_, err := BrightnessContrast{Contrast: 1.5}.Apply(newRampImage())
+	assertValidationError(t, err, -1, "contrast", ErrInvalidTone)
+}
+
+func TestGamma(t *testing.T) {
+	dst, err := Gamma{Gamma: 2.2}.Apply(newRampImage())
+	assertError(t, err, nil)
+	assertInt(t, int(dst.RGBAAt(0, 0).R), 0)
+	assertInt(t, int(dst.RGBAAt(255, 0).R), 255)
+	assertInt(t, int(dst.RGBAAt(128, 0).R), 186)
+
+	_, err = Gamma{}.Apply(newRampImage())
+	assertError(t, err, ErrInvalidTone)
+}
+
+func TestLevels(t *testing.T) {
+	t.Run("stretch the input range to the output range", func(t *testing.T) {
+		lut := Levels{InBlack: 20, InWhite: 220}.lut()
+		assertInt(t, int(lut[10]), 0)
+		assertInt(t, int(lut[20]), 0)
+		assertInt(t, int(lut[120]), 128)
+		assertInt(t, int(lut[220]), 255)
+		assertInt(t, int(lut[240]), 255)
+	})
+
+	t.Run("compress into the output range with a gamma", func(t *testing.T) {
+		outWhite := uint8(150)
+		lut := Levels{Gamma: 2, OutBlack: 50, OutWhite: &outWhite}.lut()
+		assertInt(t, int(lut[0]), 50)
+		assertInt(t, int(lut[255]), 150)
+		// sqrt(0.25) is halfway between the output points
+		assertInt(t, int(lut[64]), 100)
+	})
+
+	t.Run("invert with an output white point of 0", func(t *testing.T) {
+		var l Levels
+		err := json.Unmarshal([]byte(`{"op":"levels","out_black":255,"out_white":0}`), &l)
+		assertError(t, err, nil)
+		lut := l.lut()
+		assertInt(t, int(lut[0]), 255)
+		assertInt(t, int(lut[55]), 200)
+		assertInt(t, int(lut[255]), 0)
+		// an unset white point still defaults to 255
+		assertInt(t, int(Levels{}.lut()[55]), 55)
+	})
+
+	t.Run("return error when the black point is not below the white point", func(t *testing.T) {
+		_, err := Levels{InBlack: 200, InWhite: 100}.Apply(newRampImage())
+		assertValidationError(t, err, -1, "in_black", ErrInvalidInputRange)
+	})
+}
+
+func TestCurves(t *testing.T) {
+	t.Run("pass through the points without overshooting them", func(t *testing.T) {
+		c := Curves{Points: [][2]uint8{{0, 0}, {64, 100}, {128, 110}, {255, 255}}}
+		lut := c.lut()
+		for _, p := range c.Points {
+			assertInt(t, int(lut[p[0]]), int(p[1]))
+		}
+		for i := 1; i < 256; i++ {
+			if lut[i] < lut[i-1] {
+				t.Fatalf("got %d at %d after %d, want a monotone curve", lut[i], i, lut[i-1])
+			}
+		}
+	})
+
+	t.Run("stay flat between equal points and past the ends", func(t *testing.T) {
+		lut := Curves{Points: [][2]uint8{{50, 40}, {100, 40}, {200, 220}}}.lut()
+		assertInt(t, int(lut[0]), 40)
+		assertInt(t, int(lut[75]), 40)
+		assertInt(t, int(lut[255]), 220)
+	})
+
+	t.Run("keep the identity as it is", func(t *testing.T) {
+		dst, err := Curves{Points: [][2]uint8{{0, 0}, {255, 255}}}.Apply(newRampImage())
+		assertError(t, err, nil)
+		for x := range 256 {
+			assertInt(t, int(dst.RGBAAt(x, 0).R), x)
+		}
+	})
+
+	t.Run("return error when the points are invalid", func(t *testing.T) {
+		_, err := Curves{Points: [][2]uint8{{0, 0}}}.Apply(newRampImage())
+		assertError(t, err, ErrInvalidCurve)
+		_, err = Curves{Points: [][2]uint8{{0, 0}, {100, 50}, {100, 60}}}.Apply(newRampImage())
+		assertError(t, err, ErrInvalidCurve)
+	})
+}
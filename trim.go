@@ -0,0 +1,159 @@
+package gato
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+var ErrInvalidTolerance = errors.New("invalid tolerance: tolerance must be between 0 and 255")
+
+// Trim is an operation that removes borders of near-uniform color around an image.
+type Trim struct {
+	// Color is the reference color of the borders. If it is not set, the color of the top-left corner pixel is used.
+	Color *color.RGBA `json:"-"`
+	// Tolerance is the largest difference on any color channel (RGBA) for a pixel to still count as border.
+	Tolerance int `json:"tolerance,omitempty"`
+}
+
+func (t Trim) kind() string {
+	return "trim"
+}
+
+func (t Trim) validate() error {
+	if t.Tolerance < 0 || t.Tolerance > 255 {
+		return fieldError("tolerance", ErrInvalidTolerance)
+	}
+	return nil
+}
+
+// Bounds returns the bounding box of the content of src, i.e. the smallest rectangle containing every pixel that is not border.
+// The rectangle is relative to the top-left corner of src, as Crop expects it.
+// If src consists of border only, it returns an empty rectangle.
+func (t Trim) Bounds(src *image.RGBA) (image.Rectangle, error) {
+	if err := t.validate(); err != nil {
+		return image.Rectangle{}, err
+	}
+
+	srcW := src.Bounds().Dx()
+	srcH := src.Bounds().Dy()
+	if srcW == 0 || srcH == 0 {
+		return image.Rectangle{}, nil
+	}
+
+	origin := src.Rect.Min
+	ref := src.RGBAAt(origin.X, origin.Y)
+	if t.Color != nil {
+		ref = *t.Color
+	}
+
+	isBorder := func(x, y int) bool {
+		c := src.RGBAAt(origin.X+x, origin.Y+y)
+		return absDiff(c.R, ref.R) <= t.Tolerance &&
+			absDiff(c.G, ref.G) <= t.Tolerance &&
+			absDiff(c.B, ref.B) <= t.Tolerance &&
+			absDiff(c.A, ref.A) <= t.Tolerance
+	}
+	isBorderRow := func(y int) bool {
+		for x := range srcW {
+			if !isBorder(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+	isBorderColumn := func(x, top, bottom int) bool {
+		for y := top; y < bottom; y++ {
+			if !isBorder(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+
+	top := 0
+	for top < srcH && isBorderRow(top) {
+		top++
+	}
+	if top == srcH {
+		return image.Rectangle{}, nil
+	}
+	bottom := srcH
+	for isBorderRow(bottom - 1) {
+		bottom--
+	}
+	left := 0
+	for isBorderColumn(left, top, bottom) {
+		left++
+	}
+	right := srcW
+	for isBorderColumn(right-1, top, bottom) {
+		right--
+	}
+
+	return image.Rect(left, top, right, bottom), nil
+}
+
+// outputSize depends on the borders of the image, so it cannot be told up front.
+func (t Trim) outputSize(size image.Point) (image.Point, bool) {
+	return image.Point{}, false
+}
+
+func (t Trim) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	r, err := t.Bounds(src)
+	if err != nil {
+		return nil, err
+	}
+	if r.Empty() {
+		r = src.Bounds().Sub(src.Bounds().Min)
+	}
+	dst := s.get(r.Dx(), r.Dy())
+	crop(dst, src, r.Min)
+	return dst, nil
+}
+
+// Apply returns a new image of the content of src with its borders removed.
+// If src consists of border only, it returns a copy of src.
+func (t Trim) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	return t.apply(&scratch{}, src)
+}
+
+// absDiff returns |a - b|
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+func (t Trim) MarshalJSON() ([]byte, error) {
+	type alias Trim
+	return marshalOperation(t.kind(), struct {
+		alias
+		Color *hexColor `json:"color,omitempty"`
+	}{alias(t), (*hexColor)(t.Color)})
+}
+
+func (t *Trim) UnmarshalJSON(data []byte) error {
+	type alias Trim
+	v := struct {
+		Op string `json:"op"`
+		*alias
+		Color string `json:"color"`
+	}{alias: (*alias)(t)}
+	if err := decodeStrict(data, &v); err != nil {
+		return err
+	}
+	t.Color = nil
+	if v.Color != "" {
+		c, err := decodeColor("color", v.Color)
+		if err != nil {
+			return err
+		}
+		t.Color = &c
+	}
+	return nil
+}
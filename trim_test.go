@@ -0,0 +1,97 @@
+package gato
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func newBorderedImage(border color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 8))
+	for y := range 8 {
+		for x := range 10 {
+			img.SetRGBA(x, y, border)
+		}
+	}
+	// content spans (2, 3)-(7, 5)
+	for y := 3; y < 5; y++ {
+		for x := 2; x < 7; x++ {
+			img.SetRGBA(x, y, color.RGBA{0, 0, 0, 255})
+		}
+	}
+	return img
+}
+
+func TestTrim(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 255}
+
+	t.Run("detect the bounding box using the corner pixel", func(t *testing.T) {
+		r, err := Trim{}.Bounds(newBorderedImage(white))
+		assertError(t, err, nil)
+		if want := image.Rect(2, 3, 7, 5); r != want {
+			t.Errorf("got %v, want %v", r, want)
+		}
+	})
+
+	t.Run("tolerate near-uniform borders", func(t *testing.T) {
+		src := newBorderedImage(white)
+		src.SetRGBA(0, 7, color.RGBA{250, 250, 250, 255})
+		r, _ := Trim{Tolerance: 10}.Bounds(src)
+		if want := image.Rect(2, 3, 7, 5); r != want {
+			t.Errorf("got %v, want %v", r, want)
+		}
+		r, _ = Trim{}.Bounds(src)
+		if want := image.Rect(0, 3, 7, 8); r != want {
+			t.Errorf("got %v, want %v", r, want)
+		}
+	})
+
+	t.Run("use the given reference color", func(t *testing.T) {
+		src := newBorderedImage(white)
+		src.SetRGBA(0, 0, color.RGBA{255, 0, 0, 255})
+		r, _ := Trim{Color: &white}.Bounds(src)
+		if want := image.Rect(0, 0, 7, 5); r != want {
+			t.Errorf("got %v, want %v", r, want)
+		}
+	})
+
+	t.Run("crop the image to the bounding box", func(t *testing.T) {
+		dst, _ := Trim{}.Apply(newBorderedImage(white))
+		assertInt(t, dst.Bounds().Dx(), 5)
+		assertInt(t, dst.Bounds().Dy(), 2)
+	})
+
+	t.Run("detect the bounding box of a sub-image", func(t *testing.T) {
+		src := newBorderedImage(white)
+		// the red corner is out of the sub-image, so that its own corner is the reference
+		src.SetRGBA(0, 0, color.RGBA{255, 0, 0, 255})
+		sub := src.SubImage(image.Rect(1, 1, 9, 7)).(*image.RGBA)
+		r, err := Trim{}.Bounds(sub)
+		assertError(t, err, nil)
+		if want := image.Rect(1, 2, 6, 4); r != want {
+			t.Errorf("got %v, want %v", r, want)
+		}
+		dst, _ := Trim{}.Apply(sub)
+		assertInt(t, dst.Bounds().Dx(), 5)
+		assertInt(t, dst.Bounds().Dy(), 2)
+		if got := dst.RGBAAt(0, 0); got != (color.RGBA{0, 0, 0, 255}) {
+			t.Errorf("got %v, want the content", got)
+		}
+	})
+
+	t.Run("keep the image when it is border only", func(t *testing.T) {
+		src := newGradientImage(4, 4)
+		r, _ := Trim{Tolerance: 255}.Bounds(src)
+		if !r.Empty() {
+			t.Errorf("got %v, want empty rectangle", r)
+		}
+		dst, _ := Trim{Tolerance: 255}.Apply(src)
+		assertInt(t, dst.Bounds().Dx(), 4)
+		assertInt(t, dst.Bounds().Dy(), 4)
+	})
+
+	t.Run("return error when tolerance is out of range", func(t *testing.T) {
+		_, err := Trim{Tolerance: 256}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidTolerance)
+	})
+}
@@ -0,0 +1,249 @@
+package gato
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+var (
+	ErrSingularMatrix = errors.New("singular matrix: the transformation cannot be inverted")
+	ErrDegenerateQuad = errors.New("degenerate quad: no homography maps the given corners")
+)
+
+// Affine is a 2x3 affine transformation matrix in row-major order.
+// A point (x, y) is mapped to (a[0]*x + a[1]*y + a[2], a[3]*x + a[4]*y + a[5]).
+type Affine [6]float64
+
+// Homography returns the 3x3 homography equivalent to a.
+func (a Affine) Homography() Homography {
+	return Homography{
+		a[0], a[1], a[2],
+		a[3], a[4], a[5],
+		0, 0, 1,
+	}
+}
+
+// Homography is a 3x3 perspective transformation matrix in row-major order.
+// A point (x, y) is mapped to ((h[0]*x + h[1]*y + h[2]) / w, (h[3]*x + h[4]*y + h[5]) / w) where w = h[6]*x + h[7]*y + h[8].
+type Homography [9]float64
+
+// IdentityHomography is the homography that maps every point to itself.
+var IdentityHomography = Homography{1, 0, 0, 0, 1, 0, 0, 0, 1}
+
+// Apply maps the point (x, y) through h.
+// ok is false when the point is mapped to infinity.
+func (h Homography) Apply(x, y float64) (tx, ty float64, ok bool) {
+	w := h[6]*x + h[7]*y + h[8]
+	if w == 0 {
+		return 0, 0, false
+	}
+	return (h[0]*x + h[1]*y + h[2]) / w, (h[3]*x + h[4]*y + h[5]) / w, true
+}
+
+// Invert returns the inverse of h.
+// If h is singular, it returns an error ErrSingularMatrix.
+func (h Homography) Invert() (Homography, error) {
+	// cofactors of h
+	c00 := h[4]*h[8] - h[5]*h[7]
+	c01 := h[5]*h[6] - h[3]*h[8]
+	c02 := h[3]*h[7] - h[4]*h[6]
+
+	det := h[0]*c00 + h[1]*c01 + h[2]*c02
+	if math.Abs(det) < 1e-12 {
+		return Homography{}, ErrSingularMatrix
+	}
+
+	// inverse = adjugate / det, where the adjugate is the transposed cofactor matrix
+	return Homography{
+		c00 / det, (h[2]*h[7] - h[1]*h[8]) / det, (h[1]*h[5] - h[2]*h[4]) / det,
+		c01 / det, (h[0]*h[8] - h[2]*h[6]) / det, (h[2]*h[3] - h[0]*h[5]) / det,
+		c02 / det, (h[1]*h[6] - h[0]*h[7]) / det, (h[0]*h[4] - h[1]*h[3]) / det,
+	}, nil
+}
+
+// Quad is a quadrilateral given by its four corners as (x, y) pairs, in the order top-left, top-right, bottom-right, bottom-left.
+type Quad [4][2]float64
+
+// NewHomography returns the homography that maps each corner of src to the corresponding corner of dst.
+// If three of the corners are collinear, it returns an error ErrDegenerateQuad.
+func NewHomography(src, dst Quad) (Homography, error) {
+	// each correspondence (x, y) -> (u, v) gives two linear equations in the eight unknowns h[0]..h[7] (h[8] = 1)
+	//   h0*x + h1*y + h2 - h6*x*u - h7*y*u = u
+	//   h3*x + h4*y + h5 - h6*x*v - h7*y*v = v
+	var m [8][9]float64
+	for i := range 4 {
+		x, y := src[i][0], src[i][1]
+		u, v := dst[i][0], dst[i][1]
+		m[2*i] = [9]float64{x, y, 1, 0, 0, 0, -x * u, -y * u, u}
+		m[2*i+1] = [9]float64{0, 0, 0, x, y, 1, -x * v, -y * v, v}
+	}
+
+	// gaussian elimination with partial pivoting
+	for col := range 8 {
+		pivot := col
+		for row := col + 1; row < 8; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return Homography{}, ErrDegenerateQuad
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+
+		for row := range 8 {
+			if row == col {
+				continue
+			}
+			f := m[row][col] / m[col][col]
+			for k := col; k < 9; k++ {
+				m[row][k] -= f * m[col][k]
+			}
+		}
+	}
+
+	var h Homography
+	for i := range 8 {
+		h[i] = m[i][8] / m[i][i]
+	}
+	h[8] = 1
+
+	return h, nil
+}
+
+// QuadToRect returns the homography that maps the corners of q onto the rectangle (0, 0)-(w, h).
+// It is typically used with Warp to straighten a photographed page whose corners are q.
+func QuadToRect(q Quad, w, h int) (Homography, error) {
+	fw, fh := float64(w), float64(h)
+	return NewHomography(q, Quad{{0, 0}, {fw, 0}, {fw, fh}, {0, fh}})
+}
+
+// Warp is an operation that resamples an image through a perspective (or affine) transformation.
+type Warp struct {
+	// Matrix maps source coordinates to destination coordinates.
+	// Use Affine.Homography to warp with an affine matrix.
+	Matrix Homography `json:"matrix"`
+	// Width and Height are the dimensions of the output image. If they are not set, the dimensions of the source image are used.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// Interpolation is the method used to sample the source image. If it is not set, it defaults to Bilinear.
+	Interpolation Interpolation `json:"interpolation,omitempty"`
+	// Background is the color of the destination pixels that map outside of the source image. The zero value is transparent.
+	Background color.RGBA `json:"-"`
+}
+
+func (wp Warp) kind() string {
+	return "warp"
+}
+
+func (wp Warp) validate() error {
+	if wp.Width < 0 {
+		return fieldError("width", ErrInvalidDimension)
+	}
+	if wp.Height < 0 {
+		return fieldError("height", ErrInvalidDimension)
+	}
+	if _, err := parseInterpolation(wp.Interpolation); err != nil {
+		return fieldError("interpolation", err)
+	}
+	if _, err := wp.Matrix.Invert(); err != nil {
+		return fieldError("matrix", err)
+	}
+	return nil
+}
+
+func (wp Warp) outputSize(size image.Point) (image.Point, bool) {
+	if wp.Width != 0 {
+		size.X = wp.Width
+	}
+	if wp.Height != 0 {
+		size.Y = wp.Height
+	}
+	return size, true
+}
+
+func (wp Warp) apply(s *scratch, src *image.RGBA) (*image.RGBA, error) {
+	itp, err := parseInterpolation(wp.Interpolation)
+	if err != nil {
+		return nil, err
+	}
+	if err := itp.check(src); err != nil {
+		return nil, err
+	}
+
+	// destination pixels are mapped back to the source, so the inverse is needed
+	inv, err := wp.Matrix.Invert()
+	if err != nil {
+		return nil, err
+	}
+
+	srcW := src.Bounds().Dx()
+	srcH := src.Bounds().Dy()
+	dstW := wp.Width
+	if dstW == 0 {
+		dstW = srcW
+	}
+	dstH := wp.Height
+	if dstH == 0 {
+		dstH = srcH
+	}
+	dst := s.get(dstW, dstH)
+
+	err = s.parallel(dstH, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := range dstW {
+				// map the center of the destination pixel into the source
+				u, v, ok := inv.Apply(float64(x)+0.5, float64(y)+0.5)
+				if !ok || u < 0 || v < 0 || u > float64(srcW) || v > float64(srcH) {
+					dst.SetRGBA(x, y, wp.Background)
+					continue
+				}
+
+				// interpolators place pixel centers at integer coordinates
+				dst.SetRGBA(x, y, itp.at(src, u-0.5, v-0.5))
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dst, nil
+}
+
+// Apply returns a new image of the source warped following the operation.
+// Destination pixels that map outside of the source image are filled with Background.
+func (wp Warp) Apply(src *image.RGBA) (*image.RGBA, error) {
+	if err := wp.validate(); err != nil {
+		return nil, err
+	}
+	return wp.apply(&scratch{}, src)
+}
+
+func (wp Warp) MarshalJSON() ([]byte, error) {
+	type alias Warp
+	return marshalOperation(wp.kind(), struct {
+		alias
+		Background *hexColor `json:"background,omitempty"`
+	}{alias(wp), newHexColor(wp.Background)})
+}
+
+func (wp *Warp) UnmarshalJSON(data []byte) error {
+	type alias Warp
+	v := struct {
+		Op string `json:"op"`
+		*alias
+		Background string `json:"background"`
+	}{alias: (*alias)(wp)}
+	if err := decodeStrict(data, &v); err != nil {
+		return err
+	}
+	bg, err := decodeColor("background", v.Background)
+	if err != nil {
+		return err
+	}
+	wp.Background = bg
+	return nil
+}
@@ -0,0 +1,98 @@
+package gato
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func newGradientImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), 100, 255})
+		}
+	}
+	return img
+}
+
+func TestHomography(t *testing.T) {
+	t.Run("invert a homography", func(t *testing.T) {
+		h := Homography{2, 0.5, 10, 0.1, 3, -4, 0.001, 0.002, 1}
+		inv, err := h.Invert()
+		assertError(t, err, nil)
+
+		x, y, _ := h.Apply(12, 34)
+		gotX, gotY, _ := inv.Apply(x, y)
+		if math.Abs(gotX-12) > 1e-9 || math.Abs(gotY-34) > 1e-9 {
+			t.Errorf("got (%v, %v), want (12, 34)", gotX, gotY)
+		}
+	})
+
+	t.Run("return error when the matrix is singular", func(t *testing.T) {
+		h := Homography{1, 2, 3, 2, 4, 6, 0, 0, 1}
+		_, err := h.Invert()
+		assertError(t, err, ErrSingularMatrix)
+	})
+
+	t.Run("map the corners of a quad onto a rectangle", func(t *testing.T) {
+		q := Quad{{10, 20}, {90, 5}, {100, 80}, {0, 95}}
+		h, err := QuadToRect(q, 200, 100)
+		assertError(t, err, nil)
+
+		want := Quad{{0, 0}, {200, 0}, {200, 100}, {0, 100}}
+		for i := range 4 {
+			x, y, _ := h.Apply(q[i][0], q[i][1])
+			if math.Abs(x-want[i][0]) > 1e-6 || math.Abs(y-want[i][1]) > 1e-6 {
+				t.Errorf("corner %d: got (%v, %v), want %v", i, x, y, want[i])
+			}
+		}
+	})
+
+	t.Run("return error when the quad is degenerate", func(t *testing.T) {
+		q := Quad{{0, 0}, {10, 10}, {20, 20}, {30, 30}}
+		_, err := QuadToRect(q, 100, 100)
+		assertError(t, err, ErrDegenerateQuad)
+	})
+}
+
+func TestWarp(t *testing.T) {
+	t.Run("identity warp keeps the image as is", func(t *testing.T) {
+		src := newGradientImage(8, 6)
+		for _, m := range []Interpolation{NearestNeighbor, Bilinear, Bicubic} {
+			dst, err := Warp{Matrix: IdentityHomography, Interpolation: m}.Apply(src)
+			assertError(t, err, nil)
+			for y := range 6 {
+				for x := range 8 {
+					if got, want := dst.RGBAAt(x, y), src.RGBAAt(x, y); got != want {
+						t.Fatalf("%s: at (%d, %d) got %v, want %v", m, x, y, got, want)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("translate with an affine matrix", func(t *testing.T) {
+		src := newGradientImage(8, 8)
+		dst, _ := Warp{Matrix: Affine{1, 0, 2, 0, 1, 1}.Homography(), Width: 10, Height: 10}.Apply(src)
+		assertInt(t, dst.Bounds().Dx(), 10)
+		assertInt(t, dst.Bounds().Dy(), 10)
+		if got, want := dst.RGBAAt(5, 4), src.RGBAAt(3, 3); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got := dst.RGBAAt(0, 0); got != (color.RGBA{}) {
+			t.Errorf("got %v, want transparent", got)
+		}
+	})
+
+	t.Run("return error when invalid interpolation method is provided", func(t *testing.T) {
+		_, err := Warp{Matrix: IdentityHomography, Interpolation: "full crimp"}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrInvalidInterpolation)
+	})
+
+	t.Run("return error when the matrix is singular", func(t *testing.T) {
+		_, err := Warp{}.Apply(newGradientImage(4, 4))
+		assertError(t, err, ErrSingularMatrix)
+	})
+}